@@ -0,0 +1,155 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/danielmorell/sqlxm/backends"
+)
+
+// ArchiveOldRecords moves the records of migrations applied before before from
+// the migration table to the "<table>_history" table, which is created with the
+// same columns if it does not exist. The records are copied and deleted in a
+// single transaction. Backends that commit the transaction when a table is
+// created, like MySQL, create the history table before the transaction.
+//
+// Archived migrations are no longer known to be applied, so they are run again
+// unless they are removed from the Migrator, e.g. after squashing them into a
+// baseline migration.
+//
+// The records of every namespace would be archived, so ErrNamespaced is
+// returned if the Migrator has a namespace.
+func (m *Migrator) ArchiveOldRecords(before time.Time) error {
+	if m.namespace != "" {
+		return fmt.Errorf("archive migration records failed: %w", ErrNamespaced)
+	}
+	ctx := context.Background()
+
+	err := m.backend.Lock(ctx, m.lockTimeout)
+	if err != nil {
+		return fmt.Errorf("acquire migration lock failed: %w", err)
+	}
+	defer m.backend.Unlock(context.Background())
+
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil {
+		return errTableSetup("the migration table check failed", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	if c, ok := m.backend.(backends.HistoryTableCreator); ok {
+		err = c.CreateHistoryTable(ctx)
+		if err != nil {
+			return fmt.Errorf("create history table failed: %w", err)
+		}
+	}
+
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	err = m.backend.ArchiveRecords(tx, before)
+	if err != nil {
+		m.rollbackTx(tx)
+		return fmt.Errorf("archive migration records failed: %w", err)
+	}
+	if m.tableChecksum {
+		err = m.storeTableChecksum(tx)
+		if err != nil {
+			m.rollbackTx(tx)
+			return fmt.Errorf("store migration table checksum failed: %w", err)
+		}
+	}
+	err = m.commitTx(tx)
+	if err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+	m.logger.Info("archived migration records", "before", before)
+	return nil
+}
+
+// CleanMigrationTable deletes every record from the migration table, keeping
+// the table, and resets the previous migrations and the log of the Migrator, so
+// every migration is run again by the next run. It is meant for resetting the
+// state between integration tests.
+//
+// If the Migrator has a namespace only the records in the namespace are
+// deleted, in a single transaction, so the other namespaces are not reset.
+//
+// WARNING: this is destructive and can't be undone. Nothing is done to undo the
+// migrations, so running them again on a database that has them fails. Never
+// call it outside of test code.
+func (m *Migrator) CleanMigrationTable() error {
+	ctx := context.Background()
+
+	err := m.backend.Lock(ctx, m.lockTimeout)
+	if err != nil {
+		return fmt.Errorf("acquire migration lock failed: %w", err)
+	}
+	defer m.backend.Unlock(context.Background())
+
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil {
+		return errTableSetup("the migration table check failed", err)
+	}
+	if exists && m.namespace != "" {
+		err = m.cleanNamespace(ctx)
+		if err != nil {
+			return fmt.Errorf("clean migration table failed: %w", err)
+		}
+	}
+	if exists && m.namespace == "" {
+		err = m.backend.CleanTable()
+		if err != nil {
+			return fmt.Errorf("clean migration table failed: %w", err)
+		}
+	}
+	if exists && m.namespace == "" && m.tableChecksum {
+		tx, err := m.begin(ctx)
+		if err != nil {
+			return fmt.Errorf("begin transaction failed: %w", err)
+		}
+		err = m.storeTableChecksum(tx)
+		if err != nil {
+			m.rollbackTx(tx)
+			return fmt.Errorf("store migration table checksum failed: %w", err)
+		}
+		err = m.commitTx(tx)
+		if err != nil {
+			return fmt.Errorf("commit transaction failed: %w", err)
+		}
+	}
+	m.previous = make(map[string]string)
+	m.log = nil
+	m.logger.Warn("deleted every migration record", "table", m.TableName)
+	return nil
+}
+
+// cleanNamespace deletes the records in the namespace of the Migrator in a
+// single transaction. The backend only returns and deletes the records with the
+// namespace prefix.
+func (m *Migrator) cleanNamespace(ctx context.Context) error {
+	prev, err := m.backend.QueryPreviousContext(ctx)
+	if err != nil {
+		return fmt.Errorf("get previous migrations failed: %w", err)
+	}
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	for name := range prev {
+		err = m.backend.DeleteRecord(tx, name)
+		if err != nil {
+			m.rollbackTx(tx)
+			return fmt.Errorf("delete record for '%s' failed: %w", name, err)
+		}
+	}
+	err = m.commitTx(tx)
+	if err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+	return nil
+}
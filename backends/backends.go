@@ -1,6 +1,13 @@
 package backends
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"strings"
 	"time"
 
@@ -10,39 +17,545 @@ import (
 type Backend interface {
 	// Setup does the initial configuration of the backend.
 	Setup(db *sqlx.DB, table string, tableSchema string)
-	// InsertRecord migration record into the DB.
-	InsertRecord(tx *sqlx.Tx, name string, hash string, comment string) error
+	// Ping checks that the database can be reached before a run, e.g. that
+	// every node of a cluster is up.
+	Ping(ctx context.Context) error
+	// InsertRecord migration record into the DB. executionMs is how long the
+	// migration took to run in milliseconds.
+	InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error
+	// InsertRecordContext is like InsertRecord but uses ctx.
+	InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error
+	// InsertRecordWithApplier is like InsertRecordContext, and also records who
+	// applied the migration, e.g. the host name or service, and the values of
+	// any extra columns.
+	InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...ExtraField) error
 	// HasMigrationTable returns true if the migration table exists.
 	HasMigrationTable() (bool, error)
+	// HasMigrationTableContext is like HasMigrationTable but uses ctx.
+	HasMigrationTableContext(ctx context.Context) (bool, error)
 	// QueryPrevious queries and sets the records of all previous migrations.
 	QueryPrevious() (map[string]string, error)
+	// QueryPreviousContext is like QueryPrevious but uses ctx.
+	QueryPreviousContext(ctx context.Context) (map[string]string, error)
 	// CreateMigrationTable makes the migrations table, and return the query used to
 	// do it.
 	CreateMigrationTable() (string, error)
+	// CreateMigrationTableContext is like CreateMigrationTable but uses ctx.
+	CreateMigrationTableContext(ctx context.Context) (string, error)
 	RepairHashes(tx *sqlx.Tx, hashes map[string]string) error
+	// OverrideColumns sets custom column definitions to use in place of the
+	// defaults when the migration table is created.
+	OverrideColumns(columns map[string]string)
+	// ExtendColumns sets extra columns to add to the migration table when it is
+	// created.
+	ExtendColumns(columns []RecordColumn)
+	// AddColumns adds the columns the existing migration table doesn't have,
+	// and returns the queries used to do it.
+	AddColumns(ctx context.Context, columns []RecordColumn) (string, error)
+	// MigrateMigrationTable upgrades an existing migration table created by an
+	// older version of sqlxm, e.g. adding the columns it doesn't have. The
+	// upgrades that have already been made are skipped.
+	MigrateMigrationTable() error
+	// ListTables returns the names of all the tables in the database schema.
+	ListTables() ([]string, error)
+	// QueryRecords returns all the migration records ordered by id.
+	QueryRecords(q sqlx.Queryer) ([]MigrationRecord, error)
+	// CountRecords returns the number of migration records.
+	CountRecords(ctx context.Context) (int, error)
+	// QueryChecksum returns the stored migration table checksum, creating the
+	// checksum table if it does not exist. An empty string is returned if no
+	// checksum has been stored.
+	QueryChecksum() (string, error)
+	// StoreChecksum replaces the stored migration table checksum.
+	StoreChecksum(tx *sqlx.Tx, checksum string) error
+	// DeleteRecord deletes a migration record from the DB.
+	DeleteRecord(tx *sqlx.Tx, name string) error
+	// UpdateComment replaces the comment of a migration record.
+	UpdateComment(tx *sqlx.Tx, name string, comment string) error
+	// CleanTable deletes every migration record with a single statement,
+	// keeping the table.
+	CleanTable() error
+	// ArchiveRecords moves the migration records dated before before to the
+	// "<table>_history" table, creating it with the same columns if it does
+	// not exist.
+	ArchiveRecords(tx *sqlx.Tx, before time.Time) error
+	// Lock acquires a lock on the migration table so only one Migrator can run
+	// at a time. ErrLockTimeout is returned if the lock is not acquired within
+	// timeout.
+	Lock(ctx context.Context, timeout time.Duration) error
+	// Unlock releases the lock acquired by Lock.
+	Unlock(ctx context.Context) error
 }
 
+// A Retrier is a Backend that can retry a statement in a transaction after a
+// transient error, for example a serialization failure.
+type Retrier interface {
+	// Retry calls fn, which runs statements in tx, and calls it again if it
+	// fails with an error that can be retried.
+	Retry(ctx context.Context, tx *sqlx.Tx, fn func() error) error
+}
+
+// A StatementRunner is a Backend that controls how migration statements are
+// run, for example to run DDL outside of the migration transaction.
+type StatementRunner interface {
+	// RunStatement runs a migration statement with its args.
+	RunStatement(ctx context.Context, tx *sqlx.Tx, statement string, args ...interface{}) error
+}
+
+// A Claimer is a Backend that can claim a migration by inserting its record
+// before the migration is run, so concurrent runs don't both apply it. The
+// migration table needs a status column, see StatusColumn.
+type Claimer interface {
+	// ClaimRecord inserts the migration record with the status StatusRunning,
+	// unless there already is a record for the migration. It returns false if
+	// the record was not inserted because another run has claimed it.
+	ClaimRecord(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, appliedBy string, extra ...ExtraField) (bool, error)
+	// CompleteRecord sets the status of a claimed record to StatusDone, and
+	// stores how long the migration took to run in milliseconds.
+	CompleteRecord(ctx context.Context, tx *sqlx.Tx, name string, executionMs int64) error
+}
+
+// The statuses of a claimed migration record.
+const (
+	StatusRunning = "running"
+	StatusDone    = "done"
+)
+
+// StatusColumn is the migration table column used by a Claimer. Records that
+// are not claimed, e.g. ones inserted before claims were used, are done.
+var StatusColumn = RecordColumn{Name: "status", Type: "VARCHAR(16)", Default: "'" + StatusDone + "'"}
+
+// A Savepointer is a Backend with its own savepoint statements. Backends that
+// are not Savepointers use Savepoint, RollbackToSavepoint and ReleaseSavepoint.
+type Savepointer interface {
+	// Savepoint creates a savepoint with name in tx.
+	Savepoint(ctx context.Context, tx *sqlx.Tx, name string) error
+	// RollbackToSavepoint undoes the changes made in tx since the savepoint.
+	RollbackToSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error
+	// ReleaseSavepoint removes the savepoint, keeping the changes made since it.
+	ReleaseSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error
+}
+
+// A QueryTimeouter is a Backend that can limit how long a migration statement
+// runs with a database setting, so the database stops the statement when the
+// timeout is hit.
+type QueryTimeouter interface {
+	// TimeoutStatement prepares tx to stop statement after timeout, and
+	// returns the statement to run.
+	TimeoutStatement(ctx context.Context, tx *sqlx.Tx, statement string, timeout time.Duration) (string, error)
+	// IsQueryTimeout returns true if err is from a statement that was stopped
+	// because it ran longer than the timeout.
+	IsQueryTimeout(err error) bool
+}
+
+// An Explainer is a Backend that can show how the database would run a
+// statement with EXPLAIN, without running it.
+type Explainer interface {
+	// Explain returns the plan of statement. ErrNotApplicable is returned if
+	// EXPLAIN can't analyze the statement, e.g. DDL.
+	Explain(ctx context.Context, statement string, args ...interface{}) (string, error)
+}
+
+// A NoticeCapturer is a Backend that can write the notices the database sends
+// while migrations are run, like Postgres NOTICE messages, to a writer.
+type NoticeCapturer interface {
+	// CaptureNotices sets the writer the notice handler registered by the next
+	// Setup writes to. Notices are not captured if w is nil.
+	CaptureNotices(w io.Writer)
+	// WatchNotices sets the notice handler on conn, which the migration
+	// transaction is begun on. Calling stop removes it.
+	WatchNotices(ctx context.Context, conn *sqlx.Conn) (stop func(), err error)
+}
+
+// A PragmaSetter is a Backend that issues PRAGMA statements when it is set up,
+// like SQLite.
+type PragmaSetter interface {
+	// SetPragmas sets the pragmas issued by the next Setup, e.g.
+	// "journal_mode = WAL".
+	SetPragmas(pragmas []string)
+}
+
+// A Namespacer is a Backend that can read only the migration records whose
+// names start with a prefix, so the records of a namespace are filtered by the
+// database rather than after all of them are read. The prefix is matched with
+// the pattern from LikePrefix.
+type Namespacer interface {
+	// QueryPreviousPrefix is like QueryPreviousContext, but only returns the
+	// records whose names start with prefix.
+	QueryPreviousPrefix(ctx context.Context, prefix string) (map[string]string, error)
+	// QueryRecordsPrefix is like QueryRecords, but only returns the records
+	// whose names start with prefix.
+	QueryRecordsPrefix(q sqlx.Queryer, prefix string) ([]MigrationRecord, error)
+	// CountRecordsPrefix is like CountRecords, but only counts the records
+	// whose names start with prefix.
+	CountRecordsPrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// A HistoryTableCreator is a Backend that can't create a table in a transaction
+// without committing it, like MySQL. The history table is created with
+// CreateHistoryTable before the transaction of ArchiveRecords is begun, so
+// ArchiveRecords only moves the records.
+type HistoryTableCreator interface {
+	// CreateHistoryTable creates the "<table>_history" table with the same
+	// columns as the migration table if it does not exist.
+	CreateHistoryTable(ctx context.Context) error
+}
+
+// ErrLockTimeout is returned by Backend.Lock when the lock is not acquired
+// before the timeout.
+var ErrLockTimeout = errors.New("lock timeout")
+
+// ErrNotApplicable is returned by Explainer.Explain for a statement EXPLAIN
+// can't analyze.
+var ErrNotApplicable = errors.New("explain not applicable")
+
+// ErrQueryTimeout is returned when a migration statement runs longer than the
+// query timeout.
+var ErrQueryTimeout = errors.New("query timeout")
+
+// How long TryLock waits between attempts to acquire a lock.
+const lockRetryInterval = 100 * time.Millisecond
+
 type MigrationRecord struct {
-	ID      int       `db:"id"`
-	Name    string    `db:"name"`
-	Hash    string    `db:"hash"`
-	Date    time.Time `db:"date"`
-	Comment string    `db:"comment"`
+	ID        int       `db:"id"`
+	Name      string    `db:"name"`
+	Hash      string    `db:"hash"`
+	Date      time.Time `db:"date"`
+	Comment   string    `db:"comment"`
+	AppliedBy string    `db:"applied_by"`
+}
+
+// A RecordColumn is an extra column of the migration table, for example to store
+// the git SHA or deployment of each migration.
+type RecordColumn struct {
+	// Name of the column.
+	Name string
+	// Type is the SQL type of the column, e.g. "VARCHAR(64)".
+	Type string
+	// Default is the SQL default value, e.g. "'unknown'". The column has no
+	// default if it is empty.
+	Default string
+	// Nullable allows NULL in the column.
+	Nullable bool
+}
+
+// Definition returns the column definition that follows the column name.
+func (c RecordColumn) Definition() string {
+	def := c.Type
+	if c.Default != "" {
+		def += " DEFAULT " + c.Default
+	}
+	if !c.Nullable {
+		def += " NOT NULL"
+	}
+	return def
 }
 
-// nameTable takes a query and replaces all instances of "??" with the tableName
-func nameTable(query string, tableName string) string {
+// A SchemaUpgrade is a change to the migration table made by a newer version of
+// sqlxm, which MigrateMigrationTable makes to tables created before it.
+type SchemaUpgrade struct {
+	// Version of the migration table after the upgrade. The upgrades of a
+	// backend are numbered from 1 in the order they are made.
+	Version int
+	// Column added by the upgrade.
+	Column RecordColumn
+}
+
+// SchemaVersionColumn is the migration table column that stores the version of
+// the table, so the upgrades that have been made are skipped.
+var SchemaVersionColumn = RecordColumn{Name: "schema_version", Type: "INTEGER", Default: "0"}
+
+// An ExtraField is the value of a RecordColumn to store in a migration record.
+type ExtraField struct {
+	Name  string
+	Value interface{}
+}
+
+// recordDate scans a migration record date. Drivers return dates in different
+// forms, e.g. MySQL returns []byte unless parseTime is set on the DSN.
+type recordDate struct {
+	time.Time
+}
+
+// The layouts tried when a date is returned as text.
+var recordDateLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+}
+
+// Scan implements the sql.Scanner interface.
+func (d *recordDate) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		d.Time = time.Time{}
+		return nil
+	case time.Time:
+		d.Time = v
+		return nil
+	case []byte:
+		return d.parse(string(v))
+	case string:
+		return d.parse(v)
+	}
+	return fmt.Errorf("unsupported date type %T", value)
+}
+
+func (d *recordDate) parse(value string) error {
+	for _, layout := range recordDateLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			d.Time = t
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported date format '%s'", value)
+}
+
+// ParseTableName splits a table name of the form schema.table into the schema
+// and the table. The schema is empty if the name is not qualified.
+func ParseTableName(qualified string) (schema string, table string) {
+	i := strings.LastIndex(qualified, ".")
+	if i < 0 {
+		return "", qualified
+	}
+	return qualified[:i], qualified[i+1:]
+}
+
+// splitTableName returns the table and schema for Setup, using the schema of a
+// schema qualified table name in place of tableSchema.
+func splitTableName(table string, tableSchema string) (string, string) {
+	schema, table := ParseTableName(table)
+	if schema != "" {
+		tableSchema = schema
+	}
+	return table, tableSchema
+}
+
+// nameColumns returns columns with "??" in the definitions replaced with the
+// unqualified table name, for constraint names that can't have a schema.
+func nameColumns(columns map[string]string, table string) map[string]string {
+	named := make(map[string]string, len(columns))
+	for name, def := range columns {
+		named[name] = strings.Replace(def, "??", table, -1)
+	}
+	return named
+}
+
+// nameTable takes a query and replaces all instances of "??" with the tableName.
+//
+// Column placeholders like "{date}" are replaced with the column definition
+// from columns. When more than one map is given the later maps take precedence,
+// so defaults can be passed first followed by any overrides.
+func nameTable(query string, tableName string, columns ...map[string]string) string {
+	defs := make(map[string]string)
+	for _, c := range columns {
+		for name, def := range c {
+			defs[name] = def
+		}
+	}
+	for name, def := range defs {
+		query = strings.Replace(query, "{"+name+"}", def, -1)
+	}
 	return strings.Replace(query, "??", tableName, -1)
 }
 
+// extraColumns returns the definitions of columns to append to the column list
+// of a CREATE TABLE query. The names are wrapped in quote.
+func extraColumns(columns []RecordColumn, quote string) string {
+	var b strings.Builder
+	for _, c := range columns {
+		fmt.Fprintf(&b, ",\n\t\t%s%s%s %s", quote, c.Name, quote, c.Definition())
+	}
+	return b.String()
+}
+
+// extraFields returns the column names and placeholders to append to the
+// column and value lists of an INSERT query, and the values to append to its
+// args. n is the number of args before the extra fields, and placeholder
+// returns the placeholder of the nth arg.
+func extraFields(extra []ExtraField, quote string, n int, placeholder func(n int) string) (string, string, []interface{}) {
+	var names, values strings.Builder
+	args := make([]interface{}, 0, len(extra))
+	for i, f := range extra {
+		fmt.Fprintf(&names, ", %s%s%s", quote, f.Name, quote)
+		values.WriteString(", " + placeholder(n+i+1))
+		args = append(args, f.Value)
+	}
+	return names.String(), values.String(), args
+}
+
+// questionPlaceholder is the placeholder used by SQLite and MySQL.
+func questionPlaceholder(int) string {
+	return "?"
+}
+
+// dollarPlaceholder is the placeholder used by Postgres.
+func dollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
 func InsertRecord(tx *sqlx.Tx, query string, args ...interface{}) error {
-	_, err := tx.Exec(query, args...)
+	return InsertRecordContext(context.Background(), tx, query, args...)
+}
+
+// InsertRecordContext is like InsertRecord but uses ctx.
+func InsertRecordContext(ctx context.Context, tx *sqlx.Tx, query string, args ...interface{}) error {
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ClaimRecord runs the insert query from Claimer.ClaimRecord, and returns true
+// if it inserted the record.
+func ClaimRecord(ctx context.Context, tx *sqlx.Tx, query string, args ...interface{}) (bool, error) {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Savepoint creates a savepoint with name in tx with the standard SAVEPOINT
+// statement. name must be a valid identifier.
+func Savepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, "SAVEPOINT "+name)
+	return err
+}
+
+// RollbackToSavepoint undoes the changes made in tx since the savepoint with
+// the standard ROLLBACK TO SAVEPOINT statement.
+func RollbackToSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+	return err
+}
+
+// ReleaseSavepoint removes the savepoint from tx with the standard RELEASE
+// SAVEPOINT statement.
+func ReleaseSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// newLockID returns a unique id for a lock, made of the host name, process id
+// and a random number, so a lock row shows who holds it.
+func newLockID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s:%d:%x", host, os.Getpid(), b)
+}
+
+// TryLock calls try until it acquires the lock, or returns ErrLockTimeout when
+// timeout passes.
+func TryLock(ctx context.Context, timeout time.Duration, try func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := try()
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// DeleteRecord runs the query from Backend.DeleteRecord.
+func DeleteRecord(tx *sqlx.Tx, query string, name string) error {
+	_, err := tx.Exec(query, name)
+	return err
+}
+
+// UpdateComment runs the query from Backend.UpdateComment, which has the
+// comment as its first arg and the name as its second.
+func UpdateComment(tx *sqlx.Tx, query string, name string, comment string) error {
+	_, err := tx.Exec(query, comment, name)
+	return err
+}
+
+// Explain runs statement with the EXPLAIN prefix in front of it, and returns
+// the rows of the plan as lines of tab separated columns. ErrNotApplicable is
+// returned without running anything if the first keyword of statement is not
+// one of keywords.
+func Explain(ctx context.Context, db *sqlx.DB, prefix string, statement string, keywords map[string]struct{}, args ...interface{}) (string, error) {
+	keyword := strings.ToUpper(firstKeyword(statement))
+	if _, ok := keywords[keyword]; !ok {
+		return "", fmt.Errorf("%w: EXPLAIN can't analyze %s statements", ErrNotApplicable, keyword)
+	}
+	rows, err := db.QueryxContext(ctx, prefix+" "+statement, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	lines := make([]string, 0)
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return "", err
+		}
+		columns := make([]string, len(values))
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			columns[i] = fmt.Sprint(v)
+		}
+		lines = append(lines, strings.Join(columns, "\t"))
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// CountRecords runs the query from Backend.CountRecords with its args and
+// returns the count.
+func CountRecords(ctx context.Context, db *sqlx.DB, query string, args ...interface{}) (int, error) {
+	count := 0
+	err := db.GetContext(ctx, &count, query, args...)
+	return count, err
+}
+
+// CleanTable runs the query from Backend.CleanTable.
+func CleanTable(db *sqlx.DB, query string) error {
+	_, err := db.Exec(query)
+	return err
+}
+
+// ArchiveRecords runs the queries from Backend.ArchiveRecords. The create query
+// makes the history table, unless it is empty, and the insert and delete
+// queries are run with before as their arg.
+func ArchiveRecords(tx *sqlx.Tx, create string, insert string, delete string, before interface{}) error {
+	if create != "" {
+		_, err := tx.Exec(create)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(insert, before)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(delete, before)
 	return err
 }
 
 func HasMigrationTable(db *sqlx.DB, query string) (bool, error) {
+	return HasMigrationTableContext(context.Background(), db, query)
+}
+
+// HasMigrationTableContext is like HasMigrationTable but uses ctx.
+func HasMigrationTableContext(ctx context.Context, db *sqlx.DB, query string) (bool, error) {
 	exists := false
-	err := db.Get(&exists, query)
+	err := db.GetContext(ctx, &exists, query)
 
 	// If this query fails something has gone terribly wrong.
 	if err != nil {
@@ -54,9 +567,15 @@ func HasMigrationTable(db *sqlx.DB, query string) (bool, error) {
 // QueryPrevious runs the query from the Backend.QueryPrevious and returns the
 // results.
 func QueryPrevious(db *sqlx.DB, query string) (map[string]string, error) {
+	return QueryPreviousContext(context.Background(), db, query)
+}
+
+// QueryPreviousContext is like QueryPrevious but uses ctx, and runs the query
+// with args.
+func QueryPreviousContext(ctx context.Context, db *sqlx.DB, query string, args ...interface{}) (map[string]string, error) {
 	mr := make([]MigrationRecord, 0, 10)
 
-	err := db.Select(&mr, query)
+	err := db.SelectContext(ctx, &mr, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -69,12 +588,179 @@ func QueryPrevious(db *sqlx.DB, query string) (map[string]string, error) {
 	return prev, nil
 }
 
+// LikePrefix returns the LIKE pattern matching the names that start with
+// prefix. The wildcards in prefix are escaped with "!", so the pattern must be
+// used with ESCAPE '!'. SQL Server's "[" is escaped as well.
+func LikePrefix(prefix string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_", "[", "![")
+	return r.Replace(prefix) + "%"
+}
+
+// QueryRecords runs the query from the Backend.QueryRecords with its args and
+// returns the results.
+func QueryRecords(q sqlx.Queryer, query string, args ...interface{}) ([]MigrationRecord, error) {
+	// Oracle stores empty strings as NULL.
+	rows := make([]struct {
+		MigrationRecord
+		Date      recordDate     `db:"date"`
+		Comment   sql.NullString `db:"comment"`
+		AppliedBy sql.NullString `db:"applied_by"`
+	}, 0, 10)
+	err := sqlx.Select(q, &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	mr := make([]MigrationRecord, len(rows))
+	for i, r := range rows {
+		mr[i] = r.MigrationRecord
+		mr[i].Date = r.Date.Time
+		mr[i].Comment = r.Comment.String
+		mr[i].AppliedBy = r.AppliedBy.String
+	}
+	return mr, nil
+}
+
+// QueryChecksum creates the checksum table using the create query if needed, and
+// then runs the query from Backend.QueryChecksum.
+func QueryChecksum(db *sqlx.DB, create string, query string) (string, error) {
+	_, err := db.Exec(create)
+	if err != nil {
+		return "", err
+	}
+	checksums := make([]string, 0, 1)
+	err = db.Select(&checksums, query)
+	if err != nil || len(checksums) == 0 {
+		return "", err
+	}
+	return checksums[0], nil
+}
+
+// StoreChecksum deletes the existing checksum and inserts the new one.
+func StoreChecksum(tx *sqlx.Tx, deleteQuery string, insertQuery string, checksum string) error {
+	_, err := tx.Exec(deleteQuery)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(insertQuery, checksum)
+	return err
+}
+
+// ListTables runs the query from the Backend.ListTables and returns the table
+// names.
+func ListTables(db *sqlx.DB, query string, args ...interface{}) ([]string, error) {
+	tables := make([]string, 0, 10)
+	err := db.Select(&tables, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
 func CreateMigrationTable(db *sqlx.DB, query string) (string, error) {
-	_, err := db.Exec(query)
+	return CreateMigrationTableContext(context.Background(), db, query)
+}
+
+// CreateMigrationTableContext is like CreateMigrationTable but uses ctx.
+func CreateMigrationTableContext(ctx context.Context, db *sqlx.DB, query string) (string, error) {
+	_, err := db.ExecContext(ctx, query)
 
 	return query, err
 }
 
+// AddColumns adds each of columns that isn't returned by selectQuery, which
+// should select every column of the migration table, with the query returned
+// by alter. The queries that are run are returned.
+func AddColumns(ctx context.Context, db *sqlx.DB, selectQuery string, columns []RecordColumn, alter func(c RecordColumn) string) (string, error) {
+	existing, err := tableColumns(ctx, db, selectQuery)
+	if err != nil {
+		return "", err
+	}
+
+	queries := make([]string, 0, len(columns))
+	for _, c := range columns {
+		if _, ok := existing[strings.ToLower(c.Name)]; ok {
+			continue
+		}
+		q := alter(c)
+		_, err = db.ExecContext(ctx, q)
+		if err != nil {
+			return strings.Join(queries, "\n"), err
+		}
+		queries = append(queries, q)
+	}
+	return strings.Join(queries, "\n"), nil
+}
+
+// tableColumns returns the lower case names of the columns returned by
+// selectQuery.
+func tableColumns(ctx context.Context, db *sqlx.DB, selectQuery string) (map[string]struct{}, error) {
+	rows, err := db.QueryxContext(ctx, selectQuery)
+	if err != nil {
+		return nil, err
+	}
+	names, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		existing[strings.ToLower(name)] = struct{}{}
+	}
+	return existing, nil
+}
+
+// MigrateMigrationTable brings a migration table created by an older version
+// of sqlxm up to date. The version of the table is the highest
+// SchemaVersionColumn of its records, found with versionQuery, and the column
+// is added with alter if the table doesn't have it yet. Each of the upgrades
+// with a higher version adds its column with alter, unless selectQuery, which
+// should select every column of the migration table, returns it already.
+// Finally updateQuery sets the version of every record to the latest version.
+func MigrateMigrationTable(ctx context.Context, db *sqlx.DB, selectQuery string, versionQuery string, updateQuery string, upgrades []SchemaUpgrade, alter func(c RecordColumn) string) error {
+	if len(upgrades) == 0 {
+		return nil
+	}
+	existing, err := tableColumns(ctx, db, selectQuery)
+	if err != nil {
+		return err
+	}
+
+	version := 0
+	if _, ok := existing[SchemaVersionColumn.Name]; ok {
+		err = db.GetContext(ctx, &version, versionQuery)
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err = db.ExecContext(ctx, alter(SchemaVersionColumn))
+		if err != nil {
+			return err
+		}
+	}
+	latest := upgrades[len(upgrades)-1].Version
+	if version >= latest {
+		return nil
+	}
+
+	for _, u := range upgrades {
+		if u.Version <= version {
+			continue
+		}
+		// A table with no records has no version, so the columns of a new
+		// table are skipped by name.
+		if _, ok := existing[strings.ToLower(u.Column.Name)]; ok {
+			continue
+		}
+		_, err = db.ExecContext(ctx, alter(u.Column))
+		if err != nil {
+			return fmt.Errorf("upgrade to version %d failed: %w", u.Version, err)
+		}
+	}
+	_, err = db.ExecContext(ctx, updateQuery, latest)
+	return err
+}
+
 func RepairHashes(tx *sqlx.Tx, query string, hashes map[string]string) error {
 	for name, hash := range hashes {
 		if hash == "" {
@@ -0,0 +1,96 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultCockroachRetries is the number of times a statement is retried when
+// Cockroach.MaxRetries is not set.
+const DefaultCockroachRetries = 5
+
+// Cockroach is the backend for CockroachDB. CockroachDB uses the Postgres wire
+// protocol, but serializable transactions can fail with SQLSTATE 40001 and must
+// be retried. Migration statements and hash repairs are retried from a
+// savepoint up to MaxRetries times.
+//
+// To use a different MaxRetries register another Cockroach backend.
+//
+//	err := sqlxm.RegisterBackendFactory("cockroach_10", func() backends.Backend {
+//		return &backends.Cockroach{MaxRetries: 10}
+//	})
+type Cockroach struct {
+	Postgres
+	// MaxRetries is the number of times a statement is retried after a 40001
+	// error. DefaultCockroachRetries is used if it is zero.
+	MaxRetries int
+}
+
+// The savepoint a statement is rolled back to before it is retried.
+const cockroachSavepoint = "sqlxm_retry"
+
+// HasMigrationTableContext is like HasMigrationTable but uses ctx. It uses
+// crdb_internal.tables since information_schema can be slow in CockroachDB.
+func (c *Cockroach) HasMigrationTableContext(ctx context.Context) (bool, error) {
+	q := fmt.Sprintf(`SELECT EXISTS(
+		SELECT * FROM crdb_internal.tables
+		WHERE database_name = current_database()
+		AND schema_name = '%s'
+		AND name = '%s'
+		AND state = 'PUBLIC'
+	);`, c.tableSchema, c.table)
+	return HasMigrationTableContext(ctx, c.db, q)
+}
+
+// HasMigrationTable returns true if the migration table exists.
+func (c *Cockroach) HasMigrationTable() (bool, error) {
+	return c.HasMigrationTableContext(context.Background())
+}
+
+func (c *Cockroach) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
+	return c.Retry(context.Background(), tx, func() error {
+		return c.Postgres.RepairHashes(tx, hashes)
+	})
+}
+
+// Retry calls fn, and if it fails with a 40001 error rolls tx back to before
+// fn was called and tries again.
+func (c *Cockroach) Retry(ctx context.Context, tx *sqlx.Tx, fn func() error) error {
+	max := c.MaxRetries
+	if max <= 0 {
+		max = DefaultCockroachRetries
+	}
+	for retries := 0; ; retries++ {
+		_, err := tx.ExecContext(ctx, "SAVEPOINT "+cockroachSavepoint)
+		if err != nil {
+			return err
+		}
+		err = fn()
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+cockroachSavepoint)
+			return err
+		}
+		if !isSerializationFailure(err) || retries >= max {
+			return err
+		}
+		_, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+cockroachSavepoint)
+		if rollbackErr != nil {
+			return err
+		}
+	}
+}
+
+// isSerializationFailure returns true if err has SQLSTATE 40001. Drivers that
+// don't expose the SQLSTATE are matched on the error message.
+func isSerializationFailure(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return state.SQLState() == "40001"
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "40001") || strings.Contains(msg, "restart transaction")
+}
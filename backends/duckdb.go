@@ -0,0 +1,112 @@
+package backends
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DuckDB is the backend for DuckDB, an embedded analytics database. DuckDB
+// uses the Postgres dialect and $1 placeholders, so the Postgres backend is
+// used for the queries that work in both. The migration table id uses a
+// sequence, and the lock is a row in a lock table since DuckDB has no advisory
+// locks.
+type DuckDB struct {
+	Postgres
+}
+
+// The default DuckDB migration table column definitions.
+var duckdbColumns = map[string]string{
+	"id":           "INTEGER      DEFAULT nextval('??_id_seq') PRIMARY KEY",
+	"name":         "VARCHAR(64)                               NOT NULL",
+	"hash":         "VARCHAR(64)                               NOT NULL",
+	"date":         "TIMESTAMP    DEFAULT current_timestamp    NOT NULL",
+	"comment":      "VARCHAR(512)                              NOT NULL",
+	"execution_ms": "INTEGER      DEFAULT 0                    NOT NULL",
+	"applied_by":   "VARCHAR(128) DEFAULT ''                   NOT NULL",
+}
+
+// Setup does the initial configuration of the backend. The default schema is
+// 'main'.
+func (d *DuckDB) Setup(db *sqlx.DB, table string, tableSchema string) {
+	if tableSchema == "" {
+		tableSchema = "main"
+	}
+	d.Postgres.Setup(db, table, tableSchema)
+}
+
+// CreateMigrationTable makes the migrations table, and return the query used to
+// do it.
+func (d *DuckDB) CreateMigrationTable() (string, error) {
+	return d.CreateMigrationTableContext(context.Background())
+}
+
+// CreateMigrationTableContext is like CreateMigrationTable but uses ctx. DuckDB
+// has no SERIAL type, so the id is the next value of the "<table>_id_seq"
+// sequence.
+func (d *DuckDB) CreateMigrationTableContext(ctx context.Context) (string, error) {
+	q := nameTable(`CREATE SEQUENCE IF NOT EXISTS ??_id_seq;
+
+	CREATE TABLE ?? (
+		id      {id},
+		name    {name},
+		hash    {hash},
+		date    {date},
+		comment {comment},
+		execution_ms {execution_ms},
+		applied_by {applied_by}`+extraColumns(d.extra, "")+`
+	);
+
+	CREATE UNIQUE INDEX `+d.table+`_name_uindex ON ?? (name);`, d.qualified(), duckdbColumns, d.columns)
+	return CreateMigrationTableContext(ctx, d.db, q)
+}
+
+// ArchiveRecords moves the migration records dated before before to the
+// "<table>_history" table. DuckDB has no CREATE TABLE LIKE, so the history
+// table is created from an empty select.
+func (d *DuckDB) ArchiveRecords(tx *sqlx.Tx, before time.Time) error {
+	c := nameTable(`CREATE TABLE IF NOT EXISTS ??_history AS SELECT * FROM ?? WHERE 1 = 0;`, d.qualified())
+	i := nameTable(`INSERT INTO ??_history SELECT * FROM ?? WHERE date < $1;`, d.qualified())
+	del := nameTable(`DELETE FROM ?? WHERE date < $1;`, d.qualified())
+	return ArchiveRecords(tx, c, i, del, before)
+}
+
+// Lock acquires a lock by inserting the single allowed row into the
+// "<table>_lock" table, like the SQLite backend. A DuckDB file can only be
+// opened for writing by one process, so this guards against concurrent runs
+// within the process.
+//
+// If a process exits without calling Unlock the row must be deleted by hand.
+func (d *DuckDB) Lock(ctx context.Context, timeout time.Duration) error {
+	create := nameTable(`CREATE TABLE IF NOT EXISTS ??_lock (
+		id          INTEGER   PRIMARY KEY CHECK (id = 1),
+		acquired_at TIMESTAMP NOT NULL
+	);`, d.qualified())
+	_, err := d.db.ExecContext(ctx, create)
+	if err != nil {
+		return err
+	}
+	q := nameTable(`INSERT INTO ??_lock (id, acquired_at) VALUES (1, current_timestamp) ON CONFLICT DO NOTHING;`, d.qualified())
+	return TryLock(ctx, timeout, func() (bool, error) {
+		res, err := d.db.ExecContext(ctx, q)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n == 1, err
+	})
+}
+
+// Unlock releases the lock acquired by Lock.
+func (d *DuckDB) Unlock(ctx context.Context) error {
+	q := nameTable(`DELETE FROM ??_lock WHERE id = 1;`, d.qualified())
+	_, err := d.db.ExecContext(ctx, q)
+	return err
+}
+
+// TimeoutStatement returns statement unchanged, since DuckDB has no
+// statement_timeout setting. The statement is stopped by the context timeout.
+func (d *DuckDB) TimeoutStatement(ctx context.Context, tx *sqlx.Tx, statement string, timeout time.Duration) (string, error) {
+	return statement, nil
+}
@@ -0,0 +1,22 @@
+package backends
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// LibSQL is the backend for libSQL and Turso databases. libSQL is compatible
+// with SQLite, so the SQLite backend is used as is, e.g. HasMigrationTable still
+// uses sqlite_master.
+//
+// Remote databases don't support WAL mode. The backend never sets the journal
+// mode, so the mode set by the server is used.
+type LibSQL struct {
+	SQLite
+}
+
+// Setup does the initial configuration of the backend. The remote database URL
+// is part of the DSN and is handled by the driver. A remote database has a
+// single schema, so tableSchema is ignored.
+func (l *LibSQL) Setup(db *sqlx.DB, table string, tableSchema string) {
+	l.SQLite.Setup(db, table, "")
+}
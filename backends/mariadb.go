@@ -0,0 +1,310 @@
+package backends
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MariaDB is the backend for MariaDB. It is separate from the MySQL backend
+// since the two have diverged, e.g. in their information_schema tables.
+type MariaDB struct {
+	// The database connection to use for this backend.
+	db *sqlx.DB
+	// The migration table name
+	table string
+	// The SQL 'table_schema' in MariaDB is the name of the DB.
+	tableSchema string
+	// Custom migration table column definitions.
+	columns map[string]string
+	// Extra migration table columns.
+	extra []RecordColumn
+	// The connection holding the named lock.
+	lockConn *sqlx.Conn
+}
+
+// The default MariaDB migration table column definitions.
+var mariadbColumns = map[string]string{
+	"id":           "INT                                   NOT NULL AUTO_INCREMENT PRIMARY KEY",
+	"name":         "VARCHAR(64)                           NOT NULL UNIQUE KEY",
+	"hash":         "VARCHAR(64)                           NOT NULL",
+	"date":         "TIMESTAMP    DEFAULT CURRENT_TIMESTAMP NOT NULL",
+	"comment":      "VARCHAR(512)                          NOT NULL",
+	"execution_ms": "INTEGER      DEFAULT 0                 NOT NULL",
+	"applied_by":   "VARCHAR(128) DEFAULT ''                NOT NULL",
+}
+
+// The upgrades of MariaDB migration tables created by older versions of sqlxm.
+var mariadbUpgrades = []SchemaUpgrade{
+	{Version: 1, Column: RecordColumn{Name: "execution_ms", Type: "INTEGER", Default: "0"}},
+	{Version: 2, Column: RecordColumn{Name: "applied_by", Type: "VARCHAR(128)", Default: "''"}},
+}
+
+// Setup does the initial configuration of the backend.
+func (m *MariaDB) Setup(db *sqlx.DB, table string, tableSchema string) {
+	table, tableSchema = splitTableName(table, tableSchema)
+	m.db = db
+	m.table = table
+	m.tableSchema = tableSchema
+}
+
+// Ping checks that the database can be reached.
+func (m *MariaDB) Ping(ctx context.Context) error {
+	return m.db.PingContext(ctx)
+}
+
+// qualified returns the migration table name qualified with the database name
+// if there is one.
+func (m *MariaDB) qualified() string {
+	if m.tableSchema == "" {
+		return m.table
+	}
+	return fmt.Sprintf("%s.%s", m.tableSchema, m.table)
+}
+
+// InsertRecord migration record into the DB.
+func (m *MariaDB) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return m.InsertRecordContext(context.Background(), tx, name, hash, comment, executionMs)
+}
+
+// InsertRecordContext is like InsertRecord but uses ctx.
+func (m *MariaDB) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return m.InsertRecordWithApplier(ctx, tx, name, hash, comment, executionMs, "")
+}
+
+// InsertRecordWithApplier is like InsertRecordContext, and also records who
+// applied the migration and the values of any extra columns.
+func (m *MariaDB) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...ExtraField) error {
+	names, values, args := extraFields(extra, "", 5, questionPlaceholder)
+	q := nameTable(`INSERT INTO ?? (name, hash, comment, execution_ms, applied_by`+names+`) VALUES (?, ?, ?, ?, ?`+values+`);`, m.qualified())
+
+	args = append([]interface{}{name, hash, comment, executionMs, appliedBy}, args...)
+	return InsertRecordContext(ctx, tx, q, args...)
+}
+
+// HasMigrationTable returns true if the migration table exists.
+func (m *MariaDB) HasMigrationTable() (bool, error) {
+	return m.HasMigrationTableContext(context.Background())
+}
+
+// HasMigrationTableContext is like HasMigrationTable but uses ctx.
+func (m *MariaDB) HasMigrationTableContext(ctx context.Context) (bool, error) {
+	// Views and sequences have no engine.
+	q := fmt.Sprintf(`SELECT EXISTS(
+		SELECT * FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = '%s'
+		AND TABLE_NAME = '%s'
+		AND ENGINE IS NOT NULL
+	);`, m.tableSchema, m.table)
+	return HasMigrationTableContext(ctx, m.db, q)
+}
+
+// QueryPrevious queries and sets the records of all previous migrations.
+func (m *MariaDB) QueryPrevious() (map[string]string, error) {
+	return m.QueryPreviousContext(context.Background())
+}
+
+// QueryPreviousContext is like QueryPrevious but uses ctx.
+func (m *MariaDB) QueryPreviousContext(ctx context.Context) (map[string]string, error) {
+	q := nameTable(`SELECT name, hash FROM ??;`, m.qualified())
+	return QueryPreviousContext(ctx, m.db, q)
+}
+
+// CreateMigrationTable makes the migrations table, and return the query used to
+// do it.
+func (m *MariaDB) CreateMigrationTable() (string, error) {
+	return m.CreateMigrationTableContext(context.Background())
+}
+
+// CreateMigrationTableContext is like CreateMigrationTable but uses ctx.
+func (m *MariaDB) CreateMigrationTableContext(ctx context.Context) (string, error) {
+	q := nameTable(`CREATE TABLE ?? (
+		id      {id},
+		name    {name},
+		hash    {hash},
+		date    {date},
+        comment {comment},
+        execution_ms {execution_ms},
+        applied_by {applied_by}`+extraColumns(m.extra, "")+`
+	)
+	ENGINE = InnoDB
+	COMMENT 'list the schema changes';`, m.qualified(), mariadbColumns, m.columns)
+
+	return CreateMigrationTableContext(ctx, m.db, q)
+}
+
+func (m *MariaDB) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
+	q := nameTable(`UPDATE ?? SET hash = ? WHERE name = ?`, m.qualified())
+	return RepairHashes(tx, q, hashes)
+}
+
+// OverrideColumns sets custom column definitions to use in place of the
+// defaults when the migration table is created.
+func (m *MariaDB) OverrideColumns(columns map[string]string) {
+	m.columns = columns
+}
+
+// ExtendColumns sets extra columns to add to the migration table when it is
+// created.
+func (m *MariaDB) ExtendColumns(columns []RecordColumn) {
+	m.extra = columns
+}
+
+// AddColumns adds the columns the existing migration table doesn't have,
+// and returns the queries used to do it.
+func (m *MariaDB) AddColumns(ctx context.Context, columns []RecordColumn) (string, error) {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, m.qualified())
+	return AddColumns(ctx, m.db, q, columns, m.addColumn)
+}
+
+// addColumn returns the query that adds c to the migration table.
+func (m *MariaDB) addColumn(c RecordColumn) string {
+	return nameTable(fmt.Sprintf(`ALTER TABLE ?? ADD COLUMN %s %s;`, c.Name, c.Definition()), m.qualified())
+}
+
+// MigrateMigrationTable upgrades an existing migration table created by an
+// older version of sqlxm, adding the columns it doesn't have.
+func (m *MariaDB) MigrateMigrationTable() error {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, m.qualified())
+	v := nameTable(`SELECT COALESCE(MAX(schema_version), 0) FROM ??;`, m.qualified())
+	u := nameTable(`UPDATE ?? SET schema_version = ?;`, m.qualified())
+	return MigrateMigrationTable(context.Background(), m.db, q, v, u, mariadbUpgrades, m.addColumn)
+}
+
+// ListTables returns the names of all the tables in the database schema.
+func (m *MariaDB) ListTables() ([]string, error) {
+	q := `SELECT TABLE_NAME FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = ?
+		AND TABLE_TYPE = 'BASE TABLE'
+		ORDER BY TABLE_NAME;`
+	return ListTables(m.db, q, m.tableSchema)
+}
+
+// QueryRecords returns all the migration records ordered by id.
+func (m *MariaDB) QueryRecords(q sqlx.Queryer) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT id, name, hash, date, comment, applied_by FROM ?? ORDER BY id;`, m.qualified())
+	return QueryRecords(q, query)
+}
+
+// CountRecords returns the number of migration records.
+func (m *MariaDB) CountRecords(ctx context.Context) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ??;`, m.qualified())
+	return CountRecords(ctx, m.db, q)
+}
+
+// QueryPreviousPrefix is like QueryPreviousContext, but only returns the
+// records whose names start with prefix.
+func (m *MariaDB) QueryPreviousPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	q := nameTable(`SELECT name, hash FROM ?? WHERE name LIKE ? ESCAPE '!';`, m.qualified())
+	return QueryPreviousContext(ctx, m.db, q, LikePrefix(prefix))
+}
+
+// QueryRecordsPrefix is like QueryRecords, but only returns the records whose
+// names start with prefix.
+func (m *MariaDB) QueryRecordsPrefix(q sqlx.Queryer, prefix string) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT id, name, hash, date, comment, applied_by FROM ?? WHERE name LIKE ? ESCAPE '!' ORDER BY id;`, m.qualified())
+	return QueryRecords(q, query, LikePrefix(prefix))
+}
+
+// CountRecordsPrefix is like CountRecords, but only counts the records whose
+// names start with prefix.
+func (m *MariaDB) CountRecordsPrefix(ctx context.Context, prefix string) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ?? WHERE name LIKE ? ESCAPE '!';`, m.qualified())
+	return CountRecords(ctx, m.db, q, LikePrefix(prefix))
+}
+
+// QueryChecksum returns the stored migration table checksum, creating the
+// checksum table if it does not exist.
+func (m *MariaDB) QueryChecksum() (string, error) {
+	create := nameTable(`CREATE TABLE IF NOT EXISTS ??_checksum (
+		checksum   VARCHAR(64) NOT NULL,
+		updated_at TIMESTAMP   NOT NULL
+	);`, m.qualified())
+	q := nameTable(`SELECT checksum FROM ??_checksum;`, m.qualified())
+	return QueryChecksum(m.db, create, q)
+}
+
+// StoreChecksum replaces the stored migration table checksum.
+func (m *MariaDB) StoreChecksum(tx *sqlx.Tx, checksum string) error {
+	d := nameTable(`DELETE FROM ??_checksum;`, m.qualified())
+	i := nameTable(`INSERT INTO ??_checksum (checksum, updated_at) VALUES (?, CURRENT_TIMESTAMP);`, m.qualified())
+	return StoreChecksum(tx, d, i, checksum)
+}
+
+// DeleteRecord deletes a migration record from the DB.
+func (m *MariaDB) DeleteRecord(tx *sqlx.Tx, name string) error {
+	q := nameTable(`DELETE FROM ?? WHERE name = ?;`, m.qualified())
+	return DeleteRecord(tx, q, name)
+}
+
+// UpdateComment replaces the comment of a migration record.
+func (m *MariaDB) UpdateComment(tx *sqlx.Tx, name string, comment string) error {
+	q := nameTable(`UPDATE ?? SET comment = ? WHERE name = ?;`, m.qualified())
+	return UpdateComment(tx, q, name, comment)
+}
+
+// CleanTable deletes every migration record, keeping the table.
+func (m *MariaDB) CleanTable() error {
+	q := nameTable(`DELETE FROM ??;`, m.qualified())
+	return CleanTable(m.db, q)
+}
+
+// ArchiveRecords moves the migration records dated before before to the
+// "<table>_history" table. MariaDB commits the transaction when it creates a
+// table, so the history table is not created here, see CreateHistoryTable.
+func (m *MariaDB) ArchiveRecords(tx *sqlx.Tx, before time.Time) error {
+	i := nameTable(`INSERT INTO ??_history SELECT * FROM ?? WHERE date < ?;`, m.qualified())
+	d := nameTable(`DELETE FROM ?? WHERE date < ?;`, m.qualified())
+	return ArchiveRecords(tx, "", i, d, before)
+}
+
+// CreateHistoryTable creates the "<table>_history" table of ArchiveRecords on
+// the DB, outside of the transaction the records are moved in.
+func (m *MariaDB) CreateHistoryTable(ctx context.Context) error {
+	q := nameTable(`CREATE TABLE IF NOT EXISTS ??_history LIKE ??;`, m.qualified())
+	_, err := m.db.ExecContext(ctx, q)
+	return err
+}
+
+// Lock acquires a named lock keyed on the migration table name with GET_LOCK.
+// The lock is held by a dedicated connection until Unlock is called.
+// The migrations are run on another connection, so a DB limited to one open
+// connection with SetMaxOpenConns(1) deadlocks.
+func (m *MariaDB) Lock(ctx context.Context, timeout time.Duration) error {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	// GET_LOCK returns 1 if the lock was acquired, 0 on timeout, and NULL on error.
+	// MariaDB supports fractional timeouts.
+	locked := sql.NullInt64{}
+	err = conn.GetContext(ctx, &locked, `SELECT GET_LOCK(?, ?);`, m.table, timeout.Seconds())
+	if err == nil && locked.Int64 != 1 {
+		err = ErrLockTimeout
+		if !locked.Valid {
+			err = fmt.Errorf("GET_LOCK('%s') failed", m.table)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	m.lockConn = conn
+	return nil
+}
+
+// Unlock releases the named lock acquired by Lock.
+func (m *MariaDB) Unlock(ctx context.Context) error {
+	if m.lockConn == nil {
+		return nil
+	}
+	defer func() {
+		m.lockConn.Close()
+		m.lockConn = nil
+	}()
+	_, err := m.lockConn.ExecContext(ctx, `SELECT RELEASE_LOCK(?);`, m.table)
+	return err
+}
@@ -0,0 +1,341 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Oracle is the backend for Oracle Database 12c and later.
+//
+// DATE and COMMENT are reserved words in Oracle, so the migration table columns
+// are quoted and stored in lowercase to match the other backends. Oracle also
+// stores empty strings as NULL, so the comment column is nullable.
+type Oracle struct {
+	// The database connection to use for this backend.
+	db *sqlx.DB
+	// The migration table name
+	table string
+	// The schema (owner) of the migration table. The current schema is used if
+	// it is empty.
+	tableSchema string
+	// Custom migration table column definitions.
+	columns map[string]string
+	// Extra migration table columns.
+	extra []RecordColumn
+}
+
+// The default Oracle migration table column definitions.
+var oracleColumns = map[string]string{
+	"id":           "NUMBER GENERATED ALWAYS AS IDENTITY PRIMARY KEY",
+	"name":         "VARCHAR2(64)                      NOT NULL",
+	"hash":         "VARCHAR2(64)                      NOT NULL",
+	"date":         "TIMESTAMP    DEFAULT SYSTIMESTAMP NOT NULL",
+	"comment":      "VARCHAR2(512)",
+	"execution_ms": "INTEGER      DEFAULT 0            NOT NULL",
+	"applied_by":   "VARCHAR2(128)",
+}
+
+// The upgrades of Oracle migration tables created by older versions of sqlxm.
+var oracleUpgrades = []SchemaUpgrade{
+	{Version: 1, Column: RecordColumn{Name: "execution_ms", Type: "INTEGER", Default: "0"}},
+	{Version: 2, Column: RecordColumn{Name: "applied_by", Type: "VARCHAR2(128)", Nullable: true}},
+}
+
+// Setup does the initial configuration of the backend.
+func (o *Oracle) Setup(db *sqlx.DB, table string, tableSchema string) {
+	o.db = db
+	o.table = table
+	o.tableSchema = tableSchema
+}
+
+// Ping checks that the database can be reached.
+func (o *Oracle) Ping(ctx context.Context) error {
+	return o.db.PingContext(ctx)
+}
+
+// qualified returns the schema qualified migration table name.
+func (o *Oracle) qualified() string {
+	if o.tableSchema == "" {
+		return o.table
+	}
+	return fmt.Sprintf("%s.%s", o.tableSchema, o.table)
+}
+
+// owner is the condition used to match the table owner in ALL_TABLES.
+func (o *Oracle) owner() string {
+	return fmt.Sprintf(`OWNER = NVL(UPPER('%s'), SYS_CONTEXT('USERENV', 'CURRENT_SCHEMA'))`, o.tableSchema)
+}
+
+// InsertRecord migration record into the DB.
+func (o *Oracle) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return o.InsertRecordContext(context.Background(), tx, name, hash, comment, executionMs)
+}
+
+// InsertRecordContext is like InsertRecord but uses ctx.
+func (o *Oracle) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return o.InsertRecordWithApplier(ctx, tx, name, hash, comment, executionMs, "")
+}
+
+// InsertRecordWithApplier is like InsertRecordContext, and also records who
+// applied the migration and the values of any extra columns.
+func (o *Oracle) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...ExtraField) error {
+	names, values, args := extraFields(extra, "\"", 5, colonPlaceholder)
+	q := nameTable(`INSERT INTO ?? ("name", "hash", "comment", "execution_ms", "applied_by"`+names+`) VALUES (:1, :2, :3, :4, :5`+values+`)`, o.qualified())
+
+	args = append([]interface{}{name, hash, comment, executionMs, appliedBy}, args...)
+	return InsertRecordContext(ctx, tx, q, args...)
+}
+
+// HasMigrationTable returns true if the migration table exists.
+func (o *Oracle) HasMigrationTable() (bool, error) {
+	return o.HasMigrationTableContext(context.Background())
+}
+
+// HasMigrationTableContext is like HasMigrationTable but uses ctx.
+func (o *Oracle) HasMigrationTableContext(ctx context.Context) (bool, error) {
+	q := fmt.Sprintf(`SELECT CASE WHEN COUNT(*) > 0 THEN 'true' ELSE 'false' END
+		FROM ALL_TABLES
+		WHERE %s
+		AND TABLE_NAME = UPPER('%s')`, o.owner(), o.table)
+	return HasMigrationTableContext(ctx, o.db, q)
+}
+
+// QueryPrevious queries and sets the records of all previous migrations.
+func (o *Oracle) QueryPrevious() (map[string]string, error) {
+	return o.QueryPreviousContext(context.Background())
+}
+
+// QueryPreviousContext is like QueryPrevious but uses ctx.
+func (o *Oracle) QueryPreviousContext(ctx context.Context) (map[string]string, error) {
+	q := nameTable(`SELECT "name", "hash" FROM ??`, o.qualified())
+	return QueryPreviousContext(ctx, o.db, q)
+}
+
+// CreateMigrationTable makes the migrations table, and return the query used to
+// do it.
+func (o *Oracle) CreateMigrationTable() (string, error) {
+	return o.CreateMigrationTableContext(context.Background())
+}
+
+// CreateMigrationTableContext is like CreateMigrationTable but uses ctx.
+func (o *Oracle) CreateMigrationTableContext(ctx context.Context) (string, error) {
+	q := nameTable(`CREATE TABLE ?? (
+		"id"      {id},
+		"name"    {name},
+		"hash"    {hash},
+		"date"    {date},
+		"comment" {comment},
+		"execution_ms" {execution_ms},
+		"applied_by" {applied_by}`+extraColumns(o.extra, "\"")+`,
+		CONSTRAINT `+o.table+`_name_uindex UNIQUE ("name")
+	)`, o.qualified(), oracleColumns, o.columns)
+	return CreateMigrationTableContext(ctx, o.db, q)
+}
+
+func (o *Oracle) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
+	q := nameTable(`UPDATE ?? SET "hash" = :1 WHERE "name" = :2`, o.qualified())
+	return RepairHashes(tx, q, hashes)
+}
+
+// OverrideColumns sets custom column definitions to use in place of the
+// defaults when the migration table is created.
+func (o *Oracle) OverrideColumns(columns map[string]string) {
+	o.columns = columns
+}
+
+// ExtendColumns sets extra columns to add to the migration table when it is
+// created.
+func (o *Oracle) ExtendColumns(columns []RecordColumn) {
+	o.extra = columns
+}
+
+// AddColumns adds the columns the existing migration table doesn't have,
+// and returns the queries used to do it.
+func (o *Oracle) AddColumns(ctx context.Context, columns []RecordColumn) (string, error) {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, o.qualified())
+	return AddColumns(ctx, o.db, q, columns, o.addColumn)
+}
+
+// addColumn returns the query that adds c to the migration table.
+func (o *Oracle) addColumn(c RecordColumn) string {
+	return nameTable(fmt.Sprintf(`ALTER TABLE ?? ADD ("%s" %s)`, c.Name, c.Definition()), o.qualified())
+}
+
+// MigrateMigrationTable upgrades an existing migration table created by an
+// older version of sqlxm, adding the columns it doesn't have.
+func (o *Oracle) MigrateMigrationTable() error {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, o.qualified())
+	v := nameTable(`SELECT COALESCE(MAX("schema_version"), 0) FROM ??`, o.qualified())
+	u := nameTable(`UPDATE ?? SET "schema_version" = :1`, o.qualified())
+	return MigrateMigrationTable(context.Background(), o.db, q, v, u, oracleUpgrades, o.addColumn)
+}
+
+// ListTables returns the names of all the tables in the database schema.
+func (o *Oracle) ListTables() ([]string, error) {
+	q := fmt.Sprintf(`SELECT TABLE_NAME FROM ALL_TABLES
+		WHERE %s
+		ORDER BY TABLE_NAME`, o.owner())
+	return ListTables(o.db, q)
+}
+
+// QueryRecords returns all the migration records ordered by id.
+func (o *Oracle) QueryRecords(q sqlx.Queryer) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT "id", "name", "hash", "date", "comment", "applied_by" FROM ?? ORDER BY "id"`, o.qualified())
+	return QueryRecords(q, query)
+}
+
+// CountRecords returns the number of migration records.
+func (o *Oracle) CountRecords(ctx context.Context) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ??`, o.qualified())
+	return CountRecords(ctx, o.db, q)
+}
+
+// QueryPreviousPrefix is like QueryPreviousContext, but only returns the
+// records whose names start with prefix.
+func (o *Oracle) QueryPreviousPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	q := nameTable(`SELECT "name", "hash" FROM ?? WHERE "name" LIKE :1 ESCAPE '!'`, o.qualified())
+	return QueryPreviousContext(ctx, o.db, q, LikePrefix(prefix))
+}
+
+// QueryRecordsPrefix is like QueryRecords, but only returns the records whose
+// names start with prefix.
+func (o *Oracle) QueryRecordsPrefix(q sqlx.Queryer, prefix string) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT "id", "name", "hash", "date", "comment", "applied_by" FROM ?? WHERE "name" LIKE :1 ESCAPE '!' ORDER BY "id"`, o.qualified())
+	return QueryRecords(q, query, LikePrefix(prefix))
+}
+
+// CountRecordsPrefix is like CountRecords, but only counts the records whose
+// names start with prefix.
+func (o *Oracle) CountRecordsPrefix(ctx context.Context, prefix string) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ?? WHERE "name" LIKE :1 ESCAPE '!'`, o.qualified())
+	return CountRecords(ctx, o.db, q, LikePrefix(prefix))
+}
+
+// createIfNotExists wraps a CREATE TABLE statement so it is ignored if the table
+// already exists (ORA-00955).
+func createIfNotExists(create string) string {
+	return fmt.Sprintf(`BEGIN
+		EXECUTE IMMEDIATE '%s';
+	EXCEPTION
+		WHEN OTHERS THEN
+			IF SQLCODE != -955 THEN
+				RAISE;
+			END IF;
+	END;`, create)
+}
+
+// QueryChecksum returns the stored migration table checksum, creating the
+// checksum table if it does not exist.
+func (o *Oracle) QueryChecksum() (string, error) {
+	create := createIfNotExists(nameTable(`CREATE TABLE ??_checksum (
+		"checksum"   VARCHAR2(64) NOT NULL,
+		"updated_at" TIMESTAMP    NOT NULL
+	)`, o.qualified()))
+	q := nameTable(`SELECT "checksum" FROM ??_checksum`, o.qualified())
+	return QueryChecksum(o.db, create, q)
+}
+
+// StoreChecksum replaces the stored migration table checksum.
+func (o *Oracle) StoreChecksum(tx *sqlx.Tx, checksum string) error {
+	d := nameTable(`DELETE FROM ??_checksum`, o.qualified())
+	i := nameTable(`INSERT INTO ??_checksum ("checksum", "updated_at") VALUES (:1, SYSTIMESTAMP)`, o.qualified())
+	return StoreChecksum(tx, d, i, checksum)
+}
+
+// DeleteRecord deletes a migration record from the DB.
+func (o *Oracle) DeleteRecord(tx *sqlx.Tx, name string) error {
+	q := nameTable(`DELETE FROM ?? WHERE "name" = :1`, o.qualified())
+	return DeleteRecord(tx, q, name)
+}
+
+// UpdateComment replaces the comment of a migration record.
+func (o *Oracle) UpdateComment(tx *sqlx.Tx, name string, comment string) error {
+	q := nameTable(`UPDATE ?? SET "comment" = :1 WHERE "name" = :2`, o.qualified())
+	return UpdateComment(tx, q, name, comment)
+}
+
+// CleanTable deletes every migration record, keeping the table.
+func (o *Oracle) CleanTable() error {
+	q := nameTable(`DELETE FROM ??`, o.qualified())
+	return CleanTable(o.db, q)
+}
+
+// ArchiveRecords moves the migration records dated before before to the
+// "<table>_history" table. Oracle commits the transaction when it creates a
+// table, so the history table is not created here, see CreateHistoryTable.
+func (o *Oracle) ArchiveRecords(tx *sqlx.Tx, before time.Time) error {
+	i := nameTable(`INSERT INTO ??_history SELECT * FROM ?? WHERE "date" < :1`, o.qualified())
+	d := nameTable(`DELETE FROM ?? WHERE "date" < :1`, o.qualified())
+	return ArchiveRecords(tx, "", i, d, before)
+}
+
+// CreateHistoryTable creates the "<table>_history" table of ArchiveRecords on
+// the DB, outside of the transaction the records are moved in. Oracle has no
+// CREATE TABLE IF NOT EXISTS, so the error for an existing table (ORA-00955) is
+// ignored.
+func (o *Oracle) CreateHistoryTable(ctx context.Context) error {
+	q := nameTable(`BEGIN
+		EXECUTE IMMEDIATE 'CREATE TABLE ??_history AS SELECT * FROM ?? WHERE 1 = 0';
+	EXCEPTION
+		WHEN OTHERS THEN
+			IF SQLCODE != -955 THEN
+				RAISE;
+			END IF;
+	END;`, o.qualified())
+	_, err := o.db.ExecContext(ctx, q)
+	return err
+}
+
+// Lock acquires the lock by inserting the only row into the "<table>_lock"
+// table. DBMS_LOCK is not used since it needs to be granted by a DBA.
+func (o *Oracle) Lock(ctx context.Context, timeout time.Duration) error {
+	create := createIfNotExists(nameTable(`CREATE TABLE ??_lock (
+		"id"          NUMBER    PRIMARY KEY CHECK ("id" = 1),
+		"acquired_at" TIMESTAMP NOT NULL
+	)`, o.qualified()))
+	_, err := o.db.ExecContext(ctx, create)
+	if err != nil {
+		return err
+	}
+	q := nameTable(`INSERT /*+ IGNORE_ROW_ON_DUPKEY_INDEX(l ("id")) */
+		INTO ??_lock l ("id", "acquired_at") VALUES (1, SYSTIMESTAMP)`, o.qualified())
+	return TryLock(ctx, timeout, func() (bool, error) {
+		res, err := o.db.ExecContext(ctx, q)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n == 1, err
+	})
+}
+
+// Unlock releases the lock acquired by Lock.
+func (o *Oracle) Unlock(ctx context.Context) error {
+	q := nameTable(`DELETE FROM ??_lock WHERE "id" = 1`, o.qualified())
+	_, err := o.db.ExecContext(ctx, q)
+	return err
+}
+
+// colonPlaceholder is the placeholder used by Oracle.
+func colonPlaceholder(n int) string {
+	return fmt.Sprintf(":%d", n)
+}
+
+// Savepoint implements Savepointer.
+func (o *Oracle) Savepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	return Savepoint(ctx, tx, name)
+}
+
+// RollbackToSavepoint implements Savepointer.
+func (o *Oracle) RollbackToSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	return RollbackToSavepoint(ctx, tx, name)
+}
+
+// ReleaseSavepoint implements Savepointer. Oracle can't release a savepoint, so
+// nothing is done.
+func (o *Oracle) ReleaseSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package backends
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// PlanetScale is the backend for PlanetScale (Vitess). PlanetScale speaks the
+// MySQL protocol, but does not support DDL inside a transaction. DDL statements
+// are run outside the migration transaction, and DML statements and the
+// migration records stay in it.
+//
+// Since DDL is committed when it is run, a failed run can leave the DDL of the
+// earlier migrations applied without their records.
+type PlanetScale struct {
+	MySQL
+}
+
+// The first keywords of DDL statements.
+var ddlKeywords = map[string]struct{}{
+	"ALTER":    {},
+	"CREATE":   {},
+	"DROP":     {},
+	"RENAME":   {},
+	"TRUNCATE": {},
+}
+
+// RunStatement runs DDL statements on the DB outside of tx, and all other
+// statements in tx.
+func (p *PlanetScale) RunStatement(ctx context.Context, tx *sqlx.Tx, statement string, args ...interface{}) error {
+	var err error
+	if isDDL(statement) {
+		_, err = p.db.ExecContext(ctx, statement, args...)
+	} else {
+		_, err = tx.ExecContext(ctx, statement, args...)
+	}
+	return err
+}
+
+// isDDL returns true if the first keyword of the statement is a DDL keyword.
+// Leading whitespace and comments are skipped.
+func isDDL(statement string) bool {
+	_, ok := ddlKeywords[strings.ToUpper(firstKeyword(statement))]
+	return ok
+}
+
+// firstKeyword returns the first word of the statement after any leading
+// whitespace, "--" comments and "/* */" comments.
+func firstKeyword(statement string) string {
+	start, end := keywordBounds(statement)
+	return statement[start:end]
+}
+
+// keywordBounds returns the start and end index of the first word of the
+// statement, like firstKeyword. Both are the length of the statement if it has
+// no words.
+func keywordBounds(statement string) (int, int) {
+	i := 0
+	for {
+		s := strings.TrimLeftFunc(statement[i:], unicode.IsSpace)
+		i = len(statement) - len(s)
+		switch {
+		case strings.HasPrefix(s, "--"):
+			j := strings.IndexByte(s, '\n')
+			if j < 0 {
+				return len(statement), len(statement)
+			}
+			i += j + 1
+		case strings.HasPrefix(s, "/*"):
+			j := strings.Index(s, "*/")
+			if j < 0 {
+				return len(statement), len(statement)
+			}
+			i += j + 2
+		default:
+			end := strings.IndexFunc(s, func(r rune) bool {
+				return !unicode.IsLetter(r)
+			})
+			if end < 0 {
+				return i, len(statement)
+			}
+			return i, i + end
+		}
+	}
+}
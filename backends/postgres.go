@@ -1,9 +1,16 @@
 package backends
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type Postgres struct {
@@ -13,57 +20,371 @@ type Postgres struct {
 	table string
 	// The SQL 'table_schema' usually is 'public'
 	tableSchema string
+	// Custom migration table column definitions.
+	columns map[string]string
+	// Extra migration table columns.
+	extra []RecordColumn
+	// The connection holding the advisory lock.
+	lockConn *sqlx.Conn
+	// Where the notice handler writes notices, and the handler registered by
+	// Setup.
+	notices       io.Writer
+	noticeHandler func(*pq.Error)
+}
+
+// The default Postgres migration table column definitions.
+var postgresColumns = map[string]string{
+	"id": `SERIAL
+			CONSTRAINT ??_pk PRIMARY KEY`,
+	"name":         "VARCHAR(64)                NOT NULL",
+	"hash":         "VARCHAR(64)                NOT NULL",
+	"date":         "TIMESTAMP    DEFAULT NOW() NOT NULL",
+	"comment":      "VARCHAR(512)               NOT NULL",
+	"execution_ms": "INTEGER      DEFAULT 0     NOT NULL",
+	"applied_by":   "VARCHAR(128) DEFAULT ''    NOT NULL",
+}
+
+// The upgrades of Postgres migration tables created by older versions of sqlxm.
+var postgresUpgrades = []SchemaUpgrade{
+	{Version: 1, Column: RecordColumn{Name: "execution_ms", Type: "INTEGER", Default: "0"}},
+	{Version: 2, Column: RecordColumn{Name: "applied_by", Type: "VARCHAR(128)", Default: "''"}},
+}
+
+// The first keywords of the statements Postgres EXPLAIN can analyze.
+var postgresExplainKeywords = map[string]struct{}{
+	"DELETE": {},
+	"INSERT": {},
+	"SELECT": {},
+	"UPDATE": {},
+	"VALUES": {},
+	"WITH":   {},
 }
 
 // Setup does the initial configuration of the backend.
 func (p *Postgres) Setup(db *sqlx.DB, table string, tableSchema string) {
+	table, tableSchema = splitTableName(table, tableSchema)
+	if tableSchema == "" {
+		tableSchema = "public"
+	}
 	p.db = db
 	p.table = table
 	p.tableSchema = tableSchema
+	p.noticeHandler = nil
+	// Notices can only be captured with the lib/pq driver.
+	if _, ok := db.Driver().(*pq.Driver); ok && p.notices != nil {
+		w := p.notices
+		p.noticeHandler = func(e *pq.Error) {
+			fmt.Fprintf(w, "%s: %s\n", e.Severity, e.Message)
+		}
+	}
+}
+
+// CaptureNotices sets the writer the notice handler registered by the next
+// Setup writes to, as "SEVERITY: message" lines.
+func (p *Postgres) CaptureNotices(w io.Writer) {
+	p.notices = w
+}
+
+// WatchNotices sets the notice handler on conn. Nothing is done if Setup did
+// not register a handler, e.g. because the driver is not lib/pq.
+func (p *Postgres) WatchNotices(ctx context.Context, conn *sqlx.Conn) (func(), error) {
+	handler := p.noticeHandler
+	if handler == nil {
+		return func() {}, nil
+	}
+	err := conn.Raw(func(c interface{}) error {
+		pq.SetNoticeHandler(c.(driver.Conn), handler)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		conn.Raw(func(c interface{}) error {
+			pq.SetNoticeHandler(c.(driver.Conn), nil)
+			return nil
+		})
+	}, nil
+}
+
+// Ping checks that the database can be reached.
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// qualified returns the schema qualified migration table name.
+func (p *Postgres) qualified() string {
+	return fmt.Sprintf("%s.%s", p.tableSchema, p.table)
 }
 
 // InsertRecord migration record into the DB.
-func (p *Postgres) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string) error {
-	q := nameTable(`INSERT INTO ?? (name, hash, comment) VALUES ($1, $2, $3);`, p.table)
+func (p *Postgres) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return p.InsertRecordContext(context.Background(), tx, name, hash, comment, executionMs)
+}
 
-	return InsertRecord(tx, q, name, hash, comment)
+// InsertRecordContext is like InsertRecord but uses ctx.
+func (p *Postgres) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return p.InsertRecordWithApplier(ctx, tx, name, hash, comment, executionMs, "")
+}
+
+// InsertRecordWithApplier is like InsertRecordContext, and also records who
+// applied the migration and the values of any extra columns.
+func (p *Postgres) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...ExtraField) error {
+	names, values, args := extraFields(extra, "", 5, dollarPlaceholder)
+	q := nameTable(`INSERT INTO ?? (name, hash, comment, execution_ms, applied_by`+names+`) VALUES ($1, $2, $3, $4, $5`+values+`);`, p.qualified())
+
+	args = append([]interface{}{name, hash, comment, executionMs, appliedBy}, args...)
+	return InsertRecordContext(ctx, tx, q, args...)
+}
+
+// ClaimRecord inserts the migration record with the status StatusRunning, using
+// ON CONFLICT DO NOTHING to skip it if there already is a record for the
+// migration.
+func (p *Postgres) ClaimRecord(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, appliedBy string, extra ...ExtraField) (bool, error) {
+	names, values, args := extraFields(extra, "", 5, dollarPlaceholder)
+	q := nameTable(`INSERT INTO ?? (name, hash, comment, applied_by, status`+names+`) VALUES ($1, $2, $3, $4, $5`+values+`) ON CONFLICT (name) DO NOTHING;`, p.qualified())
+
+	args = append([]interface{}{name, hash, comment, appliedBy, StatusRunning}, args...)
+	return ClaimRecord(ctx, tx, q, args...)
+}
+
+// CompleteRecord sets the status of a claimed record to StatusDone.
+func (p *Postgres) CompleteRecord(ctx context.Context, tx *sqlx.Tx, name string, executionMs int64) error {
+	q := nameTable(`UPDATE ?? SET status = $1, execution_ms = $2 WHERE name = $3;`, p.qualified())
+	_, err := tx.ExecContext(ctx, q, StatusDone, executionMs, name)
+	return err
 }
 
 // HasMigrationTable returns true if the migration table exists.
 func (p *Postgres) HasMigrationTable() (bool, error) {
+	return p.HasMigrationTableContext(context.Background())
+}
+
+// HasMigrationTableContext is like HasMigrationTable but uses ctx.
+func (p *Postgres) HasMigrationTableContext(ctx context.Context) (bool, error) {
 	q := fmt.Sprintf(`SELECT EXISTS(
 		SELECT * FROM information_schema.tables
 		WHERE table_schema = '%s' 
 		AND table_name = '%s'
 	);`, p.tableSchema, p.table)
-	return HasMigrationTable(p.db, q)
+	return HasMigrationTableContext(ctx, p.db, q)
 }
 
 // QueryPrevious queries and sets the records of all previous migrations.
 func (p *Postgres) QueryPrevious() (map[string]string, error) {
-	q := nameTable(`SELECT name, hash FROM ??;`, p.table)
-	return QueryPrevious(p.db, q)
+	return p.QueryPreviousContext(context.Background())
+}
+
+// QueryPreviousContext is like QueryPrevious but uses ctx.
+func (p *Postgres) QueryPreviousContext(ctx context.Context) (map[string]string, error) {
+	q := nameTable(`SELECT name, hash FROM ??;`, p.qualified())
+	return QueryPreviousContext(ctx, p.db, q)
 }
 
 // CreateMigrationTable makes the migrations table, and return the query used to
 // do it.
 func (p *Postgres) CreateMigrationTable() (string, error) {
+	return p.CreateMigrationTableContext(context.Background())
+}
+
+// CreateMigrationTableContext is like CreateMigrationTable but uses ctx.
+func (p *Postgres) CreateMigrationTableContext(ctx context.Context) (string, error) {
 	q := nameTable(`CREATE TABLE ?? (
-		id      SERIAL
-			CONSTRAINT ??_pk PRIMARY KEY,
-		name    VARCHAR(64)                NOT NULL,
-		hash    VARCHAR(32)                NOT NULL,
-		date    TIMESTAMP    DEFAULT NOW() NOT NULL,
-        comment VARCHAR(512)               NOT NULL
+		id      {id},
+		name    {name},
+		hash    {hash},
+		date    {date},
+        comment {comment},
+        execution_ms {execution_ms},
+        applied_by {applied_by}`+extraColumns(p.extra, "")+`
 	);
 	
 	COMMENT ON TABLE ?? IS 'list the schema changes';
 	
-	CREATE UNIQUE INDEX ??_name_uindex ON ?? (name);`, p.table)
-	return CreateMigrationTable(p.db, q)
+	CREATE UNIQUE INDEX `+p.table+`_name_uindex ON ?? (name);`, p.qualified(), nameColumns(postgresColumns, p.table), nameColumns(p.columns, p.table))
+	return CreateMigrationTableContext(ctx, p.db, q)
 }
 
 func (p *Postgres) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
-	q := nameTable(`UPDATE ?? SET hash = $1 WHERE name = $2`, p.table)
+	q := nameTable(`UPDATE ?? SET hash = $1 WHERE name = $2`, p.qualified())
 	return RepairHashes(tx, q, hashes)
 }
+
+// OverrideColumns sets custom column definitions to use in place of the
+// defaults when the migration table is created.
+func (p *Postgres) OverrideColumns(columns map[string]string) {
+	p.columns = columns
+}
+
+// ExtendColumns sets extra columns to add to the migration table when it is
+// created.
+func (p *Postgres) ExtendColumns(columns []RecordColumn) {
+	p.extra = columns
+}
+
+// AddColumns adds the columns the existing migration table doesn't have,
+// and returns the queries used to do it.
+func (p *Postgres) AddColumns(ctx context.Context, columns []RecordColumn) (string, error) {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, p.qualified())
+	return AddColumns(ctx, p.db, q, columns, p.addColumn)
+}
+
+// addColumn returns the query that adds c to the migration table.
+func (p *Postgres) addColumn(c RecordColumn) string {
+	return nameTable(fmt.Sprintf(`ALTER TABLE ?? ADD COLUMN %s %s;`, c.Name, c.Definition()), p.qualified())
+}
+
+// MigrateMigrationTable upgrades an existing migration table created by an
+// older version of sqlxm, adding the columns it doesn't have.
+func (p *Postgres) MigrateMigrationTable() error {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, p.qualified())
+	v := nameTable(`SELECT COALESCE(MAX(schema_version), 0) FROM ??;`, p.qualified())
+	u := nameTable(`UPDATE ?? SET schema_version = $1;`, p.qualified())
+	return MigrateMigrationTable(context.Background(), p.db, q, v, u, postgresUpgrades, p.addColumn)
+}
+
+// ListTables returns the names of all the tables in the database schema.
+func (p *Postgres) ListTables() ([]string, error) {
+	q := `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = $1
+		AND table_type = 'BASE TABLE'
+		ORDER BY table_name;`
+	return ListTables(p.db, q, p.tableSchema)
+}
+
+// QueryRecords returns all the migration records ordered by id.
+func (p *Postgres) QueryRecords(q sqlx.Queryer) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT id, name, hash, date, comment, applied_by FROM ?? ORDER BY id;`, p.qualified())
+	return QueryRecords(q, query)
+}
+
+// CountRecords returns the number of migration records.
+func (p *Postgres) CountRecords(ctx context.Context) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ??;`, p.qualified())
+	return CountRecords(ctx, p.db, q)
+}
+
+// QueryPreviousPrefix is like QueryPreviousContext, but only returns the
+// records whose names start with prefix.
+func (p *Postgres) QueryPreviousPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	q := nameTable(`SELECT name, hash FROM ?? WHERE name LIKE $1 ESCAPE '!';`, p.qualified())
+	return QueryPreviousContext(ctx, p.db, q, LikePrefix(prefix))
+}
+
+// QueryRecordsPrefix is like QueryRecords, but only returns the records whose
+// names start with prefix.
+func (p *Postgres) QueryRecordsPrefix(q sqlx.Queryer, prefix string) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT id, name, hash, date, comment, applied_by FROM ?? WHERE name LIKE $1 ESCAPE '!' ORDER BY id;`, p.qualified())
+	return QueryRecords(q, query, LikePrefix(prefix))
+}
+
+// CountRecordsPrefix is like CountRecords, but only counts the records whose
+// names start with prefix.
+func (p *Postgres) CountRecordsPrefix(ctx context.Context, prefix string) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ?? WHERE name LIKE $1 ESCAPE '!';`, p.qualified())
+	return CountRecords(ctx, p.db, q, LikePrefix(prefix))
+}
+
+// QueryChecksum returns the stored migration table checksum, creating the
+// checksum table if it does not exist.
+func (p *Postgres) QueryChecksum() (string, error) {
+	create := nameTable(`CREATE TABLE IF NOT EXISTS ??_checksum (
+		checksum   VARCHAR(64) NOT NULL,
+		updated_at TIMESTAMP   NOT NULL
+	);`, p.qualified())
+	q := nameTable(`SELECT checksum FROM ??_checksum;`, p.qualified())
+	return QueryChecksum(p.db, create, q)
+}
+
+// StoreChecksum replaces the stored migration table checksum.
+func (p *Postgres) StoreChecksum(tx *sqlx.Tx, checksum string) error {
+	d := nameTable(`DELETE FROM ??_checksum;`, p.qualified())
+	i := nameTable(`INSERT INTO ??_checksum (checksum, updated_at) VALUES ($1, CURRENT_TIMESTAMP);`, p.qualified())
+	return StoreChecksum(tx, d, i, checksum)
+}
+
+// DeleteRecord deletes a migration record from the DB.
+func (p *Postgres) DeleteRecord(tx *sqlx.Tx, name string) error {
+	q := nameTable(`DELETE FROM ?? WHERE name = $1;`, p.qualified())
+	return DeleteRecord(tx, q, name)
+}
+
+// UpdateComment replaces the comment of a migration record.
+func (p *Postgres) UpdateComment(tx *sqlx.Tx, name string, comment string) error {
+	q := nameTable(`UPDATE ?? SET comment = $1 WHERE name = $2;`, p.qualified())
+	return UpdateComment(tx, q, name, comment)
+}
+
+// CleanTable deletes every migration record, keeping the table.
+func (p *Postgres) CleanTable() error {
+	q := nameTable(`DELETE FROM ??;`, p.qualified())
+	return CleanTable(p.db, q)
+}
+
+// ArchiveRecords moves the migration records dated before before to the
+// "<table>_history" table.
+func (p *Postgres) ArchiveRecords(tx *sqlx.Tx, before time.Time) error {
+	c := nameTable(`CREATE TABLE IF NOT EXISTS ??_history (LIKE ?? INCLUDING DEFAULTS);`, p.qualified())
+	i := nameTable(`INSERT INTO ??_history SELECT * FROM ?? WHERE date < $1;`, p.qualified())
+	d := nameTable(`DELETE FROM ?? WHERE date < $1;`, p.qualified())
+	return ArchiveRecords(tx, c, i, d, before)
+}
+
+// Lock acquires a session level advisory lock keyed on the migration table name.
+// The lock is held by a dedicated connection until Unlock is called.
+// The migrations are run on another connection, so a DB limited to one open
+// connection with SetMaxOpenConns(1) deadlocks.
+func (p *Postgres) Lock(ctx context.Context, timeout time.Duration) error {
+	conn, err := p.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	err = TryLock(ctx, timeout, func() (bool, error) {
+		locked := false
+		err := conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock(hashtext($1));`, p.table)
+		return locked, err
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	p.lockConn = conn
+	return nil
+}
+
+// Unlock releases the advisory lock acquired by Lock.
+func (p *Postgres) Unlock(ctx context.Context) error {
+	if p.lockConn == nil {
+		return nil
+	}
+	defer func() {
+		p.lockConn.Close()
+		p.lockConn = nil
+	}()
+	_, err := p.lockConn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1));`, p.table)
+	return err
+}
+
+// TimeoutStatement sets the statement_timeout of tx with SET LOCAL, so it only
+// applies until tx ends.
+func (p *Postgres) TimeoutStatement(ctx context.Context, tx *sqlx.Tx, statement string, timeout time.Duration) (string, error) {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`SET LOCAL statement_timeout = %d;`, timeout.Milliseconds()))
+	return statement, err
+}
+
+// IsQueryTimeout returns true if err has SQLSTATE 57014, which is used when a
+// statement is cancelled. Drivers that don't expose the SQLSTATE are matched
+// on the error message.
+func (p *Postgres) IsQueryTimeout(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return state.SQLState() == "57014"
+	}
+	return strings.Contains(err.Error(), "statement timeout")
+}
+
+// Explain returns the plan of statement from EXPLAIN, or ErrNotApplicable if it is
+// not a query or DML statement, e.g. DDL.
+func (p *Postgres) Explain(ctx context.Context, statement string, args ...interface{}) (string, error) {
+	return Explain(ctx, p.db, "EXPLAIN", statement, postgresExplainKeywords, args...)
+}
@@ -1,7 +1,9 @@
 package backends
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -11,53 +13,377 @@ type SQLite struct {
 	db *sqlx.DB
 	// The migration table name
 	table string
+	// The name of the attached database the migration table is in, if it is
+	// not the main database.
+	tableSchema string
+	// Custom migration table column definitions.
+	columns map[string]string
+	// Extra migration table columns.
+	extra []RecordColumn
+	// Identifies the lock row inserted by Lock.
+	lockID string
+	// The pragmas issued by Setup, and the error of the first one that failed.
+	pragmas   []string
+	pragmaErr error
 }
 
-// Setup does the initial configuration of the backend.
+// The default SQLite migration table column definitions.
+var sqliteColumns = map[string]string{
+	"id":           "INTEGER                             PRIMARY KEY",
+	"name":         "TEXT                                NOT NULL UNIQUE",
+	"hash":         "TEXT                                NOT NULL",
+	"date":         "TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL",
+	"comment":      "TEXT                                NOT NULL",
+	"execution_ms": "INTEGER   DEFAULT 0                 NOT NULL",
+	"applied_by":   "TEXT      DEFAULT ''                NOT NULL",
+}
+
+// The upgrades of SQLite migration tables created by older versions of sqlxm.
+var sqliteUpgrades = []SchemaUpgrade{
+	{Version: 1, Column: RecordColumn{Name: "execution_ms", Type: "INTEGER", Default: "0"}},
+	{Version: 2, Column: RecordColumn{Name: "applied_by", Type: "TEXT", Default: "''"}},
+}
+
+// The first keywords of the statements SQLite EXPLAIN can analyze.
+var sqliteExplainKeywords = map[string]struct{}{
+	"DELETE":  {},
+	"INSERT":  {},
+	"REPLACE": {},
+	"SELECT":  {},
+	"UPDATE":  {},
+	"WITH":    {},
+}
+
+// Setup does the initial configuration of the backend. The tableSchema is the
+// name of an attached database, and the main database is used if it is empty.
 func (s *SQLite) Setup(db *sqlx.DB, table string, tableSchema string) {
+	table, tableSchema = splitTableName(table, tableSchema)
 	s.db = db
 	s.table = table
+	s.tableSchema = tableSchema
+	s.pragmaErr = nil
+	for _, p := range s.pragmas {
+		_, err := db.Exec("PRAGMA " + p + ";")
+		if err != nil {
+			s.pragmaErr = fmt.Errorf("PRAGMA %s failed: %w", p, err)
+			break
+		}
+	}
+}
+
+// SetPragmas sets the pragmas issued by the next Setup. They are run outside of
+// a transaction on a connection from the pool, so pragmas that only apply to a
+// connection, like foreign_keys, don't apply to the other connections. Setup
+// can't return an error, so a failed pragma is returned by Ping.
+func (s *SQLite) SetPragmas(pragmas []string) {
+	s.pragmas = pragmas
+}
+
+// Ping checks that the database can be reached, and that the pragmas issued by
+// Setup succeeded.
+func (s *SQLite) Ping(ctx context.Context) error {
+	if s.pragmaErr != nil {
+		return s.pragmaErr
+	}
+	return s.db.PingContext(ctx)
+}
+
+// qualified returns the migration table name qualified with the attached
+// database name if there is one.
+func (s *SQLite) qualified() string {
+	if s.tableSchema == "" {
+		return s.table
+	}
+	return fmt.Sprintf("%s.%s", s.tableSchema, s.table)
 }
 
 // InsertRecord migration record into the DB.
-func (s *SQLite) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string) error {
-	q := nameTable(`INSERT INTO ?? (name, hash, comment) VALUES (?, ?, ?);`, s.table)
+func (s *SQLite) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return s.InsertRecordContext(context.Background(), tx, name, hash, comment, executionMs)
+}
 
-	return InsertRecord(tx, q, name, hash, comment)
+// InsertRecordContext is like InsertRecord but uses ctx.
+func (s *SQLite) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return s.InsertRecordWithApplier(ctx, tx, name, hash, comment, executionMs, "")
+}
+
+// InsertRecordWithApplier is like InsertRecordContext, and also records who
+// applied the migration and the values of any extra columns.
+func (s *SQLite) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...ExtraField) error {
+	names, values, args := extraFields(extra, "", 5, questionPlaceholder)
+	q := nameTable(`INSERT INTO ?? (name, hash, comment, execution_ms, applied_by`+names+`) VALUES (?, ?, ?, ?, ?`+values+`);`, s.qualified())
+
+	args = append([]interface{}{name, hash, comment, executionMs, appliedBy}, args...)
+	return InsertRecordContext(ctx, tx, q, args...)
+}
+
+// ClaimRecord inserts the migration record with the status StatusRunning, using
+// INSERT OR IGNORE to skip it if there already is a record for the migration.
+func (s *SQLite) ClaimRecord(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, appliedBy string, extra ...ExtraField) (bool, error) {
+	names, values, args := extraFields(extra, "", 5, questionPlaceholder)
+	q := nameTable(`INSERT OR IGNORE INTO ?? (name, hash, comment, applied_by, status`+names+`) VALUES (?, ?, ?, ?, ?`+values+`);`, s.qualified())
+
+	args = append([]interface{}{name, hash, comment, appliedBy, StatusRunning}, args...)
+	return ClaimRecord(ctx, tx, q, args...)
+}
+
+// CompleteRecord sets the status of a claimed record to StatusDone.
+func (s *SQLite) CompleteRecord(ctx context.Context, tx *sqlx.Tx, name string, executionMs int64) error {
+	q := nameTable(`UPDATE ?? SET status = ?, execution_ms = ? WHERE name = ?;`, s.qualified())
+	_, err := tx.ExecContext(ctx, q, StatusDone, executionMs, name)
+	return err
 }
 
 // HasMigrationTable returns true if the migration table exists.
 func (s *SQLite) HasMigrationTable() (bool, error) {
+	return s.HasMigrationTableContext(context.Background())
+}
+
+// HasMigrationTableContext is like HasMigrationTable but uses ctx.
+func (s *SQLite) HasMigrationTableContext(ctx context.Context) (bool, error) {
+	master := "sqlite_master"
+	if s.tableSchema != "" {
+		master = s.tableSchema + ".sqlite_master"
+	}
 	q := fmt.Sprintf(`SELECT count(name)
-		FROM sqlite_master 
+		FROM %s 
 		WHERE type='table' 
-		AND name = '%s';`, s.table)
+		AND name = '%s';`, master, s.table)
 
-	return HasMigrationTable(s.db, q)
+	return HasMigrationTableContext(ctx, s.db, q)
 }
 
 // QueryPrevious queries and sets the records of all previous migrations.
 func (s *SQLite) QueryPrevious() (map[string]string, error) {
-	q := nameTable(`SELECT name, hash FROM ??;`, s.table)
-	return QueryPrevious(s.db, q)
+	return s.QueryPreviousContext(context.Background())
+}
+
+// QueryPreviousContext is like QueryPrevious but uses ctx.
+func (s *SQLite) QueryPreviousContext(ctx context.Context) (map[string]string, error) {
+	q := nameTable(`SELECT name, hash FROM ??;`, s.qualified())
+	return QueryPreviousContext(ctx, s.db, q)
 }
 
 // CreateMigrationTable makes the migrations table, and return the query used to
 // do it.
 func (s *SQLite) CreateMigrationTable() (string, error) {
+	return s.CreateMigrationTableContext(context.Background())
+}
+
+// CreateMigrationTableContext is like CreateMigrationTable but uses ctx.
+func (s *SQLite) CreateMigrationTableContext(ctx context.Context) (string, error) {
 	q := nameTable(`CREATE TABLE ?? (
 		-- list the schema changes
-		id      INTEGER                             PRIMARY KEY,
-		name    TEXT                                NOT NULL UNIQUE,
-		hash    TEXT                                NOT NULL,
-		date    TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
-        comment TEXT                                NOT NULL
-	);`, s.table)
+		id      {id},
+		name    {name},
+		hash    {hash},
+		date    {date},
+        comment {comment},
+        execution_ms {execution_ms},
+        applied_by {applied_by}`+extraColumns(s.extra, "")+`
+	);`, s.qualified(), sqliteColumns, s.columns)
 
-	return CreateMigrationTable(s.db, q)
+	return CreateMigrationTableContext(ctx, s.db, q)
 }
 
 func (s *SQLite) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
-	q := nameTable(`UPDATE ?? SET hash = ? WHERE name = ?`, s.table)
+	q := nameTable(`UPDATE ?? SET hash = ? WHERE name = ?`, s.qualified())
 	return RepairHashes(tx, q, hashes)
 }
+
+// OverrideColumns sets custom column definitions to use in place of the
+// defaults when the migration table is created.
+func (s *SQLite) OverrideColumns(columns map[string]string) {
+	s.columns = columns
+}
+
+// ExtendColumns sets extra columns to add to the migration table when it is
+// created.
+func (s *SQLite) ExtendColumns(columns []RecordColumn) {
+	s.extra = columns
+}
+
+// AddColumns adds the columns the existing migration table doesn't have,
+// and returns the queries used to do it.
+func (s *SQLite) AddColumns(ctx context.Context, columns []RecordColumn) (string, error) {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, s.qualified())
+	return AddColumns(ctx, s.db, q, columns, s.addColumn)
+}
+
+// addColumn returns the query that adds c to the migration table.
+func (s *SQLite) addColumn(c RecordColumn) string {
+	return nameTable(fmt.Sprintf(`ALTER TABLE ?? ADD COLUMN %s %s;`, c.Name, c.Definition()), s.qualified())
+}
+
+// MigrateMigrationTable upgrades an existing migration table created by an
+// older version of sqlxm, adding the columns it doesn't have.
+func (s *SQLite) MigrateMigrationTable() error {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, s.qualified())
+	v := nameTable(`SELECT COALESCE(MAX(schema_version), 0) FROM ??;`, s.qualified())
+	u := nameTable(`UPDATE ?? SET schema_version = ?;`, s.qualified())
+	return MigrateMigrationTable(context.Background(), s.db, q, v, u, sqliteUpgrades, s.addColumn)
+}
+
+// ListTables returns the names of all the tables in the database schema.
+func (s *SQLite) ListTables() ([]string, error) {
+	q := `SELECT name FROM sqlite_master
+		WHERE type = 'table'
+		AND name NOT LIKE 'sqlite_%'
+		ORDER BY name;`
+	return ListTables(s.db, q)
+}
+
+// QueryRecords returns all the migration records ordered by id.
+func (s *SQLite) QueryRecords(q sqlx.Queryer) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT id, name, hash, date, comment, applied_by FROM ?? ORDER BY id;`, s.qualified())
+	return QueryRecords(q, query)
+}
+
+// CountRecords returns the number of migration records.
+func (s *SQLite) CountRecords(ctx context.Context) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ??;`, s.qualified())
+	return CountRecords(ctx, s.db, q)
+}
+
+// QueryPreviousPrefix is like QueryPreviousContext, but only returns the
+// records whose names start with prefix.
+func (s *SQLite) QueryPreviousPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	q := nameTable(`SELECT name, hash FROM ?? WHERE name LIKE ? ESCAPE '!';`, s.qualified())
+	return QueryPreviousContext(ctx, s.db, q, LikePrefix(prefix))
+}
+
+// QueryRecordsPrefix is like QueryRecords, but only returns the records whose
+// names start with prefix.
+func (s *SQLite) QueryRecordsPrefix(q sqlx.Queryer, prefix string) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT id, name, hash, date, comment, applied_by FROM ?? WHERE name LIKE ? ESCAPE '!' ORDER BY id;`, s.qualified())
+	return QueryRecords(q, query, LikePrefix(prefix))
+}
+
+// CountRecordsPrefix is like CountRecords, but only counts the records whose
+// names start with prefix.
+func (s *SQLite) CountRecordsPrefix(ctx context.Context, prefix string) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ?? WHERE name LIKE ? ESCAPE '!';`, s.qualified())
+	return CountRecords(ctx, s.db, q, LikePrefix(prefix))
+}
+
+// QueryChecksum returns the stored migration table checksum, creating the
+// checksum table if it does not exist.
+func (s *SQLite) QueryChecksum() (string, error) {
+	create := nameTable(`CREATE TABLE IF NOT EXISTS ??_checksum (
+		checksum   VARCHAR(64) NOT NULL,
+		updated_at TIMESTAMP   NOT NULL
+	);`, s.qualified())
+	q := nameTable(`SELECT checksum FROM ??_checksum;`, s.qualified())
+	return QueryChecksum(s.db, create, q)
+}
+
+// StoreChecksum replaces the stored migration table checksum.
+func (s *SQLite) StoreChecksum(tx *sqlx.Tx, checksum string) error {
+	d := nameTable(`DELETE FROM ??_checksum;`, s.qualified())
+	i := nameTable(`INSERT INTO ??_checksum (checksum, updated_at) VALUES (?, CURRENT_TIMESTAMP);`, s.qualified())
+	return StoreChecksum(tx, d, i, checksum)
+}
+
+// DeleteRecord deletes a migration record from the DB.
+func (s *SQLite) DeleteRecord(tx *sqlx.Tx, name string) error {
+	q := nameTable(`DELETE FROM ?? WHERE name = ?;`, s.qualified())
+	return DeleteRecord(tx, q, name)
+}
+
+// UpdateComment replaces the comment of a migration record.
+func (s *SQLite) UpdateComment(tx *sqlx.Tx, name string, comment string) error {
+	q := nameTable(`UPDATE ?? SET comment = ? WHERE name = ?;`, s.qualified())
+	return UpdateComment(tx, q, name, comment)
+}
+
+// CleanTable deletes every migration record, keeping the table.
+func (s *SQLite) CleanTable() error {
+	q := nameTable(`DELETE FROM ??;`, s.qualified())
+	return CleanTable(s.db, q)
+}
+
+// ArchiveRecords moves the migration records dated before before to the
+// "<table>_history" table. SQLite stores CURRENT_TIMESTAMP as UTC text, so
+// before is compared as text in the same format.
+func (s *SQLite) ArchiveRecords(tx *sqlx.Tx, before time.Time) error {
+	c := nameTable(`CREATE TABLE IF NOT EXISTS ??_history AS SELECT * FROM ?? WHERE 0;`, s.qualified())
+	i := nameTable(`INSERT INTO ??_history SELECT * FROM ?? WHERE date < ?;`, s.qualified())
+	d := nameTable(`DELETE FROM ?? WHERE date < ?;`, s.qualified())
+	return ArchiveRecords(tx, c, i, d, before.UTC().Format("2006-01-02 15:04:05"))
+}
+
+// The column of the lock table that stores the id of the lock.
+var sqliteLockIDColumn = RecordColumn{Name: "migrator_id", Type: "TEXT", Default: "''"}
+
+// SQLiteLockTTL is how long the SQLite lock row is kept before it is taken over
+// by another Lock, since it is left behind by a process that exited without
+// calling Unlock.
+const SQLiteLockTTL = time.Hour
+
+// Lock acquires a lock by inserting the single allowed row into the
+// "<table>_lock" table. SQLite has no advisory locks, but only one connection
+// can insert the row, so the insert works the same way, even when several
+// processes use the same database file. The row stores an id for this lock, so
+// Unlock only deletes a row it inserted.
+//
+// The lock table is named after the migration table, rather than one shared
+// "sqlxm_lock" table, so migrators with different tables don't wait for each
+// other. A conflicting row is handled by the insert instead of failing it, so
+// a row acquired more than SQLiteLockTTL ago is taken over by the same
+// statement, e.g. after a process exited without calling Unlock.
+func (s *SQLite) Lock(ctx context.Context, timeout time.Duration) error {
+	create := nameTable(`CREATE TABLE IF NOT EXISTS ??_lock (
+		id          INTEGER   PRIMARY KEY CHECK (id = 1),
+		migrator_id TEXT      DEFAULT '' NOT NULL,
+		acquired_at TIMESTAMP NOT NULL
+	);`, s.qualified())
+	_, err := s.db.ExecContext(ctx, create)
+	if err != nil {
+		return err
+	}
+	// Lock tables created before the migrator_id column was added.
+	sel := nameTable(`SELECT * FROM ??_lock WHERE 0;`, s.qualified())
+	_, err = AddColumns(ctx, s.db, sel, []RecordColumn{sqliteLockIDColumn}, func(c RecordColumn) string {
+		return nameTable(fmt.Sprintf(`ALTER TABLE ??_lock ADD COLUMN %s %s;`, c.Name, c.Definition()), s.qualified())
+	})
+	if err != nil {
+		return err
+	}
+
+	id := newLockID()
+	q := nameTable(`INSERT INTO ??_lock (id, migrator_id, acquired_at) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET migrator_id = excluded.migrator_id, acquired_at = excluded.acquired_at
+		WHERE acquired_at < ?;`, s.qualified())
+	err = TryLock(ctx, timeout, func() (bool, error) {
+		now := time.Now().UTC()
+		stale := now.Add(-SQLiteLockTTL)
+		res, err := s.db.ExecContext(ctx, q, id, now.Format("2006-01-02 15:04:05"), stale.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n == 1, err
+	})
+	if err != nil {
+		return err
+	}
+	s.lockID = id
+	return nil
+}
+
+// Unlock releases the lock acquired by Lock.
+func (s *SQLite) Unlock(ctx context.Context) error {
+	if s.lockID == "" {
+		return nil
+	}
+	q := nameTable(`DELETE FROM ??_lock WHERE migrator_id = ?;`, s.qualified())
+	_, err := s.db.ExecContext(ctx, q, s.lockID)
+	s.lockID = ""
+	return err
+}
+
+// Explain returns the plan of statement from EXPLAIN QUERY PLAN, or ErrNotApplicable if it is
+// not a query or DML statement, e.g. DDL.
+func (s *SQLite) Explain(ctx context.Context, statement string, args ...interface{}) (string, error) {
+	return Explain(ctx, s.db, "EXPLAIN QUERY PLAN", statement, sqliteExplainKeywords, args...)
+}
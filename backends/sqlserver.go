@@ -0,0 +1,328 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type SQLServer struct {
+	// The database connection to use for this backend.
+	db *sqlx.DB
+	// The migration table name
+	table string
+	// The SQL Server schema the migration table belongs to, 'dbo' by default.
+	tableSchema string
+	// Custom migration table column definitions.
+	columns map[string]string
+	// Extra migration table columns.
+	extra []RecordColumn
+	// The connection holding the application lock.
+	lockConn *sqlx.Conn
+}
+
+// The default SQL Server migration table column definitions.
+var sqlserverColumns = map[string]string{
+	"id":           "INT IDENTITY(1,1)                NOT NULL PRIMARY KEY",
+	"name":         "NVARCHAR(64)                     NOT NULL",
+	"hash":         "VARCHAR(64)                      NOT NULL",
+	"date":         "DATETIME2    DEFAULT SYSDATETIME() NOT NULL",
+	"comment":      "NVARCHAR(512)                    NOT NULL",
+	"execution_ms": "INTEGER      DEFAULT 0             NOT NULL",
+	"applied_by":   "NVARCHAR(128)DEFAULT ''            NOT NULL",
+}
+
+// The upgrades of SQLServer migration tables created by older versions of sqlxm.
+var sqlserverUpgrades = []SchemaUpgrade{
+	{Version: 1, Column: RecordColumn{Name: "execution_ms", Type: "INTEGER", Default: "0"}},
+	{Version: 2, Column: RecordColumn{Name: "applied_by", Type: "NVARCHAR(128)", Default: "''"}},
+}
+
+// Setup does the initial configuration of the backend.
+func (s *SQLServer) Setup(db *sqlx.DB, table string, tableSchema string) {
+	if tableSchema == "" {
+		tableSchema = "dbo"
+	}
+	s.db = db
+	s.table = table
+	s.tableSchema = tableSchema
+}
+
+// Ping checks that the database can be reached.
+func (s *SQLServer) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// qualified returns the schema qualified migration table name.
+func (s *SQLServer) qualified() string {
+	return fmt.Sprintf("%s.%s", s.tableSchema, s.table)
+}
+
+// InsertRecord migration record into the DB.
+func (s *SQLServer) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return s.InsertRecordContext(context.Background(), tx, name, hash, comment, executionMs)
+}
+
+// InsertRecordContext is like InsertRecord but uses ctx.
+func (s *SQLServer) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return s.InsertRecordWithApplier(ctx, tx, name, hash, comment, executionMs, "")
+}
+
+// InsertRecordWithApplier is like InsertRecordContext, and also records who
+// applied the migration and the values of any extra columns.
+func (s *SQLServer) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...ExtraField) error {
+	names, values, args := extraFields(extra, "", 5, atPlaceholder)
+	q := nameTable(`INSERT INTO ?? (name, hash, comment, execution_ms, applied_by`+names+`) VALUES (@p1, @p2, @p3, @p4, @p5`+values+`);`, s.qualified())
+
+	args = append([]interface{}{name, hash, comment, executionMs, appliedBy}, args...)
+	return InsertRecordContext(ctx, tx, q, args...)
+}
+
+// HasMigrationTable returns true if the migration table exists.
+func (s *SQLServer) HasMigrationTable() (bool, error) {
+	return s.HasMigrationTableContext(context.Background())
+}
+
+// HasMigrationTableContext is like HasMigrationTable but uses ctx.
+func (s *SQLServer) HasMigrationTableContext(ctx context.Context) (bool, error) {
+	q := fmt.Sprintf(`SELECT CAST(CASE WHEN EXISTS(
+		SELECT * FROM INFORMATION_SCHEMA.TABLES
+		WHERE TABLE_CATALOG = DB_NAME()
+		AND TABLE_SCHEMA = '%s'
+		AND TABLE_NAME = '%s'
+	) THEN 1 ELSE 0 END AS BIT);`, s.tableSchema, s.table)
+	return HasMigrationTableContext(ctx, s.db, q)
+}
+
+// QueryPrevious queries and sets the records of all previous migrations.
+func (s *SQLServer) QueryPrevious() (map[string]string, error) {
+	return s.QueryPreviousContext(context.Background())
+}
+
+// QueryPreviousContext is like QueryPrevious but uses ctx.
+func (s *SQLServer) QueryPreviousContext(ctx context.Context) (map[string]string, error) {
+	q := nameTable(`SELECT name, hash FROM ??;`, s.qualified())
+	return QueryPreviousContext(ctx, s.db, q)
+}
+
+// CreateMigrationTable makes the migrations table, and return the query used to
+// do it.
+func (s *SQLServer) CreateMigrationTable() (string, error) {
+	return s.CreateMigrationTableContext(context.Background())
+}
+
+// CreateMigrationTableContext is like CreateMigrationTable but uses ctx.
+func (s *SQLServer) CreateMigrationTableContext(ctx context.Context) (string, error) {
+	q := nameTable(`CREATE TABLE ?? (
+		id      {id},
+		name    {name},
+		hash    {hash},
+		date    {date},
+		comment {comment},
+		execution_ms {execution_ms},
+		applied_by {applied_by}`+extraColumns(s.extra, "")+`
+	);
+
+	CREATE UNIQUE INDEX `+s.table+`_name_uindex ON ?? (name) WHERE name IS NOT NULL;`, s.qualified(), sqlserverColumns, s.columns)
+	return CreateMigrationTableContext(ctx, s.db, q)
+}
+
+func (s *SQLServer) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
+	q := nameTable(`UPDATE ?? SET hash = @p1 WHERE name = @p2`, s.qualified())
+	return RepairHashes(tx, q, hashes)
+}
+
+// OverrideColumns sets custom column definitions to use in place of the
+// defaults when the migration table is created.
+func (s *SQLServer) OverrideColumns(columns map[string]string) {
+	s.columns = columns
+}
+
+// ExtendColumns sets extra columns to add to the migration table when it is
+// created.
+func (s *SQLServer) ExtendColumns(columns []RecordColumn) {
+	s.extra = columns
+}
+
+// AddColumns adds the columns the existing migration table doesn't have,
+// and returns the queries used to do it.
+func (s *SQLServer) AddColumns(ctx context.Context, columns []RecordColumn) (string, error) {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, s.qualified())
+	return AddColumns(ctx, s.db, q, columns, s.addColumn)
+}
+
+// addColumn returns the query that adds c to the migration table.
+func (s *SQLServer) addColumn(c RecordColumn) string {
+	return nameTable(fmt.Sprintf(`ALTER TABLE ?? ADD %s %s;`, c.Name, c.Definition()), s.qualified())
+}
+
+// MigrateMigrationTable upgrades an existing migration table created by an
+// older version of sqlxm, adding the columns it doesn't have.
+func (s *SQLServer) MigrateMigrationTable() error {
+	q := nameTable(`SELECT * FROM ?? WHERE 1 = 0`, s.qualified())
+	v := nameTable(`SELECT COALESCE(MAX(schema_version), 0) FROM ??;`, s.qualified())
+	u := nameTable(`UPDATE ?? SET schema_version = @p1;`, s.qualified())
+	return MigrateMigrationTable(context.Background(), s.db, q, v, u, sqlserverUpgrades, s.addColumn)
+}
+
+// ListTables returns the names of all the tables in the database schema.
+func (s *SQLServer) ListTables() ([]string, error) {
+	q := `SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES
+		WHERE TABLE_CATALOG = DB_NAME()
+		AND TABLE_SCHEMA = @p1
+		AND TABLE_TYPE = 'BASE TABLE'
+		ORDER BY TABLE_NAME;`
+	return ListTables(s.db, q, s.tableSchema)
+}
+
+// QueryRecords returns all the migration records ordered by id.
+func (s *SQLServer) QueryRecords(q sqlx.Queryer) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT id, name, hash, date, comment, applied_by FROM ?? ORDER BY id;`, s.qualified())
+	return QueryRecords(q, query)
+}
+
+// CountRecords returns the number of migration records.
+func (s *SQLServer) CountRecords(ctx context.Context) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ??;`, s.qualified())
+	return CountRecords(ctx, s.db, q)
+}
+
+// QueryPreviousPrefix is like QueryPreviousContext, but only returns the
+// records whose names start with prefix.
+func (s *SQLServer) QueryPreviousPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	q := nameTable(`SELECT name, hash FROM ?? WHERE name LIKE @p1 ESCAPE '!';`, s.qualified())
+	return QueryPreviousContext(ctx, s.db, q, LikePrefix(prefix))
+}
+
+// QueryRecordsPrefix is like QueryRecords, but only returns the records whose
+// names start with prefix.
+func (s *SQLServer) QueryRecordsPrefix(q sqlx.Queryer, prefix string) ([]MigrationRecord, error) {
+	query := nameTable(`SELECT id, name, hash, date, comment, applied_by FROM ?? WHERE name LIKE @p1 ESCAPE '!' ORDER BY id;`, s.qualified())
+	return QueryRecords(q, query, LikePrefix(prefix))
+}
+
+// CountRecordsPrefix is like CountRecords, but only counts the records whose
+// names start with prefix.
+func (s *SQLServer) CountRecordsPrefix(ctx context.Context, prefix string) (int, error) {
+	q := nameTable(`SELECT COUNT(*) FROM ?? WHERE name LIKE @p1 ESCAPE '!';`, s.qualified())
+	return CountRecords(ctx, s.db, q, LikePrefix(prefix))
+}
+
+// QueryChecksum returns the stored migration table checksum, creating the
+// checksum table if it does not exist.
+func (s *SQLServer) QueryChecksum() (string, error) {
+	create := nameTable(`IF OBJECT_ID(N'??_checksum', N'U') IS NULL
+	CREATE TABLE ??_checksum (
+		checksum   VARCHAR(64) NOT NULL,
+		updated_at DATETIME2   NOT NULL
+	);`, s.qualified())
+	q := nameTable(`SELECT checksum FROM ??_checksum;`, s.qualified())
+	return QueryChecksum(s.db, create, q)
+}
+
+// StoreChecksum replaces the stored migration table checksum.
+func (s *SQLServer) StoreChecksum(tx *sqlx.Tx, checksum string) error {
+	d := nameTable(`DELETE FROM ??_checksum;`, s.qualified())
+	i := nameTable(`INSERT INTO ??_checksum (checksum, updated_at) VALUES (@p1, SYSDATETIME());`, s.qualified())
+	return StoreChecksum(tx, d, i, checksum)
+}
+
+// DeleteRecord deletes a migration record from the DB.
+func (s *SQLServer) DeleteRecord(tx *sqlx.Tx, name string) error {
+	q := nameTable(`DELETE FROM ?? WHERE name = @p1;`, s.qualified())
+	return DeleteRecord(tx, q, name)
+}
+
+// UpdateComment replaces the comment of a migration record.
+func (s *SQLServer) UpdateComment(tx *sqlx.Tx, name string, comment string) error {
+	q := nameTable(`UPDATE ?? SET comment = @p1 WHERE name = @p2;`, s.qualified())
+	return UpdateComment(tx, q, name, comment)
+}
+
+// CleanTable deletes every migration record, keeping the table.
+func (s *SQLServer) CleanTable() error {
+	q := nameTable(`DELETE FROM ??;`, s.qualified())
+	return CleanTable(s.db, q)
+}
+
+// ArchiveRecords moves the migration records dated before before to the
+// "<table>_history" table. The history table is created with SELECT INTO, and
+// the UNION stops the id column from being an identity column, so the ids can
+// be copied.
+func (s *SQLServer) ArchiveRecords(tx *sqlx.Tx, before time.Time) error {
+	c := nameTable(`IF OBJECT_ID('??_history', 'U') IS NULL
+		SELECT * INTO ??_history FROM ?? WHERE 1 = 0
+		UNION ALL
+		SELECT * FROM ?? WHERE 1 = 0;`, s.qualified())
+	i := nameTable(`INSERT INTO ??_history SELECT * FROM ?? WHERE date < @p1;`, s.qualified())
+	d := nameTable(`DELETE FROM ?? WHERE date < @p1;`, s.qualified())
+	return ArchiveRecords(tx, c, i, d, before)
+}
+
+// Lock acquires a session owned application lock keyed on the migration table
+// with sp_getapplock. The lock is held by a dedicated connection until Unlock is
+// called. The migrations are run on another connection, so a DB limited to one
+// open connection with SetMaxOpenConns(1) deadlocks.
+func (s *SQLServer) Lock(ctx context.Context, timeout time.Duration) error {
+	conn, err := s.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	// sp_getapplock returns 0 or 1 when the lock is granted, -1 on timeout, and
+	// less than -1 on error.
+	status := 0
+	err = conn.GetContext(ctx, &status, `DECLARE @status INT;
+		EXEC @status = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive',
+			@LockOwner = 'Session', @LockTimeout = @p2;
+		SELECT @status;`, s.qualified(), timeout.Milliseconds())
+	if err == nil && status < 0 {
+		err = ErrLockTimeout
+		if status < -1 {
+			err = fmt.Errorf("sp_getapplock('%s') failed with status %d", s.qualified(), status)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s.lockConn = conn
+	return nil
+}
+
+// Unlock releases the application lock acquired by Lock.
+func (s *SQLServer) Unlock(ctx context.Context) error {
+	if s.lockConn == nil {
+		return nil
+	}
+	defer func() {
+		s.lockConn.Close()
+		s.lockConn = nil
+	}()
+	_, err := s.lockConn.ExecContext(ctx, `EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session';`, s.qualified())
+	return err
+}
+
+// atPlaceholder is the placeholder used by SQL Server.
+func atPlaceholder(n int) string {
+	return fmt.Sprintf("@p%d", n)
+}
+
+// Savepoint implements Savepointer with SAVE TRANSACTION.
+func (s *SQLServer) Savepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, "SAVE TRANSACTION "+name)
+	return err
+}
+
+// RollbackToSavepoint implements Savepointer with ROLLBACK TRANSACTION.
+func (s *SQLServer) RollbackToSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, "ROLLBACK TRANSACTION "+name)
+	return err
+}
+
+// ReleaseSavepoint implements Savepointer. SQL Server can't release a
+// savepoint, so nothing is done.
+func (s *SQLServer) ReleaseSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	return nil
+}
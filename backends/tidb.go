@@ -0,0 +1,70 @@
+package backends
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TiDB is the backend for TiDB. TiDB speaks the MySQL protocol, so the
+// migration table is the same as MySQL's, but DDL is committed outside of
+// transactions and some statements are rejected inside one. Writes to the
+// migration table that are rejected are retried outside the transaction.
+type TiDB struct {
+	MySQL
+}
+
+// errNotInTransaction is part of the error TiDB returns for statements that
+// can't be run inside a transaction.
+const errNotInTransaction = "cannot be executed in a transaction"
+
+// isNotInTransaction returns true if err is the TiDB error for a statement that
+// can't be run inside a transaction.
+func isNotInTransaction(err error) bool {
+	return err != nil && strings.Contains(err.Error(), errNotInTransaction)
+}
+
+// InsertRecord migration record into the DB.
+func (t *TiDB) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return t.InsertRecordContext(context.Background(), tx, name, hash, comment, executionMs)
+}
+
+// InsertRecordContext is like InsertRecord but uses ctx.
+func (t *TiDB) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return t.InsertRecordWithApplier(ctx, tx, name, hash, comment, executionMs, "")
+}
+
+// InsertRecordWithApplier is like InsertRecordContext, and also records who
+// applied the migration and the values of any extra columns. If TiDB won't run
+// the insert in tx it is retried outside of it.
+func (t *TiDB) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...ExtraField) error {
+	names, values, args := extraFields(extra, "", 5, questionPlaceholder)
+	q := nameTable(`INSERT INTO ?? (name, hash, comment, execution_ms, applied_by`+names+`) VALUES (?, ?, ?, ?, ?`+values+`);`, t.qualified())
+
+	args = append([]interface{}{name, hash, comment, executionMs, appliedBy}, args...)
+	err := InsertRecordContext(ctx, tx, q, args...)
+	if isNotInTransaction(err) {
+		_, err = t.db.ExecContext(ctx, q, args...)
+	}
+	return err
+}
+
+// RepairHashes updates the stored hashes. If TiDB won't run the updates in tx
+// they are retried outside of it.
+func (t *TiDB) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
+	q := nameTable(`UPDATE ?? SET hash = ? WHERE name = ?`, t.qualified())
+	err := RepairHashes(tx, q, hashes)
+	if isNotInTransaction(err) {
+		for name, hash := range hashes {
+			if hash == "" {
+				continue
+			}
+			_, err = t.db.Exec(q, hash, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
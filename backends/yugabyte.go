@@ -0,0 +1,65 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+)
+
+// Yugabyte is the backend for YugabyteDB. YugabyteDB is compatible with
+// Postgres, but the migration table is created with a HASH sharded primary key
+// and an inline unique constraint on the name, instead of a separate unique
+// index, to keep the distributed DDL to a single statement.
+type Yugabyte struct {
+	Postgres
+}
+
+// The default YugabyteDB migration table column definitions.
+var yugabyteColumns = map[string]string{
+	"id":           "SERIAL",
+	"name":         "VARCHAR(64)                NOT NULL",
+	"hash":         "VARCHAR(64)                NOT NULL",
+	"date":         "TIMESTAMP    DEFAULT NOW() NOT NULL",
+	"comment":      "VARCHAR(512)               NOT NULL",
+	"execution_ms": "INTEGER      DEFAULT 0     NOT NULL",
+	"applied_by":   "VARCHAR(128) DEFAULT ''    NOT NULL",
+}
+
+// HasMigrationTable returns true if the migration table exists.
+func (y *Yugabyte) HasMigrationTable() (bool, error) {
+	return y.HasMigrationTableContext(context.Background())
+}
+
+// HasMigrationTableContext is like HasMigrationTable but uses ctx. It uses
+// pg_catalog since information_schema is slow on distributed clusters.
+func (y *Yugabyte) HasMigrationTableContext(ctx context.Context) (bool, error) {
+	q := fmt.Sprintf(`SELECT EXISTS(
+		SELECT * FROM pg_catalog.pg_tables
+		WHERE schemaname = '%s'
+		AND tablename = '%s'
+	);`, y.tableSchema, y.table)
+	return HasMigrationTableContext(ctx, y.db, q)
+}
+
+// CreateMigrationTable makes the migrations table, and return the query used to
+// do it.
+func (y *Yugabyte) CreateMigrationTable() (string, error) {
+	return y.CreateMigrationTableContext(context.Background())
+}
+
+// CreateMigrationTableContext is like CreateMigrationTable but uses ctx.
+func (y *Yugabyte) CreateMigrationTableContext(ctx context.Context) (string, error) {
+	q := nameTable(`CREATE TABLE ?? (
+		id      {id},
+		name    {name},
+		hash    {hash},
+		date    {date},
+        comment {comment},
+        execution_ms {execution_ms},
+        applied_by {applied_by}`+extraColumns(y.extra, "")+`,
+		CONSTRAINT `+y.table+`_pk PRIMARY KEY (id HASH),
+		CONSTRAINT `+y.table+`_name_ukey UNIQUE (name)
+	);
+
+	COMMENT ON TABLE ?? IS 'list the schema changes';`, y.qualified(), yugabyteColumns, y.columns)
+	return CreateMigrationTableContext(ctx, y.db, q)
+}
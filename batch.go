@@ -0,0 +1,56 @@
+package sqlxm
+
+import "fmt"
+
+// A MigrationDef defines a migration to add with BatchAddMigration or load from
+// a MigrationSource. The fields are the arguments of AddMigration, and the
+// optional rollback statement, tags and environment of the migration.
+type MigrationDef struct {
+	Name        string        `json:"name"`
+	Comment     string        `json:"comment"`
+	Statement   string        `json:"statement"`
+	Args        []interface{} `json:"args"`
+	Rollback    string        `json:"rollback"`
+	Tags        []string      `json:"tags"`
+	Environment string        `json:"environment"`
+}
+
+// BatchAddMigration adds each of migrations like AddMigration, in order, but
+// either all of them are added or none are. This is useful for migrations that
+// are generated, where a duplicate name shouldn't leave half of them added.
+//
+// An ErrDuplicateMigration error is returned if a name is used more than once
+// in migrations, or a migration with the name has already been added.
+func (m *Migrator) BatchAddMigration(migrations []MigrationDef) error {
+	statements := make([]string, len(migrations))
+	rollbacks := make([]string, len(migrations))
+	names := make(map[string]struct{}, len(migrations))
+	for i, def := range migrations {
+		if _, ok := m.names[def.Name]; ok {
+			return fmt.Errorf("migration '%s' already exists: %w", def.Name, ErrDuplicateMigration)
+		}
+		if _, ok := names[def.Name]; ok {
+			return fmt.Errorf("migration '%s' is in the batch more than once: %w", def.Name, ErrDuplicateMigration)
+		}
+		names[def.Name] = struct{}{}
+
+		statement, err := m.renderStatement(def.Name, def.Statement)
+		if err != nil {
+			return err
+		}
+		statements[i] = statement
+		rollbacks[i], err = m.renderStatement(def.Name, def.Rollback)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, def := range migrations {
+		m.addMigration(def.Name, def.Comment, statements[i], def.Args)
+		mig := &m.migrations[len(m.migrations)-1]
+		mig.RollbackStatement = rollbacks[i]
+		mig.Tags = def.Tags
+		mig.Environment = def.Environment
+	}
+	return nil
+}
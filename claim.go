@@ -0,0 +1,39 @@
+package sqlxm
+
+import "github.com/danielmorell/sqlxm/backends"
+
+// WithIdempotentRun claims each migration before it is run by inserting its
+// record with the status "running", and sets the status to "done" once it has
+// run. If another run has already claimed the migration it is skipped and
+// logged as SKIPPED, so concurrent runs don't fail on a duplicate record even
+// when the backend has no advisory lock.
+//
+// The claims need a status column in the migration table, which is added with
+// ExtendMigrationRecord, so call AlterMigrationTable to add it to an existing
+// table. The SQLite, MySQL and Postgres backends support claims, migrations are
+// run as usual with other backends.
+func WithIdempotentRun() Option {
+	return func(m *Migrator) {
+		m.claims = true
+		for _, c := range m.recordColumns {
+			if c.Name == backends.StatusColumn.Name {
+				return
+			}
+		}
+		ExtendMigrationRecord(backends.StatusColumn)(m)
+	}
+}
+
+// claimer returns the backend as a backends.Claimer if WithIdempotentRun is
+// set and the backend supports claims. The namespace and middleware wrappers
+// always implement backends.Claimer, so the check is done on the base backend.
+func (m *Migrator) claimer() (backends.Claimer, bool) {
+	if !m.claims {
+		return nil, false
+	}
+	if _, ok := m.baseBackend.(backends.Claimer); !ok {
+		return nil, false
+	}
+	c, ok := m.backend.(backends.Claimer)
+	return c, ok
+}
@@ -0,0 +1,202 @@
+// Command sqlxm runs sqlxm migrations from a directory of .sql files.
+//
+//    sqlxm --driver postgres --dsn "$DSN" --dir migrations up
+//    sqlxm --driver postgres --dsn "$DSN" --dir migrations down 1
+//    sqlxm --driver postgres --dsn "$DSN" --dir migrations status
+//    sqlxm --dir migrations create add_users_table
+//    sqlxm --driver postgres --dsn "$DSN" --dir migrations repair add_users_table
+//
+// The DSN can also be set with the SQLXM_DSN environment variable.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danielmorell/sqlxm"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+// config is set by the global flags.
+type config struct {
+	driver string
+	dsn    string
+	dir    string
+	table  string
+	schema string
+}
+
+func main() {
+	err := rootCmd().Execute()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func rootCmd() *cobra.Command {
+	c := &config{}
+	root := &cobra.Command{
+		Use:          "sqlxm",
+		Short:        "Run sqlxm migrations from a directory of .sql files",
+		SilenceUsage: true,
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&c.driver, "driver", "postgres", "the database/sql driver name, e.g. postgres, mysql or sqlite")
+	flags.StringVar(&c.dsn, "dsn", os.Getenv("SQLXM_DSN"), "the data source name, defaults to $SQLXM_DSN")
+	flags.StringVar(&c.dir, "dir", "migrations", "the directory of .sql migration files")
+	flags.StringVar(&c.table, "table", sqlxm.DefaultTableName, "the migration table name")
+	flags.StringVar(&c.schema, "schema", "", "the migration table schema")
+
+	root.AddCommand(
+		upCmd(c),
+		downCmd(c),
+		statusCmd(c),
+		createCmd(c),
+		repairCmd(c),
+	)
+	return root
+}
+
+// migrator connects to the DB and loads the migrations from the directory.
+func (c *config) migrator() (*sqlxm.Migrator, error) {
+	if c.dsn == "" {
+		return nil, fmt.Errorf("a DSN must be set with --dsn or SQLXM_DSN")
+	}
+	db, err := sqlx.Open(c.driver, c.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open DB failed: %w", err)
+	}
+	m, err := sqlxm.New(db, c.table, c.schema)
+	if err != nil {
+		return nil, err
+	}
+	err = m.AddMigrationsFromFS(os.DirFS(c.dir), ".")
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// printLog writes each log entry on its own line.
+func printLog(cmd *cobra.Command, l []sqlxm.MigrationLog) {
+	for _, entry := range l {
+		cmd.Printf("%-10s %s: %s\n", entry.Status, entry.Name, entry.Details)
+	}
+}
+
+func upCmd(c *config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "up",
+		Short: "Run all the pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := c.migrator()
+			if err != nil {
+				return err
+			}
+			l, err := m.RunStrict()
+			printLog(cmd, l)
+			return err
+		},
+	}
+}
+
+func downCmd(c *config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "down N",
+		Short: "Roll back the last N migrations",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("N must be a number: %w", err)
+			}
+			m, err := c.migrator()
+			if err != nil {
+				return err
+			}
+			l, err := m.RollbackLast(n)
+			printLog(cmd, l)
+			return err
+		},
+	}
+}
+
+func statusCmd(c *config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of every migration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := c.migrator()
+			if err != nil {
+				return err
+			}
+			status, err := m.Status()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tSTATUS\tAPPLIED AT")
+			for _, s := range status {
+				appliedAt := "-"
+				if s.AppliedAt != nil {
+					appliedAt = s.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, s, appliedAt)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func createCmd(c *config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create a new timestamped migration file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			file := filepath.Join(c.dir, fmt.Sprintf("%s_%s.sql", time.Now().UTC().Format("20060102150405"), name))
+			err := os.MkdirAll(c.dir, 0755)
+			if err != nil {
+				return err
+			}
+			body := fmt.Sprintf("-- %s\n-- +migrate Up\n\n\n-- +migrate Down\n\n", name)
+			err = os.WriteFile(file, []byte(body), 0644)
+			if err != nil {
+				return err
+			}
+			cmd.Println(file)
+			return nil
+		},
+	}
+}
+
+func repairCmd(c *config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "repair NAME",
+		Short: "Update the stored hash of an applied migration",
+		Long: "Update the stored hash of an applied migration. Only the migrations up to NAME are run, so " +
+			"no pending migrations after it are applied.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := c.migrator()
+			if err != nil {
+				return err
+			}
+			m.RepairHash(args[0])
+			l, err := m.MigrateTo(args[0])
+			printLog(cmd, l)
+			return err
+		},
+	}
+}
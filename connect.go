@@ -0,0 +1,65 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The longest time to wait between connection attempts.
+const maxConnectionBackoff = 30 * time.Second
+
+// WithConnectionRetry makes Run retry the initial connection to the DB up to
+// maxAttempts times, for example when the DB container is not ready when the app
+// starts. The wait between attempts starts at initialBackoff and doubles after
+// each attempt, up to 30 seconds. Each failed attempt is logged at the warn level
+// if a Logger is set.
+func WithConnectionRetry(maxAttempts int, initialBackoff time.Duration) Option {
+	return func(m *Migrator) {
+		m.connectAttempts = maxAttempts
+		m.connectBackoff = initialBackoff
+	}
+}
+
+// Ping checks that the database can be reached with the Ping of the backend,
+// without retrying. Runs ping the database before anything else is done, so
+// this is only needed to check the connection ahead of time, e.g. in a health
+// check.
+func (m *Migrator) Ping(ctx context.Context) error {
+	err := m.backend.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("ping database failed: %w", err)
+	}
+	return nil
+}
+
+// connect pings the DB until it succeeds or the connection attempts run out. The
+// DB is only pinged once if WithConnectionRetry is not used.
+func (m *Migrator) connect(ctx context.Context) error {
+	if m.connectAttempts <= 0 {
+		return m.Ping(ctx)
+	}
+	backoff := m.connectBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = m.backend.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		if attempt >= m.connectAttempts {
+			break
+		}
+		m.logger.Warn("database connection failed, retrying",
+			"attempt", attempt, "max_attempts", m.connectAttempts, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("connect to database failed after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxConnectionBackoff {
+			backoff = maxConnectionBackoff
+		}
+	}
+	return fmt.Errorf("connect to database failed after %d attempts: %w", m.connectAttempts, err)
+}
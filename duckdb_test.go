@@ -0,0 +1,101 @@
+//go:build duckdb
+// +build duckdb
+
+package sqlxm
+
+// The DuckDB driver needs cgo, so these tests only run with the duckdb build
+// tag after adding the driver to the module.
+//
+//    go get github.com/marcboeker/go-duckdb
+//    go test -tags duckdb -run DuckDB .
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+func newDuckDBMigrator(t *testing.T) (*Migrator, *sqlx.DB) {
+	db, err := sqlx.Open("duckdb", filepath.Join(t.TempDir(), "test.duckdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	m, err := New(db, "migrations", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &m, db
+}
+
+func TestDuckDBE2E(t *testing.T) {
+	m, db := newDuckDBMigrator(t)
+	if BackendType(db.DriverName()) != "duckdb" {
+		t.Fatalf("expected the duckdb backend, got '%s'", BackendType(db.DriverName()))
+	}
+
+	err := m.AddMigration("create_user_table", "Add the user table", `CREATE TABLE users (id INT, name VARCHAR(64) NOT NULL);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("add_user", "", `INSERT INTO users (id, name) VALUES ($1, $2);`, 1, "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if len(log) != 3 || log[1].Status != SUCCESS || log[2].Status != SUCCESS {
+		t.Fatalf("migration log incorrect: %+v", log)
+	}
+
+	records, err := m.backend.QueryRecords(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID >= records[1].ID || records[1].Name != "add_user" {
+		t.Errorf("migration records incorrect: %+v", records)
+	}
+
+	// A second run finds the previous migrations.
+	log, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if log[len(log)-1].Status != PREVIOUS {
+		t.Errorf("migration should have been run before: %+v", log[len(log)-1])
+	}
+}
+
+func TestDuckDBArchiveOldRecords(t *testing.T) {
+	m, db := newDuckDBMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	err = m.ArchiveOldRecords(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations_history;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 archived record, got %d", count)
+	}
+}
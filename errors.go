@@ -0,0 +1,137 @@
+package sqlxm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/danielmorell/sqlxm/backends"
+)
+
+// ErrDuplicateMigration is returned when a migration is added with a name that
+// has already been added.
+var ErrDuplicateMigration = errors.New("duplicate migration")
+
+// ErrHashMismatch is returned when the hash of a migration that has already been
+// run does not match the hash stored in the migration table.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// ErrBackendNotFound is returned when a backend key has not been registered.
+var ErrBackendNotFound = errors.New("backend not found")
+
+// ErrMigrationTableSetup is returned when the migration table can't be checked
+// for or created.
+var ErrMigrationTableSetup = errors.New("migration table setup failed")
+
+// tableSetupError is an ErrMigrationTableSetup error that wraps the error from
+// the backend, so errors.Is and errors.As match either of them.
+type tableSetupError struct {
+	msg string
+	err error
+}
+
+// errTableSetup returns an ErrMigrationTableSetup error for the step described
+// by msg that failed with err.
+func errTableSetup(msg string, err error) error {
+	return tableSetupError{msg: msg, err: err}
+}
+
+func (e tableSetupError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrMigrationTableSetup, e.msg, e.err)
+}
+
+// Is reports whether target is ErrMigrationTableSetup.
+func (e tableSetupError) Is(target error) bool {
+	return target == ErrMigrationTableSetup
+}
+
+// Unwrap returns the error from the backend.
+func (e tableSetupError) Unwrap() error {
+	return e.err
+}
+
+// ErrSequenceGap is returned when sequential numbering is enabled and a
+// migration number is missing.
+var ErrSequenceGap = errors.New("migration sequence gap")
+
+// ErrSequenceDuplicate is returned when sequential numbering is enabled and two
+// migrations have the same number.
+var ErrSequenceDuplicate = errors.New("duplicate migration sequence number")
+
+// ErrSafeMode is returned by operations that are not allowed in safe mode.
+var ErrSafeMode = errors.New("not allowed in safe mode")
+
+// ErrMigrationsFailed is returned by a run with SavepointPerMigration when some
+// of the migrations failed and the others were committed.
+var ErrMigrationsFailed = errors.New("migrations failed")
+
+// ErrMigrationNotFound is returned by DeleteRecord and UpdateMigrationComment
+// when the migration table has no record for the migration.
+var ErrMigrationNotFound = errors.New("migration not found")
+
+// ErrTooManyPending is returned when a run would apply more migrations than
+// the limit set with WithMaxPendingMigrations.
+var ErrTooManyPending = errors.New("too many pending migrations")
+
+// ErrInvalidNamespace is returned by New when the prefix set with
+// WithNamespacePrefix has characters other than lowercase letters, digits, "_"
+// and "-".
+var ErrInvalidNamespace = errors.New("invalid namespace")
+
+// ErrNamespaced is returned by operations that cover the whole migration table,
+// like ArchiveOldRecords and the table checksum, when a namespace is set with
+// WithNamespacePrefix.
+var ErrNamespaced = errors.New("not supported with a namespace")
+
+// ErrMigrationTableNotFound is returned by Validate when the migration table
+// does not exist.
+var ErrMigrationTableNotFound = errors.New("migration table not found")
+
+// ErrMigrationTableTampered is returned when the migration table checksum does
+// not match the stored checksum.
+var ErrMigrationTableTampered = errors.New("migration table tampered")
+
+// ErrLockTimeout is returned when the migration lock is not acquired before the
+// lock timeout.
+var ErrLockTimeout = backends.ErrLockTimeout
+
+// ErrNotApplicable is returned by ExplainMigration when EXPLAIN can't analyze
+// the statement of the migration, e.g. DDL, or the backend doesn't support it.
+var ErrNotApplicable = backends.ErrNotApplicable
+
+// ErrQueryTimeout is returned when a migration statement runs longer than the
+// query timeout set with WithQueryTimeout.
+var ErrQueryTimeout = backends.ErrQueryTimeout
+
+// MigrationErrors is a list of errors returned together, e.g. every conflicting
+// migration found by RecursiveLoad. errors.Is and errors.As match any of the
+// errors.
+type MigrationErrors []error
+
+func (e MigrationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors matches target.
+func (e MigrationErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target.
+func (e MigrationErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package sqlxm
+
+import "fmt"
+
+// EventType is the kind of a MigrationEvent.
+type EventType int
+
+const (
+	// MigrationComplete is sent when a migration has run, whether it
+	// succeeded or failed.
+	MigrationComplete EventType = iota
+	// RunComplete is sent at the end of each run.
+	RunComplete
+)
+
+var eventTypeNames = []string{
+	MigrationComplete: "migration_complete",
+	RunComplete:       "run_complete",
+}
+
+// String returns the lowercase name of the event type, e.g. "run_complete".
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+	return eventTypeNames[t]
+}
+
+// A MigrationEvent is sent on the Events channel while migrations are run.
+type MigrationEvent struct {
+	Type EventType
+	// Log is the log entry of the migration. It is empty for RunComplete.
+	Log MigrationLog
+}
+
+// Events returns a channel that receives a MigrationComplete event as each
+// migration is run and a RunComplete event at the end of each run, so the
+// progress can be watched in another goroutine, e.g. to update a UI. The
+// channel is buffered to the number of added migrations plus one, and events
+// are discarded instead of blocking the run when it is full. No events are sent
+// until Events is called.
+//
+// Events should be called before the run, and CloseEvents after it returns.
+//
+//    events := m.Events()
+//    go func() {
+//        for e := range events {
+//            fmt.Println(e.Type, e.Log.Name)
+//        }
+//    }()
+//    _, err := m.Run()
+//    m.CloseEvents()
+func (m *Migrator) Events() <-chan MigrationEvent {
+	if m.events == nil {
+		m.events = make(chan MigrationEvent, len(m.migrations)+1)
+	}
+	return m.events
+}
+
+// CloseEvents closes the channel returned by Events to signal that the runs are
+// done. The next call to Events returns a new channel.
+func (m *Migrator) CloseEvents() {
+	if m.events != nil {
+		close(m.events)
+		m.events = nil
+	}
+}
+
+// sendEvent sends e on the Events channel without blocking.
+func (m *Migrator) sendEvent(e MigrationEvent) {
+	if m.events == nil {
+		return
+	}
+	select {
+	case m.events <- e:
+	default:
+	}
+}
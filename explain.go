@@ -0,0 +1,86 @@
+package sqlxm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/danielmorell/sqlxm/backends"
+)
+
+// ExplainMigration runs EXPLAIN on the statement of the named migration without
+// running it, which catches syntax errors and references to missing tables
+// before Run. The plan of each statement in the migration is returned, one row
+// per line.
+//
+// EXPLAIN only analyzes queries and DML like INSERT and UPDATE, so
+// ErrNotApplicable is returned if any statement of the migration is DDL, or if
+// the backend doesn't support EXPLAIN. Postgres, MySQL and SQLite, with EXPLAIN
+// QUERY PLAN, support it.
+func (m *Migrator) ExplainMigration(ctx context.Context, name string) (string, error) {
+	err := m.loadSources()
+	if err != nil {
+		return "", err
+	}
+	i, ok := m.findMigration(name)
+	if !ok {
+		return "", fmt.Errorf("explain '%s' failed: migration has not been added", name)
+	}
+	return m.explain(ctx, m.migrations[i])
+}
+
+// ExplainAll runs ExplainMigration on every added migration, and returns the
+// plans keyed by migration name. Migrations EXPLAIN can't analyze are left
+// out. The first other error stops the check, and is returned with the plans
+// of the migrations before it.
+func (m *Migrator) ExplainAll(ctx context.Context) (map[string]string, error) {
+	plans := make(map[string]string)
+	err := m.loadSources()
+	if err != nil {
+		return plans, err
+	}
+	for _, mig := range m.migrations {
+		plan, err := m.explain(ctx, mig)
+		if errors.Is(err, ErrNotApplicable) {
+			continue
+		}
+		if err != nil {
+			return plans, err
+		}
+		plans[mig.Name] = plan
+	}
+	return plans, nil
+}
+
+// explain returns the plans of the statements of mig.
+func (m *Migrator) explain(ctx context.Context, mig Migration) (string, error) {
+	e, ok := m.backend.(backends.Explainer)
+	if !ok {
+		return "", fmt.Errorf("explain '%s' failed: %w: the backend does not support EXPLAIN", mig.Name, ErrNotApplicable)
+	}
+	statement := mig.Statement
+	if m.preprocess != nil {
+		var err error
+		statement, err = m.preprocess(ctx, mig.Name, statement)
+		if err != nil {
+			return "", fmt.Errorf("explain '%s' failed: preprocess statement failed: %w", mig.Name, err)
+		}
+	}
+
+	// The args can only be bound when there is a single statement.
+	stmts := splitStatements(statement)
+	args := mig.args
+	if len(stmts) > 1 {
+		args = nil
+	}
+	plans := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		plan, err := e.Explain(ctx, strings.TrimSpace(stmt), args...)
+		if err != nil {
+			return "", fmt.Errorf("explain '%s' failed: %w", mig.Name, err)
+		}
+		plans = append(plans, plan)
+	}
+	return strings.Join(plans, "\n"), nil
+}
@@ -0,0 +1,67 @@
+package sqlxm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exportedMigration is a migration definition written by ExportToYAML and
+// ExportToJSON.
+type exportedMigration struct {
+	Name        string   `yaml:"name" json:"name"`
+	Comment     string   `yaml:"comment" json:"comment"`
+	Hash        string   `yaml:"hash" json:"hash"`
+	Statement   string   `yaml:"statement" json:"statement"`
+	Rollback    string   `yaml:"rollback,omitempty" json:"rollback,omitempty"`
+	Tags        []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Environment string   `yaml:"environment,omitempty" json:"environment,omitempty"`
+}
+
+// ExportToYAML writes all the added migrations to w as a YAML list, so they can
+// be committed to a repository or diffed. The hash is the one computed for the
+// migration, not the stored one, and the statements are written after the
+// templates are rendered.
+//
+// The list can be added to a Migrator again with AddMigrationsFromYAML, which
+// ignores the hash. The args of the migrations are not written.
+func (m *Migrator) ExportToYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	err := enc.Encode(m.exportedMigrations())
+	if err != nil {
+		return fmt.Errorf("write migration YAML failed: %w", err)
+	}
+	return enc.Close()
+}
+
+// ExportToJSON is like ExportToYAML, but writes the migrations as a JSON array
+// that can be loaded with JSONSource.
+func (m *Migrator) ExportToJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(m.exportedMigrations())
+	if err != nil {
+		return fmt.Errorf("write migration JSON failed: %w", err)
+	}
+	return nil
+}
+
+// exportedMigrations returns the definitions of the added migrations.
+func (m *Migrator) exportedMigrations() []exportedMigration {
+	exported := make([]exportedMigration, len(m.migrations))
+	for i, mig := range m.migrations {
+		exported[i] = exportedMigration{
+			Name:        mig.Name,
+			Comment:     mig.Comment,
+			Hash:        mig.hash,
+			Statement:   mig.Statement,
+			Rollback:    mig.RollbackStatement,
+			Tags:        mig.Tags,
+			Environment: mig.Environment,
+		}
+	}
+	return exported
+}
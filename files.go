@@ -0,0 +1,312 @@
+package sqlxm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNoMigrationsFound is returned when a pattern or directory used to load
+// migrations does not match any migration files.
+type ErrNoMigrationsFound struct {
+	Pattern string
+}
+
+func (e ErrNoMigrationsFound) Error() string {
+	return fmt.Sprintf("no migrations found matching '%s'", e.Pattern)
+}
+
+// AddMigrationFromFile reads a SQL file and adds it as a migration.
+//
+// The migration name is the file name without the extension. If the first line
+// of the file is a SQL comment (--) it is used as the migration comment. The
+// file can also have up and down sections, see AddMigrationsFromFS.
+func (m *Migrator) AddMigrationFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read migration file '%s' failed: %w", path, err)
+	}
+	base := filepath.Base(path)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+
+	return m.addMigrationFile(name, string(data))
+}
+
+// AddFromReader reads all of r and adds it as the statement of a migration like
+// AddMigration, e.g. for SQL from a network request, a zip file or a generate
+// step. If reading r fails the error is returned and the migration is not
+// added.
+func (m *Migrator) AddFromReader(name string, comment string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read migration '%s' failed: %w", name, err)
+	}
+	return m.AddMigration(name, comment, string(data))
+}
+
+// AddMigrationFromGlob adds a migration for every file matching pattern. The
+// files are added in lexicographic order, so a numeric prefix like
+// 001_create_users.sql can be used to control the order they are run in.
+//
+// The pattern is evaluated when AddMigrationFromGlob is called, not when the
+// migrations are run. If no files match an ErrNoMigrationsFound error is
+// returned. Like BatchAddMigration, either all the files are added or none are,
+// e.g. if one of them is a duplicate.
+func (m *Migrator) AddMigrationFromGlob(pattern string) error {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("glob '%s' failed: %w", pattern, err)
+	}
+	if len(paths) == 0 {
+		return ErrNoMigrationsFound{Pattern: pattern}
+	}
+	sort.Strings(paths)
+
+	defs := make([]MigrationDef, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("read migration file '%s' failed: %w", p, err)
+		}
+		base := filepath.Base(p)
+		defs = append(defs, fileMigrationDef(strings.TrimSuffix(base, filepath.Ext(base)), string(data)))
+	}
+	return m.BatchAddMigration(defs)
+}
+
+// AddMigrationsFromFS adds a migration for every .sql file in dir of fsys, so
+// migrations can be embedded with embed.FS or loaded with os.DirFS.
+//
+//    //go:embed migrations/*.sql
+//    var migrations embed.FS
+//
+//    err := m.AddMigrationsFromFS(migrations, "migrations")
+//
+// The files are added in lexicographic order, so they should be named with a
+// numeric prefix like 001_create_users.sql. The migration name is the file name
+// without the numeric prefix and extension, e.g. create_users. If the first
+// line of a file is a SQL comment (--) it is used as the migration comment.
+//
+// A file can have both the up and down migration separated by sql-migrate
+// style delimiters. The comment lines above the Up delimiter are used as the
+// migration comment, and the down section is used as the rollback statement.
+//
+//    -- Add the users table.
+//    -- +migrate Up
+//    CREATE TABLE users (id INT);
+//
+//    -- +migrate Down
+//    DROP TABLE users;
+//
+// An error is returned if a name has already been added, and an
+// ErrNoMigrationsFound error is returned if dir has no .sql files.
+func (m *Migrator) AddMigrationsFromFS(fsys fs.FS, dir string) error {
+	pattern := path.Join(dir, "*.sql")
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return fmt.Errorf("glob '%s' failed: %w", pattern, err)
+	}
+	if len(paths) == 0 {
+		return ErrNoMigrationsFound{Pattern: pattern}
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("read migration file '%s' failed: %w", p, err)
+		}
+		err = m.addMigrationFile(migrationName(path.Base(p)), string(data))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A LoadOption changes how RecursiveLoad finds migration files.
+type LoadOption func(*loadConfig)
+
+type loadConfig struct {
+	// How many levels of subdirectories to load, or -1 for all of them.
+	maxDepth int
+}
+
+// MaxDepth limits RecursiveLoad to n levels of subdirectories below the root
+// directory. With a MaxDepth of 0 only the files in the root are loaded.
+func MaxDepth(n int) LoadOption {
+	return func(c *loadConfig) {
+		c.maxDepth = n
+	}
+}
+
+// RecursiveLoad adds a migration for every .sql file under root in fsys,
+// including the files in subdirectories, so migrations can be organized by
+// module or feature.
+//
+//    migrations/
+//        billing/001_create_invoices.sql
+//        users/001_create_users.sql
+//
+// The files are added in the order of their path relative to root, so both the
+// directory and the file names control the order. The migration name is the
+// relative path without the extension, e.g. users/001_create_users. Files are
+// parsed like AddMigrationsFromFS.
+//
+// Every file is added even if some names have already been added, and the
+// conflicts are returned together as MigrationErrors. An ErrNoMigrationsFound
+// error is returned if there are no .sql files.
+func (m *Migrator) RecursiveLoad(fsys fs.FS, root string, opts ...LoadOption) error {
+	config := loadConfig{maxDepth: -1}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	paths := make([]string, 0)
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := relativePath(root, p)
+		if d.IsDir() {
+			if rel != "" && config.maxDepth >= 0 && strings.Count(rel, "/") >= config.maxDepth {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path.Ext(p) == ".sql" {
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walk '%s' failed: %w", root, err)
+	}
+	if len(paths) == 0 {
+		return ErrNoMigrationsFound{Pattern: path.Join(root, "**", "*.sql")}
+	}
+	sort.Strings(paths)
+
+	var conflicts MigrationErrors
+	for _, rel := range paths {
+		p := path.Join(root, rel)
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("read migration file '%s' failed: %w", p, err)
+		}
+		err = m.addMigrationFile(strings.TrimSuffix(rel, ".sql"), string(data))
+		if errors.Is(err, ErrDuplicateMigration) {
+			conflicts = append(conflicts, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(conflicts) > 0 {
+		return conflicts
+	}
+	return nil
+}
+
+// relativePath returns p relative to root, where p is a path under root from
+// fs.WalkDir. It is empty for root itself.
+func relativePath(root string, p string) string {
+	if p == root {
+		return ""
+	}
+	if root == "." {
+		return p
+	}
+	return strings.TrimPrefix(p, root+"/")
+}
+
+// addMigrationFile adds the migration from the contents of a migration file.
+func (m *Migrator) addMigrationFile(name string, data string) error {
+	def := fileMigrationDef(name, data)
+	return m.AddMigrationWithRollback(def.Name, def.Comment, def.Statement, def.Rollback)
+}
+
+// fileMigrationDef returns the definition of a migration from the contents of a
+// migration file.
+func fileMigrationDef(name string, data string) MigrationDef {
+	comment, up, down, ok := parseSections(data)
+	if !ok {
+		return MigrationDef{Name: name, Comment: fileComment(data), Statement: data}
+	}
+	return MigrationDef{Name: name, Comment: comment, Statement: up, Rollback: down}
+}
+
+// parseSections splits a migration file into the comment, up and down sections
+// using the "-- +migrate Up" and "-- +migrate Down" delimiters. ok is false if
+// the file has no Up delimiter.
+func parseSections(data string) (comment string, up string, down string, ok bool) {
+	var comments []string
+	var upLines, downLines []string
+	section := ""
+	for _, line := range strings.Split(data, "\n") {
+		if s, isDelimiter := migrateDelimiter(line); isDelimiter {
+			section = s
+			ok = ok || s == "Up"
+			continue
+		}
+		switch section {
+		case "":
+			c := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "--"))
+			if c != "" {
+				comments = append(comments, c)
+			}
+		case "Up":
+			upLines = append(upLines, line)
+		case "Down":
+			downLines = append(downLines, line)
+		}
+	}
+	up = strings.TrimSpace(strings.Join(upLines, "\n"))
+	down = strings.TrimSpace(strings.Join(downLines, "\n"))
+	return strings.Join(comments, " "), up, down, ok
+}
+
+// migrateDelimiter returns the section name if line is a "-- +migrate Up" or
+// "-- +migrate Down" delimiter. Options after the section name are ignored.
+func migrateDelimiter(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "--") {
+		return "", false
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, "--"))
+	if len(fields) < 2 || fields[0] != "+migrate" {
+		return "", false
+	}
+	if fields[1] != "Up" && fields[1] != "Down" {
+		return "", false
+	}
+	return fields[1], true
+}
+
+// migrationName returns the migration name for a file name by removing the
+// extension and numeric prefix, e.g. 001_create_users.sql is create_users.
+func migrationName(file string) string {
+	name := strings.TrimSuffix(file, path.Ext(file))
+	trimmed := strings.TrimLeft(name, "0123456789")
+	if trimmed == name || !strings.HasPrefix(trimmed, "_") || len(trimmed) == 1 {
+		return name
+	}
+	return trimmed[1:]
+}
+
+// fileComment returns the text of the first line of a migration file if it is
+// a SQL comment.
+func fileComment(statement string) string {
+	line := strings.TrimSpace(strings.SplitN(statement, "\n", 2)[0])
+	if !strings.HasPrefix(line, "--") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "--"))
+}
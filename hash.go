@@ -0,0 +1,178 @@
+package sqlxm
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A HashFunc creates the checksum for a migration statement and args. The
+// checksum is stored in the migration table, so it must be deterministic and no
+// more than 64 characters long.
+type HashFunc func(query string, args []interface{}) string
+
+// SHA256Hash is the default HashFunc. It returns the 64 character hex encoded
+// SHA-256 hash of the query and args.
+func SHA256Hash(query string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(query)
+	for _, arg := range args {
+		b.WriteString(fmt.Sprintf("%v", arg))
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(b.String())))
+}
+
+// MD5Hash returns the 32 character hex encoded MD5 hash of the query and args.
+// It creates the same hashes as earlier versions of sqlxm, so it can be used
+// with migration tables created before SHA256Hash became the default.
+func MD5Hash(query string, args []interface{}) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(query+fmt.Sprintf("%v", args))))
+}
+
+// NormalizeStatement normalizes a SQL statement so formatting changes don't
+// change it. Line (--) and block (/* */) comments are removed, runs of
+// whitespace are collapsed to a single space, leading and trailing whitespace
+// is trimmed, and everything outside of quoted strings and identifiers,
+// including the SQL keywords, is lowercased.
+//
+// WithHashNormalization hashes the normalized statement, which gives the same
+// hash as calling NormalizeStatement before AddMigration, but the statement is
+// still run as it was written.
+func NormalizeStatement(stmt string) string {
+	var b strings.Builder
+	var quote rune
+	space := false
+	runes := []rune(stmt)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if quote != 0 {
+			b.WriteRune(r)
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		// Comments are replaced with whitespace.
+		if r == '-' && i+1 < len(runes) && runes[i+1] == '-' {
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		}
+		if r == '/' && i+1 < len(runes) && runes[i+1] == '*' {
+			i += 2
+			for i < len(runes) && !(runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '/') {
+				i++
+			}
+			i++
+			space = true
+			continue
+		}
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteRune(' ')
+		}
+		space = false
+		switch r {
+		case '\'', '"', '`':
+			quote = r
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// NormalizeStatements returns each of stmts normalized with NormalizeStatement.
+func NormalizeStatements(stmts []string) []string {
+	normalized := make([]string, len(stmts))
+	for i, stmt := range stmts {
+		normalized[i] = NormalizeStatement(stmt)
+	}
+	return normalized
+}
+
+// GlobalFingerprint returns the hash of the hashes of all the added migrations
+// in order, created with the Migrator HashFunc. Two Migrators with the same
+// migrations in the same order have the same fingerprint, so it can be compared
+// to find drift between environments, e.g. on a /version endpoint.
+func (m *Migrator) GlobalFingerprint() string {
+	var b strings.Builder
+	for _, mig := range m.migrations {
+		b.WriteString(mig.hash)
+	}
+	return m.hashFunc(b.String(), nil)
+}
+
+// A HashCollision is a hash shared by more than one migration, either because
+// the same statement was added under different names or, very rarely, because
+// two statements hash to the same value.
+type HashCollision struct {
+	Hash  string   `json:"hash"`
+	Names []string `json:"names"`
+}
+
+// DetectHashCollision returns every hash shared by more than one of the added
+// migrations, with the names of the migrations in the order they were added.
+// It is useful to find a statement that was copied into a new migration by
+// mistake. The collisions are in the order their hash was first added.
+func (m *Migrator) DetectHashCollision() []HashCollision {
+	hashes := make([]hashedName, len(m.migrations))
+	for i, mig := range m.migrations {
+		hashes[i] = hashedName{name: mig.Name, hash: mig.hash}
+	}
+	return hashCollisions(hashes)
+}
+
+// DetectStoredHashCollision is like DetectHashCollision, but checks the records
+// in the migration table, in the order they were applied. If the table does not
+// exist ErrMigrationTableNotFound is returned.
+func (m *Migrator) DetectStoredHashCollision() ([]HashCollision, error) {
+	exists, err := m.backend.HasMigrationTableContext(context.Background())
+	if err != nil {
+		return nil, errTableSetup("the migration table check failed", err)
+	}
+	if !exists {
+		return nil, ErrMigrationTableNotFound
+	}
+	records, err := m.backend.QueryRecords(m.db)
+	if err != nil {
+		return nil, fmt.Errorf("get migration records failed: %w", err)
+	}
+	hashes := make([]hashedName, len(records))
+	for i, r := range records {
+		hashes[i] = hashedName{name: r.Name, hash: r.Hash}
+	}
+	return hashCollisions(hashes), nil
+}
+
+// A hashedName is the name and hash of a migration or migration record.
+type hashedName struct {
+	name string
+	hash string
+}
+
+// hashCollisions returns the hashes shared by more than one name.
+func hashCollisions(hashes []hashedName) []HashCollision {
+	names := make(map[string][]string)
+	order := make([]string, 0)
+	for _, h := range hashes {
+		if _, seen := names[h.hash]; !seen {
+			order = append(order, h.hash)
+		}
+		names[h.hash] = append(names[h.hash], h.name)
+	}
+	collisions := make([]HashCollision, 0)
+	for _, hash := range order {
+		if len(names[hash]) > 1 {
+			collisions = append(collisions, HashCollision{Hash: hash, Names: names[hash]})
+		}
+	}
+	return collisions
+}
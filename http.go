@@ -0,0 +1,59 @@
+package sqlxm
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// StatusHandler returns an http.Handler that writes the Status of every
+// migration as a JSON array. The response status is 200 if all the applied
+// migrations have matching hashes, and 500 if there is a mismatch or the status
+// can't be queried. This is useful for health dashboards.
+func StatusHandler(m *Migrator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := m.Status()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		code := http.StatusOK
+		for _, s := range status {
+			if s.Applied && !s.Orphan && !s.HashMatch {
+				code = http.StatusInternalServerError
+			}
+		}
+		writeJSON(w, code, status)
+	})
+}
+
+// ReadyHandler returns an http.Handler for a readiness probe. The response
+// status is 200 if there are no pending migrations, 503 if there are, and 500
+// if the status can't be queried. The names of the pending migrations are
+// written as a JSON array.
+func ReadyHandler(m *Migrator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := m.Status()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		pending := make([]string, 0)
+		for _, s := range status {
+			if !s.Applied {
+				pending = append(pending, s.Name)
+			}
+		}
+		code := http.StatusOK
+		if len(pending) > 0 {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, pending)
+	})
+}
+
+// writeJSON writes v as the JSON response body with the status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
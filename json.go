@@ -0,0 +1,76 @@
+package sqlxm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MarshalText implements encoding.TextMarshaler, so a LogStatus is marshalled
+// to JSON as its name.
+func (s LogStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *LogStatus) UnmarshalText(text []byte) error {
+	for status, name := range logStatusNames {
+		if name == string(text) {
+			*s = LogStatus(status)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log status '%s'", text)
+}
+
+// migrationLogJSON is a MigrationLog without its methods, so it can be marshalled
+// without recursion.
+type migrationLogJSON MigrationLog
+
+// MarshalJSON implements json.Marshaler. The duration is written in
+// milliseconds.
+func (l MigrationLog) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		migrationLogJSON
+		Duration float64 `json:"duration_ms"`
+	}{
+		migrationLogJSON: migrationLogJSON(l),
+		Duration:         float64(l.Duration) / float64(time.Millisecond),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *MigrationLog) UnmarshalJSON(data []byte) error {
+	v := struct {
+		*migrationLogJSON
+		Duration float64 `json:"duration_ms"`
+	}{
+		migrationLogJSON: (*migrationLogJSON)(l),
+	}
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	l.Duration = time.Duration(v.Duration * float64(time.Millisecond))
+	return nil
+}
+
+// RunAndWrite runs the migrations like RunStrictContext, and writes the log to w as a
+// JSON array. The log is written even if the run fails, and the run error is
+// returned.
+func (m *Migrator) RunAndWrite(ctx context.Context, w io.Writer) error {
+	l, err := m.RunStrictContext(ctx)
+	if l == nil {
+		l = []MigrationLog{}
+	}
+	writeErr := json.NewEncoder(w).Encode(l)
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("write migration log failed: %w", writeErr)
+	}
+	return nil
+}
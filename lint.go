@@ -0,0 +1,151 @@
+package sqlxm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A LintRule checks the statement of a migration for problems before it is
+// run, e.g. a table without a primary key.
+type LintRule interface {
+	// Name of the rule, used in the LintError of each problem.
+	Name() string
+	// Check returns a LintError for each problem in the statement of the named
+	// migration, or nothing if there are no problems.
+	Check(name string, statement string) []LintError
+}
+
+// A LintError is a problem found in a migration by a LintRule.
+type LintError struct {
+	// Rule is the name of the rule that found the problem.
+	Rule string
+	// Migration is the name of the migration.
+	Migration string
+	// Message describes the problem.
+	Message string
+}
+
+func (e LintError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Migration, e.Rule, e.Message)
+}
+
+// LintErrors is every LintError found in a run. errors.As can be used to get
+// them from the error returned by the run.
+type LintErrors []LintError
+
+func (e LintErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("lint failed: %s", strings.Join(msgs, "; "))
+}
+
+// WithLinter checks the pending migrations with rules before each run. Every
+// rule is checked against every pending migration, and if any problems are
+// found the run returns them all as LintErrors without applying anything.
+//
+//    m, err := sqlxm.New(db, "migrations", "public", sqlxm.WithLinter(
+//        sqlxm.PrimaryKeyRule(),
+//        sqlxm.DropCommentRule(),
+//    ))
+func WithLinter(rules ...LintRule) Option {
+	return func(m *Migrator) {
+		m.lintRules = append(m.lintRules, rules...)
+	}
+}
+
+// lint checks the migrations that have not been run with the lint rules.
+func (m *Migrator) lint(migrations []Migration, prev map[string]string) error {
+	var errs LintErrors
+	for _, mig := range migrations {
+		if _, exists := prev[mig.Name]; exists {
+			continue
+		}
+		for _, rule := range m.lintRules {
+			errs = append(errs, rule.Check(mig.Name, mig.Statement)...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// PrimaryKeyRule returns a LintRule that finds CREATE TABLE statements without
+// a PRIMARY KEY. Tables created from a query with CREATE TABLE AS are not
+// checked.
+func PrimaryKeyRule() LintRule {
+	return primaryKeyRule{}
+}
+
+type primaryKeyRule struct{}
+
+// Name implements LintRule.
+func (primaryKeyRule) Name() string {
+	return "primary-key"
+}
+
+// Check implements LintRule.
+func (r primaryKeyRule) Check(name string, statement string) []LintError {
+	var errs []LintError
+	for _, stmt := range splitStatements(statement) {
+		s := NormalizeStatement(stmt)
+		if !isCreateTable(s) || strings.Contains(s, " as select ") {
+			continue
+		}
+		if !strings.Contains(s, "primary key") {
+			errs = append(errs, LintError{Rule: r.Name(), Migration: name, Message: "CREATE TABLE has no PRIMARY KEY"})
+		}
+	}
+	return errs
+}
+
+// DropCommentRule returns a LintRule that finds DROP TABLE statements without a
+// SQL comment before them explaining why the table is dropped.
+func DropCommentRule() LintRule {
+	return dropCommentRule{}
+}
+
+type dropCommentRule struct{}
+
+// Name implements LintRule.
+func (dropCommentRule) Name() string {
+	return "drop-comment"
+}
+
+// Check implements LintRule.
+func (r dropCommentRule) Check(name string, statement string) []LintError {
+	var errs []LintError
+	for _, stmt := range splitStatements(statement) {
+		if !strings.HasPrefix(NormalizeStatement(stmt), "drop table") {
+			continue
+		}
+		if !strings.Contains(stmt, "--") && !strings.Contains(stmt, "/*") {
+			errs = append(errs, LintError{Rule: r.Name(), Migration: name, Message: "DROP TABLE has no comment"})
+		}
+	}
+	return errs
+}
+
+// splitStatements splits a migration statement into the statements separated
+// by semicolons. The comments before a statement are part of it.
+func splitStatements(statement string) []string {
+	stmts := make([]string, 0)
+	for _, s := range strings.Split(statement, ";") {
+		if strings.TrimSpace(s) != "" {
+			stmts = append(stmts, s)
+		}
+	}
+	return stmts
+}
+
+// isCreateTable returns true if the normalized statement creates a table.
+func isCreateTable(s string) bool {
+	for _, prefix := range []string{"create table ", "create temp table ", "create temporary table "} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package sqlxm
+
+// A Logger receives structured log lines while migrations are run, so operators
+// get feedback without waiting for the MigrationLog. The fields are alternating
+// keys and values, like log/slog.
+//
+// See SlogLogger for a log/slog adapter. zap's SugaredLogger can be adapted with
+// its Debugw, Infow, Warnw and Errorw methods, and logrus with WithFields.
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+// WithLogger sets the Logger for the Migrator. Nothing is logged by default.
+func WithLogger(l Logger) Option {
+	return func(m *Migrator) {
+		if l == nil {
+			l = NoOpLogger()
+		}
+		m.logger = l
+	}
+}
+
+// NoOpLogger returns a Logger that discards everything.
+func NoOpLogger() Logger {
+	return noOpLogger{}
+}
+
+// noOpLogger is the default Logger.
+type noOpLogger struct{}
+
+func (noOpLogger) Debug(string, ...interface{}) {}
+func (noOpLogger) Info(string, ...interface{})  {}
+func (noOpLogger) Warn(string, ...interface{})  {}
+func (noOpLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,268 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MarkApplied records the named migrations as applied without running their
+// statements. This is useful when adopting sqlxm on a database that already has
+// the schema in place.
+//
+// The migration table is created if it does not exist, and all the records are
+// inserted in a single transaction. A log entry is returned for each name.
+// Migrations that already have a record are logged with the PREVIOUS status and
+// skipped. If a name has not been added to the Migrator an error is returned
+// before anything is written to the DB. If the transaction can't be committed
+// the marked migrations are logged with the ERROR status.
+func (m *Migrator) MarkApplied(names ...string) (logs []MigrationLog, err error) {
+	ctx := context.Background()
+	logs = make([]MigrationLog, 0, len(names))
+	defer func() {
+		m.log = append(m.log, logs...)
+	}()
+
+	migs := make([]Migration, 0, len(names))
+	for _, name := range names {
+		i, exists := m.findMigration(name)
+		if !exists {
+			return logs, fmt.Errorf("mark '%s' applied failed: migration has not been added", name)
+		}
+		migs = append(migs, m.migrations[i])
+	}
+
+	err = m.backend.Lock(ctx, m.lockTimeout)
+	if err != nil {
+		return logs, fmt.Errorf("acquire migration lock failed: %w", err)
+	}
+	defer m.backend.Unlock(context.Background())
+
+	err = m.ensureMigrationTable(ctx)
+	if err != nil {
+		return logs, err
+	}
+
+	prev, err := m.backend.QueryPreviousContext(ctx)
+	if err != nil {
+		return logs, fmt.Errorf("get previous migrations failed: %w", err)
+	}
+
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return logs, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	commit := true
+	defer func() {
+		if m.rollbackOnPanic {
+			if r := recover(); r != nil {
+				m.rollbackTx(tx)
+				panic(r)
+			}
+		}
+		if commit {
+			cerr := m.commitTx(tx)
+			if cerr != nil {
+				err = fmt.Errorf("commit transaction failed: %w", cerr)
+				failUncommitted(logs, cerr)
+			}
+			return
+		}
+		m.rollbackTx(tx)
+	}()
+
+	for _, mig := range migs {
+		l := MigrationLog{
+			Name:    mig.Name,
+			Hash:    mig.hash,
+			Status:  SUCCESS,
+			Details: "marked migration as applied",
+		}
+		if _, exists := prev[mig.Name]; exists {
+			l.Status = PREVIOUS
+			l.Details = "migration already run"
+			logs = append(logs, l)
+			continue
+		}
+		err = mig.insertRecord(ctx, tx, m, 0)
+		if err != nil {
+			commit = false
+			l.Status = ERROR
+			l.Details = fmt.Sprintf("record insert failed: %s", err)
+			logs = append(logs, l)
+			return logs, fmt.Errorf("mark '%s' applied failed: %w", mig.Name, err)
+		}
+		prev[mig.Name] = mig.hash
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+// ForceRun runs the named migration again even if it has already been applied.
+// The existing record is deleted, the statement is run, and a new record with
+// the current hash is inserted, all in a single transaction. If the migration
+// has not been applied it is run normally. This is useful when a data fix
+// migration has been updated.
+//
+// ForceRun is not allowed if the Migrator was created with WithSafeMode, so it
+// returns ErrSafeMode. An error is also returned if the migration has not been
+// added. If the transaction can't be committed the migration is logged with the
+// ERROR status.
+func (m *Migrator) ForceRun(name string) (logs []MigrationLog, err error) {
+	i, exists := m.findMigration(name)
+	if !exists {
+		return m.log, fmt.Errorf("force run '%s' failed: migration has not been added", name)
+	}
+	if m.safeMode {
+		return m.log, fmt.Errorf("force run '%s' failed: %w", name, ErrSafeMode)
+	}
+	mig := m.migrations[i]
+	mig.NoTransaction = mig.NoTransaction || m.nonTransactional
+	ctx := context.Background()
+
+	err = m.backend.Lock(ctx, m.lockTimeout)
+	if err != nil {
+		return m.log, fmt.Errorf("acquire migration lock failed: %w", err)
+	}
+	defer m.backend.Unlock(context.Background())
+
+	err = m.ensureMigrationTable(ctx)
+	if err != nil {
+		return m.log, err
+	}
+
+	prev, err := m.backend.QueryPreviousContext(ctx)
+	if err != nil {
+		return m.log, fmt.Errorf("get previous migrations failed: %w", err)
+	}
+	m.previous = prev
+
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return m.log, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	commit := true
+	// Set once the transaction has been committed before the migration is run
+	// outside of it.
+	committed := false
+	start := len(m.log)
+	defer func() {
+		if m.rollbackOnPanic {
+			if r := recover(); r != nil {
+				if !committed {
+					m.rollbackTx(tx)
+				}
+				panic(r)
+			}
+		}
+		if committed {
+			return
+		}
+		if commit {
+			cerr := m.commitTx(tx)
+			if cerr != nil {
+				err = fmt.Errorf("commit transaction failed: %w", cerr)
+				failUncommitted(m.log[start:], cerr)
+			}
+			return
+		}
+		m.rollbackTx(tx)
+	}()
+
+	if _, exists := m.previous[name]; exists {
+		err = m.backend.DeleteRecord(tx, name)
+		if err != nil {
+			commit = false
+			return m.log, fmt.Errorf("delete record for '%s' failed: %w", name, err)
+		}
+		delete(m.previous, name)
+	}
+
+	if mig.NoTransaction {
+		// The record is deleted before the migration is run outside of the
+		// transaction.
+		err = m.commitTx(tx)
+		if err != nil {
+			commit = false
+			return m.log, fmt.Errorf("commit transaction failed: %w", err)
+		}
+		committed = true
+	}
+	err = m.executeMigration(ctx, tx, mig)
+	if err != nil {
+		commit = false
+		return m.log, fmt.Errorf("run error on '%s': %w", name, err)
+	}
+	return m.log, nil
+}
+
+// DeleteRecord deletes the record of the named migration from the migration
+// table, so the next run applies the migration again, e.g. to re-run a
+// migration from scratch after it failed part of the way through. Nothing is
+// done to undo the statement of the migration.
+//
+// The name does not have to be added to the Migrator, so orphan records can be
+// deleted as well. ErrMigrationNotFound is returned if there is no record for
+// the name.
+func (m *Migrator) DeleteRecord(name string) error {
+	err := m.changeRecord(name, "delete record", func(tx *sqlx.Tx) error {
+		return m.backend.DeleteRecord(tx, name)
+	})
+	if err != nil {
+		return err
+	}
+	delete(m.previous, name)
+	return nil
+}
+
+// UpdateMigrationComment replaces the stored comment of the named migration,
+// e.g. to fix a typo. The comment of the added migration is not changed, so it
+// should be fixed as well. ErrMigrationNotFound is returned if there is no
+// record for the name.
+func (m *Migrator) UpdateMigrationComment(name string, comment string) error {
+	return m.changeRecord(name, "update comment", func(tx *sqlx.Tx) error {
+		return m.backend.UpdateComment(tx, name, comment)
+	})
+}
+
+// changeRecord calls fn in a transaction if the migration table has a record
+// for the named migration. The errors are prefixed with action.
+func (m *Migrator) changeRecord(name string, action string, fn func(tx *sqlx.Tx) error) error {
+	ctx := context.Background()
+	err := m.backend.Lock(ctx, m.lockTimeout)
+	if err != nil {
+		return fmt.Errorf("acquire migration lock failed: %w", err)
+	}
+	defer m.backend.Unlock(context.Background())
+
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil {
+		return errTableSetup("the migration table check failed", err)
+	}
+	if !exists {
+		return fmt.Errorf("%s '%s' failed: %w", action, name, ErrMigrationNotFound)
+	}
+	prev, err := m.backend.QueryPreviousContext(ctx)
+	if err != nil {
+		return fmt.Errorf("get previous migrations failed: %w", err)
+	}
+	if _, exists := prev[name]; !exists {
+		return fmt.Errorf("%s '%s' failed: %w", action, name, ErrMigrationNotFound)
+	}
+
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	err = fn(tx)
+	if err != nil {
+		m.rollbackTx(tx)
+		return fmt.Errorf("%s '%s' failed: %w", action, name, err)
+	}
+	err = m.commitTx(tx)
+	if err != nil {
+		return fmt.Errorf("commit transaction failed: %w", err)
+	}
+	return nil
+}
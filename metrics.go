@@ -0,0 +1,27 @@
+package sqlxm
+
+import "time"
+
+// MetricsCollector receives metrics about each migration in a run. See the
+// sqlxmprom package for a Prometheus implementation.
+type MetricsCollector interface {
+	// OnMigrationRun is called after each migration with the status it was
+	// logged with and how long it took.
+	OnMigrationRun(name string, status LogStatus, duration time.Duration)
+}
+
+// A BackendMetricsCollector receives metrics about the backend calls timed by
+// TimingMiddleware. They are reported apart from the migrations, so backend
+// calls are not counted as migration runs.
+type BackendMetricsCollector interface {
+	// OnBackendCall is called after each backend call with the method name,
+	// e.g. "QueryRecords", SUCCESS or ERROR, and how long it took.
+	OnBackendCall(method string, status LogStatus, duration time.Duration)
+}
+
+// WithMetrics sets a MetricsCollector to report each migration to.
+func WithMetrics(c MetricsCollector) Option {
+	return func(m *Migrator) {
+		m.metrics = c
+	}
+}
@@ -0,0 +1,429 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/danielmorell/sqlxm/backends"
+	"github.com/jmoiron/sqlx"
+)
+
+// A BackendMiddleware wraps a Backend to add behavior around its methods, for
+// example timing, logging or a circuit breaker, like an http.Handler
+// middleware. It must return a Backend that calls next.
+type BackendMiddleware func(next backends.Backend) backends.Backend
+
+// UseBackendMiddleware wraps the backend of the Migrator with mw. The first
+// middleware is the outermost, so it is called first and returns last. The
+// middleware is kept when the backend is changed with UseBackend.
+//
+//    m.UseBackendMiddleware(
+//        sqlxm.LoggingMiddleware(logger),
+//        sqlxm.TimingMiddleware(collector),
+//    )
+func (m *Migrator) UseBackendMiddleware(mw ...BackendMiddleware) {
+	m.middleware = append(m.middleware, mw...)
+	if m.baseBackend != nil {
+		m.backend = m.wrapBackend(m.baseBackend)
+	}
+}
+
+// wrapBackend applies the Migrator namespace and middleware to b.
+func (m *Migrator) wrapBackend(b backends.Backend) backends.Backend {
+	if m.namespace != "" {
+		b = newNamespaceBackend(b, m.namespace)
+	}
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		b = m.middleware[i](b)
+	}
+	return b
+}
+
+// LoggingMiddleware logs every backend call with its duration to logger at the
+// debug level, or at the error level if it fails.
+func LoggingMiddleware(logger Logger) BackendMiddleware {
+	return func(next backends.Backend) backends.Backend {
+		return &hookBackend{next: next, after: func(method string, d time.Duration, err error) {
+			if err != nil {
+				logger.Error("backend call failed", "method", method, "duration", d, "error", err)
+				return
+			}
+			logger.Debug("backend call", "method", method, "duration", d)
+		}}
+	}
+}
+
+// TimingMiddleware reports how long every backend call took to the
+// OnBackendCall method of collector, with the status SUCCESS or ERROR.
+func TimingMiddleware(collector BackendMetricsCollector) BackendMiddleware {
+	return func(next backends.Backend) backends.Backend {
+		return &hookBackend{next: next, after: func(method string, d time.Duration, err error) {
+			status := SUCCESS
+			if err != nil {
+				status = ERROR
+			}
+			collector.OnBackendCall(method, status, d)
+		}}
+	}
+}
+
+// hookBackend is a Backend that calls after once each method of next returns.
+// It also implements the optional backends.Retrier, backends.StatementRunner,
+// backends.Claimer, backends.Savepointer and backends.QueryTimeouter
+// interfaces, so wrapping a backend doesn't change how migrations are run.
+type hookBackend struct {
+	next  backends.Backend
+	after func(method string, d time.Duration, err error)
+}
+
+// call runs fn and calls h.after with how long it took.
+func (h *hookBackend) call(method string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	h.after(method, time.Since(start), err)
+	return err
+}
+
+func (h *hookBackend) Setup(db *sqlx.DB, table string, tableSchema string) {
+	h.call("Setup", func() error {
+		h.next.Setup(db, table, tableSchema)
+		return nil
+	})
+}
+
+func (h *hookBackend) Ping(ctx context.Context) error {
+	return h.call("Ping", func() error {
+		return h.next.Ping(ctx)
+	})
+}
+
+func (h *hookBackend) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return h.call("InsertRecord", func() error {
+		return h.next.InsertRecord(tx, name, hash, comment, executionMs)
+	})
+}
+
+func (h *hookBackend) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return h.call("InsertRecordContext", func() error {
+		return h.next.InsertRecordContext(ctx, tx, name, hash, comment, executionMs)
+	})
+}
+
+func (h *hookBackend) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...backends.ExtraField) error {
+	return h.call("InsertRecordWithApplier", func() error {
+		return h.next.InsertRecordWithApplier(ctx, tx, name, hash, comment, executionMs, appliedBy, extra...)
+	})
+}
+
+func (h *hookBackend) HasMigrationTable() (exists bool, err error) {
+	err = h.call("HasMigrationTable", func() (err error) {
+		exists, err = h.next.HasMigrationTable()
+		return err
+	})
+	return exists, err
+}
+
+func (h *hookBackend) HasMigrationTableContext(ctx context.Context) (exists bool, err error) {
+	err = h.call("HasMigrationTableContext", func() (err error) {
+		exists, err = h.next.HasMigrationTableContext(ctx)
+		return err
+	})
+	return exists, err
+}
+
+func (h *hookBackend) QueryPrevious() (prev map[string]string, err error) {
+	err = h.call("QueryPrevious", func() (err error) {
+		prev, err = h.next.QueryPrevious()
+		return err
+	})
+	return prev, err
+}
+
+func (h *hookBackend) QueryPreviousContext(ctx context.Context) (prev map[string]string, err error) {
+	err = h.call("QueryPreviousContext", func() (err error) {
+		prev, err = h.next.QueryPreviousContext(ctx)
+		return err
+	})
+	return prev, err
+}
+
+func (h *hookBackend) CreateMigrationTable() (q string, err error) {
+	err = h.call("CreateMigrationTable", func() (err error) {
+		q, err = h.next.CreateMigrationTable()
+		return err
+	})
+	return q, err
+}
+
+func (h *hookBackend) CreateMigrationTableContext(ctx context.Context) (q string, err error) {
+	err = h.call("CreateMigrationTableContext", func() (err error) {
+		q, err = h.next.CreateMigrationTableContext(ctx)
+		return err
+	})
+	return q, err
+}
+
+func (h *hookBackend) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
+	return h.call("RepairHashes", func() error {
+		return h.next.RepairHashes(tx, hashes)
+	})
+}
+
+func (h *hookBackend) OverrideColumns(columns map[string]string) {
+	h.call("OverrideColumns", func() error {
+		h.next.OverrideColumns(columns)
+		return nil
+	})
+}
+
+func (h *hookBackend) ExtendColumns(columns []backends.RecordColumn) {
+	h.call("ExtendColumns", func() error {
+		h.next.ExtendColumns(columns)
+		return nil
+	})
+}
+
+func (h *hookBackend) AddColumns(ctx context.Context, columns []backends.RecordColumn) (q string, err error) {
+	err = h.call("AddColumns", func() (err error) {
+		q, err = h.next.AddColumns(ctx, columns)
+		return err
+	})
+	return q, err
+}
+
+func (h *hookBackend) MigrateMigrationTable() error {
+	return h.call("MigrateMigrationTable", func() error {
+		return h.next.MigrateMigrationTable()
+	})
+}
+
+func (h *hookBackend) ListTables() (tables []string, err error) {
+	err = h.call("ListTables", func() (err error) {
+		tables, err = h.next.ListTables()
+		return err
+	})
+	return tables, err
+}
+
+func (h *hookBackend) QueryRecords(q sqlx.Queryer) (records []backends.MigrationRecord, err error) {
+	err = h.call("QueryRecords", func() (err error) {
+		records, err = h.next.QueryRecords(q)
+		return err
+	})
+	return records, err
+}
+
+func (h *hookBackend) CountRecords(ctx context.Context) (count int, err error) {
+	err = h.call("CountRecords", func() (err error) {
+		count, err = h.next.CountRecords(ctx)
+		return err
+	})
+	return count, err
+}
+
+func (h *hookBackend) QueryChecksum() (checksum string, err error) {
+	err = h.call("QueryChecksum", func() (err error) {
+		checksum, err = h.next.QueryChecksum()
+		return err
+	})
+	return checksum, err
+}
+
+func (h *hookBackend) StoreChecksum(tx *sqlx.Tx, checksum string) error {
+	return h.call("StoreChecksum", func() error {
+		return h.next.StoreChecksum(tx, checksum)
+	})
+}
+
+func (h *hookBackend) DeleteRecord(tx *sqlx.Tx, name string) error {
+	return h.call("DeleteRecord", func() error {
+		return h.next.DeleteRecord(tx, name)
+	})
+}
+
+func (h *hookBackend) CleanTable() error {
+	return h.call("CleanTable", func() error {
+		return h.next.CleanTable()
+	})
+}
+
+func (h *hookBackend) UpdateComment(tx *sqlx.Tx, name string, comment string) error {
+	return h.call("UpdateComment", func() error {
+		return h.next.UpdateComment(tx, name, comment)
+	})
+}
+
+func (h *hookBackend) ArchiveRecords(tx *sqlx.Tx, before time.Time) error {
+	return h.call("ArchiveRecords", func() error {
+		return h.next.ArchiveRecords(tx, before)
+	})
+}
+
+func (h *hookBackend) Lock(ctx context.Context, timeout time.Duration) error {
+	return h.call("Lock", func() error {
+		return h.next.Lock(ctx, timeout)
+	})
+}
+
+func (h *hookBackend) Unlock(ctx context.Context) error {
+	return h.call("Unlock", func() error {
+		return h.next.Unlock(ctx)
+	})
+}
+
+// ClaimRecord calls the ClaimRecord method of next if it is a
+// backends.Claimer, otherwise ErrNotApplicable is returned.
+func (h *hookBackend) ClaimRecord(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, appliedBy string, extra ...backends.ExtraField) (claimed bool, err error) {
+	c, ok := h.next.(backends.Claimer)
+	if !ok {
+		return false, fmt.Errorf("%w: the backend does not support claims", backends.ErrNotApplicable)
+	}
+	err = h.call("ClaimRecord", func() (err error) {
+		claimed, err = c.ClaimRecord(ctx, tx, name, hash, comment, appliedBy, extra...)
+		return err
+	})
+	return claimed, err
+}
+
+// CompleteRecord calls the CompleteRecord method of next if it is a
+// backends.Claimer, otherwise ErrNotApplicable is returned.
+func (h *hookBackend) CompleteRecord(ctx context.Context, tx *sqlx.Tx, name string, executionMs int64) error {
+	c, ok := h.next.(backends.Claimer)
+	if !ok {
+		return fmt.Errorf("%w: the backend does not support claims", backends.ErrNotApplicable)
+	}
+	return h.call("CompleteRecord", func() error {
+		return c.CompleteRecord(ctx, tx, name, executionMs)
+	})
+}
+
+// Retry calls the Retry method of next if it is a backends.Retrier, otherwise
+// fn is called once.
+func (h *hookBackend) Retry(ctx context.Context, tx *sqlx.Tx, fn func() error) error {
+	r, ok := h.next.(backends.Retrier)
+	if !ok {
+		return fn()
+	}
+	return h.call("Retry", func() error {
+		return r.Retry(ctx, tx, fn)
+	})
+}
+
+// RunStatement calls the RunStatement method of next if it is a
+// backends.StatementRunner, otherwise the statement is run in tx.
+func (h *hookBackend) RunStatement(ctx context.Context, tx *sqlx.Tx, statement string, args ...interface{}) error {
+	return h.call("RunStatement", func() error {
+		if r, ok := h.next.(backends.StatementRunner); ok {
+			return r.RunStatement(ctx, tx, statement, args...)
+		}
+		_, err := tx.ExecContext(ctx, statement, args...)
+		return err
+	})
+}
+
+// Savepoint calls the Savepoint method of next if it is a backends.Savepointer,
+// otherwise the standard statement is used.
+func (h *hookBackend) Savepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	return h.call("Savepoint", func() error {
+		if s, ok := h.next.(backends.Savepointer); ok {
+			return s.Savepoint(ctx, tx, name)
+		}
+		return backends.Savepoint(ctx, tx, name)
+	})
+}
+
+// RollbackToSavepoint calls the RollbackToSavepoint method of next if it is a
+// backends.Savepointer, otherwise the standard statement is used.
+func (h *hookBackend) RollbackToSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	return h.call("RollbackToSavepoint", func() error {
+		if s, ok := h.next.(backends.Savepointer); ok {
+			return s.RollbackToSavepoint(ctx, tx, name)
+		}
+		return backends.RollbackToSavepoint(ctx, tx, name)
+	})
+}
+
+// ReleaseSavepoint calls the ReleaseSavepoint method of next if it is a
+// backends.Savepointer, otherwise the standard statement is used.
+func (h *hookBackend) ReleaseSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	return h.call("ReleaseSavepoint", func() error {
+		if s, ok := h.next.(backends.Savepointer); ok {
+			return s.ReleaseSavepoint(ctx, tx, name)
+		}
+		return backends.ReleaseSavepoint(ctx, tx, name)
+	})
+}
+
+// Explain calls the Explain method of next if it is a backends.Explainer,
+// otherwise ErrNotApplicable is returned.
+func (h *hookBackend) Explain(ctx context.Context, statement string, args ...interface{}) (plan string, err error) {
+	e, ok := h.next.(backends.Explainer)
+	if !ok {
+		return "", fmt.Errorf("%w: the backend does not support EXPLAIN", backends.ErrNotApplicable)
+	}
+	err = h.call("Explain", func() (err error) {
+		plan, err = e.Explain(ctx, statement, args...)
+		return err
+	})
+	return plan, err
+}
+
+// CaptureNotices calls the CaptureNotices method of next if it is a
+// backends.NoticeCapturer.
+func (h *hookBackend) CaptureNotices(w io.Writer) {
+	if n, ok := h.next.(backends.NoticeCapturer); ok {
+		n.CaptureNotices(w)
+	}
+}
+
+// WatchNotices calls the WatchNotices method of next if it is a
+// backends.NoticeCapturer, otherwise nothing is done.
+func (h *hookBackend) WatchNotices(ctx context.Context, conn *sqlx.Conn) (stop func(), err error) {
+	n, ok := h.next.(backends.NoticeCapturer)
+	if !ok {
+		return func() {}, nil
+	}
+	err = h.call("WatchNotices", func() (err error) {
+		stop, err = n.WatchNotices(ctx, conn)
+		return err
+	})
+	return stop, err
+}
+
+// TimeoutStatement calls the TimeoutStatement method of next if it is a
+// backends.QueryTimeouter, otherwise the statement is returned unchanged.
+func (h *hookBackend) TimeoutStatement(ctx context.Context, tx *sqlx.Tx, statement string, timeout time.Duration) (timed string, err error) {
+	t, ok := h.next.(backends.QueryTimeouter)
+	if !ok {
+		return statement, nil
+	}
+	err = h.call("TimeoutStatement", func() (err error) {
+		timed, err = t.TimeoutStatement(ctx, tx, statement, timeout)
+		return err
+	})
+	return timed, err
+}
+
+// IsQueryTimeout calls the IsQueryTimeout method of next if it is a
+// backends.QueryTimeouter.
+func (h *hookBackend) IsQueryTimeout(err error) bool {
+	if t, ok := h.next.(backends.QueryTimeouter); ok {
+		return t.IsQueryTimeout(err)
+	}
+	return false
+}
+
+// CreateHistoryTable calls the CreateHistoryTable method of next if it is a
+// backends.HistoryTableCreator, otherwise nothing is done, since the history
+// table is created by ArchiveRecords.
+func (h *hookBackend) CreateHistoryTable(ctx context.Context) error {
+	c, ok := h.next.(backends.HistoryTableCreator)
+	if !ok {
+		return nil
+	}
+	return h.call("CreateHistoryTable", func() error {
+		return c.CreateHistoryTable(ctx)
+	})
+}
@@ -0,0 +1,5 @@
+// Package sqlxmpb has the generated protobuf and gRPC code for the sqlxm
+// MigrationService. The server is in the sqlxmgrpc package.
+package sqlxmpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sqlxm.proto
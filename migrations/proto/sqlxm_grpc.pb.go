@@ -0,0 +1,206 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.19.1
+// source: sqlxm.proto
+
+package sqlxmpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// MigrationServiceClient is the client API for MigrationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MigrationServiceClient interface {
+	GetStatus(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*MigrationStatusResponse, error)
+	RunMigrations(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (MigrationService_RunMigrationsClient, error)
+	ValidateMigrations(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ValidationResponse, error)
+}
+
+type migrationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMigrationServiceClient(cc grpc.ClientConnInterface) MigrationServiceClient {
+	return &migrationServiceClient{cc}
+}
+
+func (c *migrationServiceClient) GetStatus(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*MigrationStatusResponse, error) {
+	out := new(MigrationStatusResponse)
+	err := c.cc.Invoke(ctx, "/sqlxm.v1.MigrationService/GetStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *migrationServiceClient) RunMigrations(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (MigrationService_RunMigrationsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MigrationService_ServiceDesc.Streams[0], "/sqlxm.v1.MigrationService/RunMigrations", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &migrationServiceRunMigrationsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MigrationService_RunMigrationsClient interface {
+	Recv() (*MigrationEvent, error)
+	grpc.ClientStream
+}
+
+type migrationServiceRunMigrationsClient struct {
+	grpc.ClientStream
+}
+
+func (x *migrationServiceRunMigrationsClient) Recv() (*MigrationEvent, error) {
+	m := new(MigrationEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *migrationServiceClient) ValidateMigrations(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ValidationResponse, error) {
+	out := new(ValidationResponse)
+	err := c.cc.Invoke(ctx, "/sqlxm.v1.MigrationService/ValidateMigrations", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MigrationServiceServer is the server API for MigrationService service.
+// All implementations must embed UnimplementedMigrationServiceServer
+// for forward compatibility
+type MigrationServiceServer interface {
+	GetStatus(context.Context, *emptypb.Empty) (*MigrationStatusResponse, error)
+	RunMigrations(*RunRequest, MigrationService_RunMigrationsServer) error
+	ValidateMigrations(context.Context, *emptypb.Empty) (*ValidationResponse, error)
+	mustEmbedUnimplementedMigrationServiceServer()
+}
+
+// UnimplementedMigrationServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMigrationServiceServer struct {
+}
+
+func (UnimplementedMigrationServiceServer) GetStatus(context.Context, *emptypb.Empty) (*MigrationStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedMigrationServiceServer) RunMigrations(*RunRequest, MigrationService_RunMigrationsServer) error {
+	return status.Errorf(codes.Unimplemented, "method RunMigrations not implemented")
+}
+func (UnimplementedMigrationServiceServer) ValidateMigrations(context.Context, *emptypb.Empty) (*ValidationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ValidateMigrations not implemented")
+}
+func (UnimplementedMigrationServiceServer) mustEmbedUnimplementedMigrationServiceServer() {}
+
+// UnsafeMigrationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MigrationServiceServer will
+// result in compilation errors.
+type UnsafeMigrationServiceServer interface {
+	mustEmbedUnimplementedMigrationServiceServer()
+}
+
+func RegisterMigrationServiceServer(s grpc.ServiceRegistrar, srv MigrationServiceServer) {
+	s.RegisterService(&MigrationService_ServiceDesc, srv)
+}
+
+func _MigrationService_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MigrationServiceServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sqlxm.v1.MigrationService/GetStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MigrationServiceServer).GetStatus(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MigrationService_RunMigrations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RunRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MigrationServiceServer).RunMigrations(m, &migrationServiceRunMigrationsServer{stream})
+}
+
+type MigrationService_RunMigrationsServer interface {
+	Send(*MigrationEvent) error
+	grpc.ServerStream
+}
+
+type migrationServiceRunMigrationsServer struct {
+	grpc.ServerStream
+}
+
+func (x *migrationServiceRunMigrationsServer) Send(m *MigrationEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MigrationService_ValidateMigrations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MigrationServiceServer).ValidateMigrations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sqlxm.v1.MigrationService/ValidateMigrations",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MigrationServiceServer).ValidateMigrations(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MigrationService_ServiceDesc is the grpc.ServiceDesc for MigrationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MigrationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sqlxm.v1.MigrationService",
+	HandlerType: (*MigrationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStatus",
+			Handler:    _MigrationService_GetStatus_Handler,
+		},
+		{
+			MethodName: "ValidateMigrations",
+			Handler:    _MigrationService_ValidateMigrations_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RunMigrations",
+			Handler:       _MigrationService_RunMigrations_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "sqlxm.proto",
+}
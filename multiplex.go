@@ -0,0 +1,116 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A MultiplexedMigrator runs migrations on several databases in parallel, e.g.
+// the shards of a sharded database that all have the same schema. Each shard
+// is a Migrator with its own DB, and usually the same migrations.
+type MultiplexedMigrator struct {
+	shards []shard
+	// The most shards that are migrated at the same time, or 0 for no limit.
+	concurrency int
+}
+
+// shard is a Migrator of a MultiplexedMigrator and its identifier.
+type shard struct {
+	id string
+	m  *Migrator
+}
+
+// NewMultiplexedMigrator creates a MultiplexedMigrator that migrates up to
+// concurrency shards at the same time. There is no limit if concurrency is 0.
+func NewMultiplexedMigrator(concurrency int) *MultiplexedMigrator {
+	return &MultiplexedMigrator{concurrency: concurrency}
+}
+
+// AddShard adds m as the shard with the identifier id, which is used in the
+// results and errors of RunAll.
+//
+// The shards are run in parallel, so each needs its own backend instance. The
+// built-in backends and the ones registered with RegisterBackendFactory are
+// created for each Migrator, but a backend registered with RegisterBackend is
+// shared and set up for the DB of the last Migrator that used it, so it can't
+// be used by shards with different DBs.
+func (mm *MultiplexedMigrator) AddShard(id string, m *Migrator) error {
+	for _, s := range mm.shards {
+		if s.id == id {
+			return fmt.Errorf("shard '%s' already exists", id)
+		}
+	}
+	mm.shards = append(mm.shards, shard{id: id, m: m})
+	return nil
+}
+
+// RunAll runs the migrations of every shard with RunContext, and returns the
+// migration log of each shard keyed by its identifier. A failed shard doesn't
+// stop the others, and if any of them failed the error is a ShardErrors with
+// the error of each, in the order the shards were added.
+func (mm *MultiplexedMigrator) RunAll(ctx context.Context) (map[string][]MigrationLog, error) {
+	logs := make([][]MigrationLog, len(mm.shards))
+	errs := make([]error, len(mm.shards))
+
+	limit := mm.concurrency
+	if limit <= 0 || limit > len(mm.shards) {
+		limit = len(mm.shards)
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, s := range mm.shards {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, s shard) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			logs[i], errs[i] = s.m.RunContext(ctx)
+		}(i, s)
+	}
+	wg.Wait()
+
+	results := make(map[string][]MigrationLog, len(mm.shards))
+	var failed ShardErrors
+	for i, s := range mm.shards {
+		results[s.id] = logs[i]
+		if errs[i] != nil {
+			failed = append(failed, ShardError{Shard: s.id, Err: errs[i]})
+		}
+	}
+	if len(failed) > 0 {
+		return results, failed
+	}
+	return results, nil
+}
+
+// A ShardError is the error of the run on a shard of a MultiplexedMigrator.
+type ShardError struct {
+	// Shard is the identifier of the shard.
+	Shard string
+	Err   error
+}
+
+func (e ShardError) Error() string {
+	return fmt.Sprintf("shard '%s': %s", e.Shard, e.Err)
+}
+
+// Unwrap returns the error of the run.
+func (e ShardError) Unwrap() error {
+	return e.Err
+}
+
+// ShardErrors is every ShardError of a RunAll. errors.As can be used to get
+// them from the error returned by RunAll.
+type ShardErrors []ShardError
+
+func (e ShardErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("migrations failed on %d shards: %s", len(e), strings.Join(msgs, "; "))
+}
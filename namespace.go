@@ -0,0 +1,173 @@
+package sqlxm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/danielmorell/sqlxm/backends"
+	"github.com/jmoiron/sqlx"
+)
+
+// WithNamespacePrefix scopes the migration records to prefix, so several
+// modules can share a single migration table without their migration names
+// colliding. The name of each migration is stored with prefix + ":" in front of
+// it, and only the records with the prefix are read, so a Migrator doesn't see
+// the migrations of other namespaces. The prefix may only contain lowercase
+// letters, digits, "_" and "-", so one namespace can't be the start of another,
+// otherwise New returns ErrInvalidNamespace.
+//
+// Migrations are still added and looked up by their names without the prefix.
+// The migration table is shared, so it is only created once, and the lock is
+// shared as well. CleanMigrationTable only deletes the records in the
+// namespace. The table checksum and ArchiveOldRecords cover the whole table, so
+// they return ErrNamespaced.
+func WithNamespacePrefix(prefix string) Option {
+	return func(m *Migrator) {
+		m.namespace = prefix
+	}
+}
+
+// The separator between the namespace and the migration name of a stored name.
+const namespaceSeparator = ":"
+
+// validNamespace returns true if namespace can be used as a prefix.
+func validNamespace(namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	for _, r := range namespace {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// namespaceBackend is a Backend that adds the namespace prefix to the names of
+// the migration records it writes, and only returns the records with the prefix
+// without it. The records are filtered by the database if the backend is a
+// backends.Namespacer. The other methods are forwarded like hookBackend.
+type namespaceBackend struct {
+	*hookBackend
+	prefix string
+}
+
+// newNamespaceBackend wraps next in the namespace.
+func newNamespaceBackend(next backends.Backend, namespace string) *namespaceBackend {
+	return &namespaceBackend{
+		hookBackend: &hookBackend{next: next, after: func(string, time.Duration, error) {}},
+		prefix:      namespace + namespaceSeparator,
+	}
+}
+
+// name returns the stored name of a migration.
+func (n *namespaceBackend) name(name string) string {
+	return n.prefix + name
+}
+
+// trim returns the migration name of a stored name, and false if it is not in
+// the namespace.
+func (n *namespaceBackend) trim(name string) (string, bool) {
+	if !strings.HasPrefix(name, n.prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, n.prefix), true
+}
+
+// filter returns the records in the namespace with the prefix removed.
+func (n *namespaceBackend) filter(prev map[string]string) map[string]string {
+	scoped := make(map[string]string)
+	for name, hash := range prev {
+		if trimmed, ok := n.trim(name); ok {
+			scoped[trimmed] = hash
+		}
+	}
+	return scoped
+}
+
+func (n *namespaceBackend) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return n.hookBackend.InsertRecord(tx, n.name(name), hash, comment, executionMs)
+}
+
+func (n *namespaceBackend) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return n.hookBackend.InsertRecordContext(ctx, tx, n.name(name), hash, comment, executionMs)
+}
+
+func (n *namespaceBackend) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...backends.ExtraField) error {
+	return n.hookBackend.InsertRecordWithApplier(ctx, tx, n.name(name), hash, comment, executionMs, appliedBy, extra...)
+}
+
+func (n *namespaceBackend) QueryPrevious() (map[string]string, error) {
+	return n.QueryPreviousContext(context.Background())
+}
+
+func (n *namespaceBackend) QueryPreviousContext(ctx context.Context) (map[string]string, error) {
+	var prev map[string]string
+	var err error
+	if ns, ok := n.next.(backends.Namespacer); ok {
+		prev, err = ns.QueryPreviousPrefix(ctx, n.prefix)
+	} else {
+		prev, err = n.hookBackend.QueryPreviousContext(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return n.filter(prev), nil
+}
+
+func (n *namespaceBackend) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
+	scoped := make(map[string]string, len(hashes))
+	for name, hash := range hashes {
+		scoped[n.name(name)] = hash
+	}
+	return n.hookBackend.RepairHashes(tx, scoped)
+}
+
+func (n *namespaceBackend) QueryRecords(q sqlx.Queryer) ([]backends.MigrationRecord, error) {
+	var records []backends.MigrationRecord
+	var err error
+	if ns, ok := n.next.(backends.Namespacer); ok {
+		records, err = ns.QueryRecordsPrefix(q, n.prefix)
+	} else {
+		records, err = n.hookBackend.QueryRecords(q)
+	}
+	if err != nil {
+		return nil, err
+	}
+	scoped := make([]backends.MigrationRecord, 0, len(records))
+	for _, r := range records {
+		if name, ok := n.trim(r.Name); ok {
+			r.Name = name
+			scoped = append(scoped, r)
+		}
+	}
+	return scoped, nil
+}
+
+func (n *namespaceBackend) CountRecords(ctx context.Context) (int, error) {
+	if ns, ok := n.next.(backends.Namespacer); ok {
+		return ns.CountRecordsPrefix(ctx, n.prefix)
+	}
+	prev, err := n.QueryPreviousContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(prev), nil
+}
+
+func (n *namespaceBackend) DeleteRecord(tx *sqlx.Tx, name string) error {
+	return n.hookBackend.DeleteRecord(tx, n.name(name))
+}
+
+func (n *namespaceBackend) UpdateComment(tx *sqlx.Tx, name string, comment string) error {
+	return n.hookBackend.UpdateComment(tx, n.name(name), comment)
+}
+
+func (n *namespaceBackend) ClaimRecord(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, appliedBy string, extra ...backends.ExtraField) (bool, error) {
+	return n.hookBackend.ClaimRecord(ctx, tx, n.name(name), hash, comment, appliedBy, extra...)
+}
+
+func (n *namespaceBackend) CompleteRecord(ctx context.Context, tx *sqlx.Tx, name string, executionMs int64) error {
+	return n.hookBackend.CompleteRecord(ctx, tx, n.name(name), executionMs)
+}
@@ -0,0 +1,179 @@
+package sqlxm
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// The default name of the migration table.
+	DefaultTableName = "migrations"
+	// The default time to wait for the migration lock.
+	DefaultLockTimeout = time.Minute
+)
+
+// An Option configures a Migrator when it is created with New.
+type Option func(*Migrator)
+
+// WithTableName sets the name of the migration table. The default is
+// "migrations". If the name is qualified with a schema, e.g.
+// "migrations.schema_changes", the table schema is set as well.
+func WithTableName(name string) Option {
+	return func(m *Migrator) {
+		schema, table := ParseTableName(name)
+		m.TableName = table
+		if schema != "" {
+			m.tableSchema = schema
+		}
+	}
+}
+
+// WithTableSchema sets the SQL 'table_schema' of the migration table. In
+// Postgres this is typically 'public' and in MySQL this is the name of the DB.
+// When no schema is set the backend default is used.
+func WithTableSchema(schema string) Option {
+	return func(m *Migrator) {
+		m.tableSchema = schema
+	}
+}
+
+// WithHashFunc sets the HashFunc used to create the checksum for each
+// migration. The default is SHA256Hash.
+func WithHashFunc(fn HashFunc) Option {
+	return func(m *Migrator) {
+		m.hashFunc = fn
+	}
+}
+
+// WithHashNormalization normalizes migration statements with
+// NormalizeStatement before they are hashed, so SQL formatters don't cause hash
+// mismatches. Comments are removed, whitespace is collapsed and trimmed, and
+// everything outside of quoted strings and identifiers is lowercased. Only the
+// hash is affected, the statement is run as it is.
+//
+// Changing this on an existing database changes the hashes of past migrations,
+// see RepairHash.
+func WithHashNormalization() Option {
+	return func(m *Migrator) {
+		m.normalizeHash = true
+	}
+}
+
+// WithApplier sets who applied the migrations, which is stored in the
+// applied_by column of each migration record. For example the name of a service
+// instance. The default is the host name.
+func WithApplier(s string) Option {
+	return func(m *Migrator) {
+		m.appliedBy = s
+	}
+}
+
+// WithLockTimeout sets how long to wait for the migration lock before returning
+// ErrLockTimeout. The default is DefaultLockTimeout.
+func WithLockTimeout(d time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockTimeout = d
+	}
+}
+
+// WithMaxPendingMigrations stops a run from applying more than n migrations.
+// If more than n migrations are pending the run returns ErrTooManyPending
+// before any of them are applied, so a large batch, e.g. from a bad merge,
+// needs the limit raised before it can be run. The default is no limit.
+func WithMaxPendingMigrations(n int) Option {
+	return func(m *Migrator) {
+		m.maxPending = n
+	}
+}
+
+// WithNonTransactional runs every migration outside of a transaction, like
+// migrations added with AddMigrationNoTx, for databases or statements that
+// can't run DDL in a transaction, e.g. CREATE INDEX CONCURRENTLY and ALTER TYPE
+// in Postgres. Each statement is run directly on the DB, and its record is
+// inserted in its own transaction after it has run.
+//
+// This is unsafe: a migration that fails part of the way through is not rolled
+// back, and the migrations before it stay applied. A warning is logged at the
+// start of every run.
+func WithNonTransactional() Option {
+	return func(m *Migrator) {
+		m.nonTransactional = true
+	}
+}
+
+// WithRollbackOnPanic rolls back the migration transaction if a run panics,
+// e.g. in a hook or a StatementPreprocessor, and then panics again with the
+// same value. Without it the transaction is committed by the deferred commit,
+// since a panic is not an error, so the migrations that ran before the panic
+// are applied.
+func WithRollbackOnPanic() Option {
+	return func(m *Migrator) {
+		m.rollbackOnPanic = true
+	}
+}
+
+// WithSafeMode stops the Migrator from running methods that bypass the integrity
+// checks of past migrations, so ForceRun returns ErrSafeMode. It does not change
+// Run; use RunStrict to validate the hashes of past migrations.
+func WithSafeMode() Option {
+	return func(m *Migrator) {
+		m.safeMode = true
+	}
+}
+
+// WithQueryTimeout limits how long the statement of each migration can run, so
+// a slow migration doesn't hold a connection and its locks indefinitely. The
+// migration fails with ErrQueryTimeout when the timeout is hit.
+//
+// In Postgres the statement_timeout of the migration transaction is set, and in
+// MySQL a MAX_EXECUTION_TIME hint is added to the statement. In every backend
+// the statement is also cancelled with the context, which interrupts it in
+// SQLite. MySQL only applies the hint to SELECT statements, so DDL and other
+// statements are only stopped by the context, see MySQL.TimeoutStatement. The
+// default is no timeout.
+func WithQueryTimeout(d time.Duration) Option {
+	return func(m *Migrator) {
+		m.queryTimeout = d
+	}
+}
+
+// WithSQLiteWALMode sets the journal mode of a SQLite database to WAL when the
+// backend is set up, so readers are not blocked while migrations are run. The
+// journal mode is stored in the database file, so it stays set after the
+// migrations are done. It has no effect with other backends.
+func WithSQLiteWALMode() Option {
+	return func(m *Migrator) {
+		m.pragmas = append(m.pragmas, "journal_mode = WAL")
+	}
+}
+
+// WithSQLiteForeignKeys turns foreign key enforcement on or off in a SQLite
+// database when the backend is set up. SQLite doesn't enforce foreign keys by
+// default. It has no effect with other backends.
+//
+// The foreign_keys pragma only applies to the connection it is issued on, and
+// the DB can open more connections, so set it in the DSN of the driver as well
+// to apply it to every connection.
+func WithSQLiteForeignKeys(enabled bool) Option {
+	return func(m *Migrator) {
+		p := "foreign_keys = OFF"
+		if enabled {
+			p = "foreign_keys = ON"
+		}
+		m.pragmas = append(m.pragmas, p)
+	}
+}
+
+// WithStatementPreprocessor sets fn to rewrite the statement of each migration
+// just before it is run, for example to add a schema prefix, expand macros or
+// use tenant specific table names. If fn returns an error the migration fails
+// with it.
+//
+// The hash is of the statement as it was added, so a rewrite that is different
+// for each database does not cause hash mismatches. When a rewritten statement
+// fails it is included in the details of the migration log.
+func WithStatementPreprocessor(fn func(ctx context.Context, name string, statement string) (string, error)) Option {
+	return func(m *Migrator) {
+		m.preprocess = fn
+	}
+}
@@ -0,0 +1,238 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A MigrationPlan describes what Run would do, for example to render it in a UI
+// or compare it across environments.
+type MigrationPlan struct {
+	// The migrations that have not been run, in the order they would be run.
+	Pending []PendingMigration `json:"pending"`
+	// The migrations that have not been run and would be skipped, because of
+	// SkipMigrations or a condition that is not met.
+	Skipped []SkippedMigration `json:"skipped"`
+	// The migrations that have been run and changed since.
+	HashMismatches []HashMismatch `json:"hash_mismatches"`
+	// The names of the migration records that don't match an added migration.
+	OrphanRecords []string `json:"orphan_records"`
+	// The number of migration records.
+	TotalApplied int `json:"total_applied"`
+}
+
+// A PendingMigration is a migration that has not been run. Index is its
+// position in the order the migrations were added.
+type PendingMigration struct {
+	Name    string `json:"name"`
+	Hash    string `json:"hash"`
+	Comment string `json:"comment"`
+	Index   int    `json:"index"`
+}
+
+// A SkippedMigration is a migration that has not been run and would be skipped
+// by Run. Reason is the details of its log entry, e.g. "migration condition not
+// met".
+type SkippedMigration struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	Index  int    `json:"index"`
+}
+
+// A HashMismatch is a migration whose hash does not match the hash stored when
+// it was run.
+type HashMismatch struct {
+	Name         string `json:"name"`
+	StoredHash   string `json:"stored_hash"`
+	ComputedHash string `json:"computed_hash"`
+}
+
+// Plan returns what Run would do without running any migrations.
+//
+// Plan does the same preflight work as Run. It creates the migration table if
+// it does not exist, gets the previous migrations, and validates their hashes.
+// The plan has each migration that has not been run, each hash mismatch, and
+// the records that don't match an added migration. Migrations that would be
+// skipped are reported separately from the pending ones, so the condition of
+// each conditional migration that has not been run is called. An error is returned if
+// there are any hash mismatches, just like RunStrict, along with the
+// plan.
+//
+// This is useful in CI to stop a deployment when there are pending migrations
+// or when past migrations have been changed.
+func (m *Migrator) Plan() (MigrationPlan, error) {
+	ctx := context.Background()
+	plan := MigrationPlan{
+		Pending:        make([]PendingMigration, 0),
+		Skipped:        make([]SkippedMigration, 0),
+		HashMismatches: make([]HashMismatch, 0),
+		OrphanRecords:  make([]string, 0),
+	}
+
+	err := m.loadSources()
+	if err != nil {
+		return plan, err
+	}
+	err = m.ensureMigrationTable(ctx)
+	if err != nil {
+		return plan, err
+	}
+
+	prev, err := m.backend.QueryPreviousContext(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("get previous migrations failed: %w", err)
+	}
+	m.previous = prev
+	plan.TotalApplied = len(prev)
+
+	for i, mig := range m.migrations {
+		if _, exists := m.previous[mig.Name]; !exists {
+			if _, skip := m.skip[mig.Name]; skip {
+				plan.Skipped = append(plan.Skipped, SkippedMigration{Name: mig.Name, Reason: "migration skipped", Index: i})
+				continue
+			}
+			if mig.condition != nil && !mig.condition() {
+				plan.Skipped = append(plan.Skipped, SkippedMigration{Name: mig.Name, Reason: "migration condition not met", Index: i})
+				continue
+			}
+			plan.Pending = append(plan.Pending, PendingMigration{
+				Name:    mig.Name,
+				Hash:    mig.hash,
+				Comment: mig.Comment,
+				Index:   i,
+			})
+			continue
+		}
+		h, valid := m.hashIsValid(mig)
+		if !valid {
+			plan.HashMismatches = append(plan.HashMismatches, HashMismatch{
+				Name:         mig.Name,
+				StoredHash:   h,
+				ComputedHash: mig.hash,
+			})
+		}
+	}
+	for name := range m.previous {
+		if _, added := m.names[name]; !added {
+			plan.OrphanRecords = append(plan.OrphanRecords, name)
+		}
+	}
+	sort.Strings(plan.OrphanRecords)
+
+	if len(plan.HashMismatches) > 0 {
+		return plan, fmt.Errorf("%d migration hash mismatches found: %w", len(plan.HashMismatches), ErrHashMismatch)
+	}
+	return plan, nil
+}
+
+// Validate checks that the migrations that have already been run have not been
+// changed, without running anything. A log entry with the ERROR_HASH status is
+// returned for each migration whose hash does not match the stored hash, and
+// the returned error wraps ErrHashMismatch if there are any.
+//
+// Validate only reads from the database. It does not open a transaction or
+// create the migration table, so it is safe to call from a read-only replica or
+// a health check. If the migration table does not exist
+// ErrMigrationTableNotFound is returned.
+func (m *Migrator) Validate() ([]MigrationLog, error) {
+	ctx := context.Background()
+	mismatches := make([]MigrationLog, 0)
+
+	err := m.loadSources()
+	if err != nil {
+		return mismatches, err
+	}
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil {
+		return mismatches, errTableSetup("the migration table check failed", err)
+	}
+	if !exists {
+		return mismatches, fmt.Errorf("'%s': %w", m.TableName, ErrMigrationTableNotFound)
+	}
+
+	prev, err := m.backend.QueryPreviousContext(ctx)
+	if err != nil {
+		return mismatches, fmt.Errorf("get previous migrations failed: %w", err)
+	}
+	m.previous = prev
+
+	for _, mig := range m.migrations {
+		if _, exists := m.previous[mig.Name]; !exists {
+			continue
+		}
+		h, valid := m.hashIsValid(mig)
+		if valid {
+			continue
+		}
+		mismatches = append(mismatches, MigrationLog{
+			Name:    mig.Name,
+			Hash:    mig.hash,
+			Status:  ERROR_HASH,
+			Details: fmt.Sprintf("hash mismatch DB: '%s' Migration: '%s'", h, mig.hash),
+		})
+	}
+
+	if len(mismatches) > 0 {
+		return mismatches, fmt.Errorf("%d migration hash mismatches found: %w", len(mismatches), ErrHashMismatch)
+	}
+	return mismatches, nil
+}
+
+// ExportPending writes the SQL of each migration that has not been run to w, so
+// it can be reviewed before it is applied, or run by hand. Each statement is
+// preceded by a comment header with the migration name and ends with a
+// semicolon.
+//
+//    -- Migration: create_users_table
+//    -- Create the users table
+//    CREATE TABLE users (id INT);
+//
+// Nothing is written to the DB. If the migration table does not exist every
+// migration is pending. The args of a migration are written in the header,
+// since they are not part of the statement.
+func (m *Migrator) ExportPending(w io.Writer) error {
+	ctx := context.Background()
+	prev := make(map[string]string)
+
+	err := m.loadSources()
+	if err != nil {
+		return err
+	}
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil {
+		return errTableSetup("the migration table check failed", err)
+	}
+	if exists {
+		prev, err = m.backend.QueryPreviousContext(ctx)
+		if err != nil {
+			return fmt.Errorf("get previous migrations failed: %w", err)
+		}
+	}
+
+	for _, mig := range m.migrations {
+		if _, applied := prev[mig.Name]; applied {
+			continue
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "-- Migration: %s\n", mig.Name)
+		for _, line := range strings.Split(mig.Comment, "\n") {
+			if line != "" {
+				fmt.Fprintf(&b, "-- %s\n", line)
+			}
+		}
+		if len(mig.args) > 0 {
+			fmt.Fprintf(&b, "-- Args: %v\n", mig.args)
+		}
+		statement := strings.TrimRight(strings.TrimSpace(mig.Statement), ";")
+		fmt.Fprintf(&b, "%s;\n\n", statement)
+
+		_, err = io.WriteString(w, b.String())
+		if err != nil {
+			return fmt.Errorf("write migration '%s' failed: %w", mig.Name, err)
+		}
+	}
+	return nil
+}
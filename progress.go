@@ -0,0 +1,64 @@
+package sqlxm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The number of characters inside the progress bar brackets.
+const progressBarWidth = 20
+
+// progressBar renders the migration progress to a writer.
+type progressBar struct {
+	w io.Writer
+	// fallback writes a line per migration instead of redrawing the bar with a
+	// carriage return.
+	fallback bool
+}
+
+// update renders the progress for the current migration. current starts at 1.
+func (p progressBar) update(current int, total int, name string) {
+	if p.fallback {
+		fmt.Fprintf(p.w, "Running migration %d/%d: %s...\n", current, total, name)
+		return
+	}
+	fill := progressBarWidth
+	if total > 0 {
+		fill = progressBarWidth * current / total
+	}
+	bar := strings.Repeat("=", fill) + strings.Repeat(" ", progressBarWidth-fill)
+	if fill > 0 && fill < progressBarWidth {
+		bar = bar[:fill-1] + ">" + bar[fill:]
+	}
+	// Clear the rest of the line since names have different lengths.
+	fmt.Fprintf(p.w, "\r\033[K[%s] %d/%d migrations %s", bar, current, total, name)
+}
+
+// done ends the bar so following output starts on a new line.
+func (p progressBar) done() {
+	if !p.fallback {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// WithProgressBarWriter renders an ASCII progress bar to w while migrations are
+// run. The bar is redrawn in place using a carriage return and ANSI escape
+// codes.
+//
+//    [=========>          ] 5/10 migrations create_users_table
+func (m *Migrator) WithProgressBarWriter(w io.Writer) {
+	m.progress = &progressBar{w: w, fallback: m.progressFallback}
+}
+
+// WithProgressBarFallback sets whether the progress output should fall back to
+// one line per migration for writers that don't support ANSI escape codes, like
+// CI logs.
+//
+//    Running migration 5/10: create_users_table...
+func (m *Migrator) WithProgressBarFallback(fallback bool) {
+	m.progressFallback = fallback
+	if m.progress != nil {
+		m.progress.fallback = fallback
+	}
+}
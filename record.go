@@ -0,0 +1,69 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/danielmorell/sqlxm/backends"
+)
+
+// A RecordColumn is an extra column of the migration table, for example to store
+// the git SHA or deployment of each migration.
+type RecordColumn = backends.RecordColumn
+
+// An ExtraField is the value of a RecordColumn to store in each migration record.
+type ExtraField = backends.ExtraField
+
+// ExtendMigrationRecord adds extra columns to the migration table when it is
+// created. Use WithRecordFields to set the values stored in them.
+//
+//    m, err := sqlxm.New(db, "", "",
+//        sqlxm.ExtendMigrationRecord(sqlxm.RecordColumn{Name: "git_sha", Type: "VARCHAR(40)", Default: "''"}),
+//        sqlxm.WithRecordFields(sqlxm.ExtraField{Name: "git_sha", Value: gitSHA}),
+//    )
+//
+// The columns are not added to a migration table that already exists, call
+// AlterMigrationTable to add them.
+func ExtendMigrationRecord(columns ...RecordColumn) Option {
+	return func(m *Migrator) {
+		m.recordColumns = append(m.recordColumns, columns...)
+		if m.backend != nil {
+			m.backend.ExtendColumns(m.recordColumns)
+		}
+	}
+}
+
+// WithRecordFields sets the values of extra columns added with
+// ExtendMigrationRecord that are stored in each migration record. Columns
+// without a value get their default.
+func WithRecordFields(fields ...ExtraField) Option {
+	return func(m *Migrator) {
+		m.recordFields = append(m.recordFields, fields...)
+	}
+}
+
+// AlterMigrationTable adds the extra columns from ExtendMigrationRecord to an
+// existing migration table. Columns the table already has are skipped, so it is
+// safe to call on every start up. Nothing is done if the migration table does
+// not exist, since it is created with the extra columns.
+//
+// Columns that are NOT NULL need a Default to be added to a table that has
+// records.
+func (m *Migrator) AlterMigrationTable() error {
+	ctx := context.Background()
+	if len(m.recordColumns) == 0 {
+		return nil
+	}
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil || !exists {
+		return err
+	}
+	q, err := m.backend.AddColumns(ctx, m.recordColumns)
+	if err != nil {
+		return fmt.Errorf("alter migration table failed: %w", err)
+	}
+	if q != "" {
+		m.logger.Info("altered migration table", "query", q)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+)
+
+// A HashRepairResult is a migration hash updated by RepairAllMismatches.
+type HashRepairResult struct {
+	Name    string
+	OldHash string
+	NewHash string
+}
+
+// RepairAllMismatches updates the stored hash of every applied migration that
+// does not match the hash of the added migration, without having to list them
+// like RepairHash. All the hashes are updated in a single transaction, so
+// RunStrict succeeds afterwards. A result is returned for each repaired hash.
+//
+// Records for migrations that have not been added are not changed.
+func (m *Migrator) RepairAllMismatches() ([]HashRepairResult, error) {
+	ctx := context.Background()
+	results := make([]HashRepairResult, 0)
+
+	err := m.backend.Lock(ctx, m.lockTimeout)
+	if err != nil {
+		return results, fmt.Errorf("acquire migration lock failed: %w", err)
+	}
+	defer m.backend.Unlock(context.Background())
+
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil {
+		return results, errTableSetup("the migration table check failed", err)
+	}
+	if !exists {
+		return results, nil
+	}
+
+	prev, err := m.backend.QueryPreviousContext(ctx)
+	if err != nil {
+		return results, fmt.Errorf("get previous migrations failed: %w", err)
+	}
+	hashes := make(map[string]string)
+	for _, mig := range m.migrations {
+		stored, applied := prev[mig.Name]
+		if !applied || stored == mig.hash {
+			continue
+		}
+		hashes[mig.Name] = mig.hash
+		results = append(results, HashRepairResult{
+			Name:    mig.Name,
+			OldHash: stored,
+			NewHash: mig.hash,
+		})
+	}
+	if len(hashes) == 0 {
+		return results, nil
+	}
+
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failed: %w", err)
+	}
+	err = m.backend.RepairHashes(tx, hashes)
+	if err != nil {
+		m.rollbackTx(tx)
+		return nil, fmt.Errorf("repair hashes failed: %w", err)
+	}
+	if m.tableChecksum {
+		err = m.storeTableChecksum(tx)
+		if err != nil {
+			m.rollbackTx(tx)
+			return nil, fmt.Errorf("store migration table checksum failed: %w", err)
+		}
+	}
+	err = m.commitTx(tx)
+	if err != nil {
+		return nil, fmt.Errorf("commit transaction failed: %w", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,218 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Undo the migration on the database
+func (m Migration) rollback(ctx context.Context, tx *sqlx.Tx) error {
+	_, err := tx.ExecContext(ctx, m.RollbackStatement)
+	return err
+}
+
+// AddMigrationWithRollback adds a new Migration like AddMigration, with a
+// rollbackStatement that undoes the changes made by the statement. Only
+// migrations with a rollback statement can be rolled back with RollbackLast,
+// RollbackTo and Rollback.
+func (m *Migrator) AddMigrationWithRollback(name string, comment string, statement string, rollbackStatement string, args ...interface{}) error {
+	rollbackStatement, err := m.renderStatement(name, rollbackStatement)
+	if err != nil {
+		return err
+	}
+	err = m.AddMigration(name, comment, statement, args...)
+	if err != nil {
+		return err
+	}
+	m.migrations[len(m.migrations)-1].RollbackStatement = rollbackStatement
+	return nil
+}
+
+// RollbackLast rolls back the last n applied migrations, starting with the most
+// recent one. The rollback statement of each migration is run and its record is
+// deleted from the migration table.
+//
+// Like Run, all the rollbacks are run in a single transaction. The hash of each
+// migration is always validated like RunStrict, since the rollback statement of
+// a migration that has changed since it was applied could undo something else.
+// An error is returned without rolling anything back if one of the migrations
+// is not registered or has no rollback statement.
+func (m *Migrator) RollbackLast(n int) ([]MigrationLog, error) {
+	if n <= 0 {
+		return m.log, fmt.Errorf("rollback count must be greater than 0, got %d", n)
+	}
+	names, err := m.appliedNames()
+	if err != nil {
+		return m.log, err
+	}
+	if n > len(names) {
+		n = len(names)
+	}
+
+	err = m.rollback(context.Background(), names[len(names)-n:])
+	return m.log, err
+}
+
+// RollbackTo rolls back every migration applied after the named migration and
+// the named migration itself. It works the same way as RollbackLast.
+func (m *Migrator) RollbackTo(name string) ([]MigrationLog, error) {
+	names, err := m.appliedNames()
+	if err != nil {
+		return m.log, err
+	}
+	for i, n := range names {
+		if n == name {
+			err = m.rollback(context.Background(), names[i:])
+			return m.log, err
+		}
+	}
+	return m.log, fmt.Errorf("migration '%s' has not been applied", name)
+}
+
+// Rollback rolls back the named migrations in the order given, without rolling
+// back the migrations applied after them. It works the same way as
+// RollbackLast, and an error is returned without rolling anything back if one
+// of the migrations has not been applied. Use CanRollback to check which
+// migrations have no rollback statement.
+//
+// Rolling back a migration that later migrations depend on, e.g. one that
+// creates a table a later migration alters, is likely to fail.
+func (m *Migrator) Rollback(names ...string) ([]MigrationLog, error) {
+	if len(names) == 0 {
+		return m.log, fmt.Errorf("no migrations to roll back")
+	}
+	err := m.rollbackInOrder(context.Background(), names)
+	return m.log, err
+}
+
+// CanRollback returns true if all the named migrations have a rollback
+// statement. Otherwise, it returns false and the names of the migrations
+// without one, including migrations that have not been added.
+func (m *Migrator) CanRollback(names ...string) (bool, []string) {
+	missing := make([]string, 0)
+	for _, name := range names {
+		i, ok := m.findMigration(name)
+		if !ok || m.migrations[i].RollbackStatement == "" {
+			missing = append(missing, name)
+		}
+	}
+	return len(missing) == 0, missing
+}
+
+// appliedNames returns the names of the applied migrations in the order they
+// were applied.
+func (m *Migrator) appliedNames() ([]string, error) {
+	records, err := m.backend.QueryRecords(m.db)
+	if err != nil {
+		return nil, fmt.Errorf("get applied migrations failed: %w", err)
+	}
+	names := make([]string, len(records))
+	for i, r := range records {
+		names[i] = r.Name
+	}
+	return names, nil
+}
+
+// rollback undoes the applied migrations in reverse order of names.
+func (m *Migrator) rollback(ctx context.Context, names []string) error {
+	reversed := make([]string, len(names))
+	for i, name := range names {
+		reversed[len(names)-1-i] = name
+	}
+	return m.rollbackInOrder(ctx, reversed)
+}
+
+// rollbackInOrder undoes the applied migrations in the order of names.
+func (m *Migrator) rollbackInOrder(ctx context.Context, names []string) (err error) {
+	prev, err := m.backend.QueryPreviousContext(ctx)
+	if err != nil {
+		return fmt.Errorf("get previous migrations failed: %w", err)
+	}
+	m.previous = prev
+
+	// Make sure everything can be rolled back before changing anything.
+	migs := make([]Migration, 0, len(names))
+	for _, name := range names {
+		idx, ok := m.findMigration(name)
+		if !ok {
+			return fmt.Errorf("migration '%s' is not registered", name)
+		}
+		mig := m.migrations[idx]
+		if mig.RollbackStatement == "" {
+			return fmt.Errorf("migration '%s' has no rollback statement", mig.Name)
+		}
+		if _, applied := m.previous[name]; !applied {
+			return fmt.Errorf("migration '%s' has not been applied", name)
+		}
+		migs = append(migs, mig)
+	}
+
+	tx, err := m.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	commit := true
+	defer func() {
+		if m.rollbackOnPanic {
+			if r := recover(); r != nil {
+				m.rollbackTx(tx)
+				panic(r)
+			}
+		}
+		if commit {
+			cerr := m.commitTx(tx)
+			if cerr != nil {
+				err = fmt.Errorf("commit transaction failed: %w", cerr)
+			}
+			return
+		}
+		m.rollbackTx(tx)
+	}()
+
+	for _, mig := range migs {
+		err = m.rollbackMigration(ctx, tx, mig)
+		if err != nil {
+			commit = false
+			return fmt.Errorf("rollback error on '%s': %w", mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// Rolls back a single migration. A hash mismatch is always an error.
+func (m *Migrator) rollbackMigration(ctx context.Context, tx *sqlx.Tx, mig Migration) error {
+	mLog := MigrationLog{
+		Name:    mig.Name,
+		Hash:    mig.hash,
+		Status:  ROLLBACK,
+		Details: "rolled back migration successfully",
+	}
+	defer func() {
+		m.log = append(m.log, mLog)
+	}()
+
+	h, valid := m.hashIsValid(mig)
+	if !valid {
+		d := fmt.Sprintf("hash mismatch DB: '%s' Migration: '%s'", h, mig.hash)
+		mLog.Details = d
+		mLog.Status = ERROR_HASH
+		return fmt.Errorf("%s %s: %w", mig.Name, d, ErrHashMismatch)
+	}
+
+	err := mig.rollback(ctx, tx)
+	if err != nil {
+		mLog.Status = ERROR
+		mLog.Details = fmt.Sprintf("rollback failed: %s", err)
+		return err
+	}
+
+	err = m.backend.DeleteRecord(tx, mig.Name)
+	if err != nil {
+		mLog.Status = ERROR
+		mLog.Details = fmt.Sprintf("record delete failed: %s", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/danielmorell/sqlxm/backends"
+	"github.com/jmoiron/sqlx"
+)
+
+// SavepointPerMigration runs each migration in a savepoint of the migration
+// transaction. If a migration fails only its changes are rolled back, it is
+// logged with the ERROR status, and the next migrations are still run. The
+// transaction is committed with the migrations that succeeded, and the run
+// returns an ErrMigrationsFailed error with the names of the ones that failed.
+//
+// Since failed migrations don't stop the run this is not allowed in safe mode,
+// so it must be used with Run, and RunStrict returns an ErrSafeMode error.
+// Migrations added with AddMigrationNoTx are not run in a savepoint, and still
+// stop the run if they fail.
+func SavepointPerMigration() Option {
+	return func(m *Migrator) {
+		m.savepoints = true
+	}
+}
+
+// savepoint creates the savepoint with name in tx with the backend's savepoint
+// statement if it is a backends.Savepointer.
+func (m *Migrator) savepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	if s, ok := m.backend.(backends.Savepointer); ok {
+		return s.Savepoint(ctx, tx, name)
+	}
+	return backends.Savepoint(ctx, tx, name)
+}
+
+// rollbackToSavepoint undoes the changes made in tx since the savepoint.
+func (m *Migrator) rollbackToSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	if s, ok := m.backend.(backends.Savepointer); ok {
+		return s.RollbackToSavepoint(ctx, tx, name)
+	}
+	return backends.RollbackToSavepoint(ctx, tx, name)
+}
+
+// releaseSavepoint removes the savepoint from tx.
+func (m *Migrator) releaseSavepoint(ctx context.Context, tx *sqlx.Tx, name string) error {
+	if s, ok := m.backend.(backends.Savepointer); ok {
+		return s.ReleaseSavepoint(ctx, tx, name)
+	}
+	return backends.ReleaseSavepoint(ctx, tx, name)
+}
+
+// executeMigrationSavepoint runs mig like executeMigration in a savepoint of tx
+// named after its position i in the run. It returns failed as true if the
+// migration failed and was rolled back to the savepoint, and an error if the
+// savepoint statements fail.
+func (m *Migrator) executeMigrationSavepoint(ctx context.Context, tx *sqlx.Tx, mig Migration, i int) (failed bool, err error) {
+	name := fmt.Sprintf("sqlxm_migration_%d", i)
+	err = m.savepoint(ctx, tx, name)
+	if err != nil {
+		return false, fmt.Errorf("create savepoint failed: %w", err)
+	}
+	if m.executeMigration(ctx, tx, mig) != nil {
+		err = m.rollbackToSavepoint(ctx, tx, name)
+		if err != nil {
+			return true, fmt.Errorf("rollback to savepoint failed: %w", err)
+		}
+		return true, nil
+	}
+	err = m.releaseSavepoint(ctx, tx, name)
+	if err != nil {
+		return false, fmt.Errorf("release savepoint failed: %w", err)
+	}
+	return false, nil
+}
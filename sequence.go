@@ -0,0 +1,60 @@
+package sqlxm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WithSequentialNumbering requires every migration name to start with a
+// decimal number and an underscore, e.g. 0001_create_users. Before each run the
+// numbers are checked, and ErrSequenceDuplicate or ErrSequenceGap is returned
+// if two migrations have the same number or a number is missing. This catches
+// mistakes from merging branches before anything is run.
+func WithSequentialNumbering() Option {
+	return func(m *Migrator) {
+		m.sequential = true
+	}
+}
+
+// sequenceNumber returns the numeric prefix of a migration name.
+func sequenceNumber(name string) (int, bool) {
+	i := strings.Index(name, "_")
+	if i < 1 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[:i])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// checkSequence verifies the migration numbers are unique and have no gaps.
+func (m *Migrator) checkSequence() error {
+	numbered := make(map[int][]string, len(m.migrations))
+	for _, mig := range m.migrations {
+		n, ok := sequenceNumber(mig.Name)
+		if !ok {
+			return fmt.Errorf("migration '%s' has no sequence number: %w", mig.Name, ErrSequenceGap)
+		}
+		numbered[n] = append(numbered[n], mig.Name)
+	}
+
+	numbers := make([]int, 0, len(numbered))
+	for n, names := range numbered {
+		if len(names) > 1 {
+			return fmt.Errorf("migrations '%s' have the same number: %w", strings.Join(names, "', '"), ErrSequenceDuplicate)
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	for i := 1; i < len(numbers); i++ {
+		if numbers[i] != numbers[i-1]+1 {
+			before, after := numbered[numbers[i-1]][0], numbered[numbers[i]][0]
+			return fmt.Errorf("migrations between '%s' and '%s' are missing: %w", before, after, ErrSequenceGap)
+		}
+	}
+	return nil
+}
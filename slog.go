@@ -0,0 +1,35 @@
+//go:build go1.21
+// +build go1.21
+
+package sqlxm
+
+import (
+	"log/slog"
+)
+
+// SlogLogger adapts a log/slog Logger to a Logger.
+//
+//    m, err := sqlxm.New(db, "migrations", "public", sqlxm.WithLogger(sqlxm.SlogLogger(slog.Default())))
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Debug(msg string, fields ...interface{}) {
+	s.l.Debug(msg, fields...)
+}
+
+func (s slogLogger) Info(msg string, fields ...interface{}) {
+	s.l.Info(msg, fields...)
+}
+
+func (s slogLogger) Warn(msg string, fields ...interface{}) {
+	s.l.Warn(msg, fields...)
+}
+
+func (s slogLogger) Error(msg string, fields ...interface{}) {
+	s.l.Error(msg, fields...)
+}
@@ -0,0 +1,191 @@
+package sqlxm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+)
+
+// A MigrationSource loads migration definitions, e.g. from a directory of SQL
+// files, so where migrations come from is separate from how they are run.
+type MigrationSource interface {
+	Load() ([]MigrationDef, error)
+}
+
+// AddFromSource adds the migrations loaded from src like BatchAddMigration, so
+// either all of them are added or none are.
+//
+// When the LazyLoad option is set the migrations are not loaded until the
+// migrations are run or inspected, e.g. by Plan or Status, and src is loaded
+// again each time. Migrations that have been added are skipped, so only new
+// migrations are added.
+func (m *Migrator) AddFromSource(src MigrationSource) error {
+	if m.lazyLoad {
+		m.sources = append(m.sources, src)
+		return nil
+	}
+	defs, err := src.Load()
+	if err != nil {
+		return fmt.Errorf("load migrations failed: %w", err)
+	}
+	return m.BatchAddMigration(defs)
+}
+
+// LazyLoad makes AddFromSource load the migrations when they are run instead of
+// when the source is added. Each source is loaded again on every run, so new
+// migrations can be picked up without restarting, e.g. from a DirSource.
+func LazyLoad() Option {
+	return func(m *Migrator) {
+		m.lazyLoad = true
+	}
+}
+
+// loadSources adds the new migrations from each source when LazyLoad is set.
+// An ErrDuplicateMigration error is returned if a migration has changed since
+// it was added.
+func (m *Migrator) loadSources() error {
+	if !m.lazyLoad {
+		return nil
+	}
+	for _, src := range m.sources {
+		defs, err := src.Load()
+		if err != nil {
+			return fmt.Errorf("load migrations failed: %w", err)
+		}
+		added := make([]MigrationDef, 0, len(defs))
+		for _, def := range defs {
+			i, exists := m.findMigration(def.Name)
+			if !exists {
+				added = append(added, def)
+				continue
+			}
+			statement, err := m.renderStatement(def.Name, def.Statement)
+			if err != nil {
+				return err
+			}
+			if m.migrations[i].hash != m.hashQuery(statement, def.Args) {
+				return fmt.Errorf("migration '%s' changed since it was added: %w", def.Name, ErrDuplicateMigration)
+			}
+		}
+		err = m.BatchAddMigration(added)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DirSource returns a MigrationSource for the .sql files in the dir directory.
+// The files are loaded like FSSource.
+func DirSource(dir string) MigrationSource {
+	return FSSource(os.DirFS(dir), ".")
+}
+
+// FSSource returns a MigrationSource for the .sql files in dir of fsys. The
+// files are loaded in lexicographic order and named like AddMigrationsFromFS,
+// and can have up and down sections.
+func FSSource(fsys fs.FS, dir string) MigrationSource {
+	return fsSource{fsys: fsys, dir: dir}
+}
+
+type fsSource struct {
+	fsys fs.FS
+	dir  string
+}
+
+// Load implements MigrationSource. An ErrNoMigrationsFound error is returned if
+// the directory has no .sql files.
+func (s fsSource) Load() ([]MigrationDef, error) {
+	pattern := path.Join(s.dir, "*.sql")
+	paths, err := fs.Glob(s.fsys, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("glob '%s' failed: %w", pattern, err)
+	}
+	if len(paths) == 0 {
+		return nil, ErrNoMigrationsFound{Pattern: pattern}
+	}
+	sort.Strings(paths)
+
+	defs := make([]MigrationDef, 0, len(paths))
+	for _, p := range paths {
+		data, err := fs.ReadFile(s.fsys, p)
+		if err != nil {
+			return nil, fmt.Errorf("read migration file '%s' failed: %w", p, err)
+		}
+		defs = append(defs, fileMigrationDef(migrationName(path.Base(p)), string(data)))
+	}
+	return defs, nil
+}
+
+// YAMLSource returns a MigrationSource for migrations defined in YAML read from
+// r, in the format used by AddMigrationsFromYAML. r is read once, so later loads
+// return no migrations.
+func YAMLSource(r io.Reader) MigrationSource {
+	return yamlSource{r: r}
+}
+
+type yamlSource struct {
+	r io.Reader
+}
+
+// Load implements MigrationSource.
+func (s yamlSource) Load() ([]MigrationDef, error) {
+	defs := make([]MigrationDef, 0)
+	err := decodeYAMLMigrations(s.r, func(def MigrationDef, line int) error {
+		defs = append(defs, def)
+		return nil
+	})
+	return defs, err
+}
+
+// JSONSource returns a MigrationSource for a JSON array of migrations read from
+// r. The fields are the JSON names of the MigrationDef fields.
+//
+//    [{"name": "create_users_table", "statement": "CREATE TABLE users (id INT);"}]
+//
+// r is read once, so later loads return no migrations.
+func JSONSource(r io.Reader) MigrationSource {
+	return jsonSource{r: r}
+}
+
+type jsonSource struct {
+	r io.Reader
+}
+
+// Load implements MigrationSource. The name and statement fields are required.
+func (s jsonSource) Load() ([]MigrationDef, error) {
+	defs := make([]MigrationDef, 0)
+	err := json.NewDecoder(s.r).Decode(&defs)
+	if errors.Is(err, io.EOF) {
+		return defs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse migration JSON failed: %w", err)
+	}
+	for i, def := range defs {
+		if def.Name == "" || def.Statement == "" {
+			return nil, fmt.Errorf("migration JSON item %d: name and statement are required", i)
+		}
+	}
+	return defs, nil
+}
+
+// MemorySource returns a MigrationSource for defs, e.g. for migrations that are
+// generated by code.
+func MemorySource(defs []MigrationDef) MigrationSource {
+	return memorySource(defs)
+}
+
+type memorySource []MigrationDef
+
+// Load implements MigrationSource.
+func (s memorySource) Load() ([]MigrationDef, error) {
+	defs := make([]MigrationDef, len(s))
+	copy(defs, s)
+	return defs, nil
+}
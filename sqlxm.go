@@ -1,28 +1,77 @@
+// Package sqlxm runs SQL migrations with sqlx and records them in a migration
+// table, so each migration is applied once.
+//
+// Migrations are run in one of two modes. Run is in loose mode: the hash of a
+// migration that has already been run is compared with the stored hash, and a
+// mismatch is only noted in the migration log. RunStrict is in strict (safe)
+// mode and stops with ErrHashMismatch instead. The partial runs, MigrateTo,
+// MigrateBetween and RunN, are always in strict mode.
+//
+// Run was in strict mode in earlier versions, and RunUnsafe was the loose run.
+// Code that relied on Run stopping on a hash mismatch should call RunStrict.
 package sqlxm
 
 import (
-	"crypto/md5"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/danielmorell/sqlxm/backends"
 	"github.com/jmoiron/sqlx"
 )
 
+// LogStatus is the status of a MigrationLog.
+type LogStatus int
+
 const (
-	SUCCESS = iota
+	SUCCESS LogStatus = iota
 	PREVIOUS
 	ERROR
 	ERROR_HASH
+	ROLLBACK
+	PENDING
+	SKIPPED
 )
 
+var logStatusNames = []string{
+	SUCCESS:    "success",
+	PREVIOUS:   "previous",
+	ERROR:      "error",
+	ERROR_HASH: "error_hash",
+	ROLLBACK:   "rollback",
+	PENDING:    "pending",
+	SKIPPED:    "skipped",
+}
+
+// String returns the lowercase name of the status, e.g. "error_hash", for use in
+// structured logs.
+func (s LogStatus) String() string {
+	if s < 0 || int(s) >= len(logStatusNames) {
+		return fmt.Sprintf("LogStatus(%d)", int(s))
+	}
+	return logStatusNames[s]
+}
+
 var defaultBackends = map[string][]string{
-	"postgres":  {"postgres", "pgx", "pq-timeouts", "cloudsqlpostgres", "nrpostgres", "cockroach"},
-	"mysql":     {"mysql", "nrmysql"},
-	"sqlite":    {"sqlite", "sqlite3", "nrsqlite3"},
-	"oracle":    {"oci8", "ora", "goracle", "godror"},
-	"sqlserver": {"sqlserver"},
+	"postgres":    {"postgres", "pgx", "pq-timeouts", "cloudsqlpostgres", "nrpostgres"},
+	"cockroach":   {"cockroach", "cockroachdb"},
+	"duckdb":      {"duckdb"},
+	"mysql":       {"mysql", "nrmysql"},
+	"mariadb":     {"mariadb", "maria"},
+	"libsql":      {"libsql", "turso"},
+	"sqlite":      {"sqlite", "sqlite3", "nrsqlite3"},
+	"oracle":      {"oci8", "ora", "goracle", "godror"},
+	"planetscale": {"mysql+planetscale"},
+	"sqlserver":   {"sqlserver"},
+	"tidb":        {"tidb"},
+	"yugabyte":    {"yugabyte", "yugabytedb"},
 }
 
 var backendMap sync.Map
@@ -44,51 +93,157 @@ func BackendType(driverName string) string {
 	return itype.(string)
 }
 
-var registeredBackends = map[string]backends.Backend{
-	"mysql":    &backends.MySQL{},
-	"postgres": &backends.Postgres{},
-	"sqlite":   &backends.SQLite{},
+// A BackendFactory returns a new instance of a backend. UseBackend calls it, so
+// each Migrator has its own instance and the state of a run, like the
+// connection holding the migration lock, is not shared between Migrators.
+type BackendFactory func() backends.Backend
+
+// registeredBackendsMu guards registeredBackends.
+var registeredBackendsMu sync.RWMutex
+
+var registeredBackends = map[string]BackendFactory{
+	"cockroach":   func() backends.Backend { return &backends.Cockroach{} },
+	"duckdb":      func() backends.Backend { return &backends.DuckDB{} },
+	"libsql":      func() backends.Backend { return &backends.LibSQL{} },
+	"mariadb":     func() backends.Backend { return &backends.MariaDB{} },
+	"mysql":       func() backends.Backend { return &backends.MySQL{} },
+	"oracle":      func() backends.Backend { return &backends.Oracle{} },
+	"planetscale": func() backends.Backend { return &backends.PlanetScale{} },
+	"postgres":    func() backends.Backend { return &backends.Postgres{} },
+	"sqlite":      func() backends.Backend { return &backends.SQLite{} },
+	"sqlserver":   func() backends.Backend { return &backends.SQLServer{} },
+	"tidb":        func() backends.Backend { return &backends.TiDB{} },
+	"yugabyte":    func() backends.Backend { return &backends.Yugabyte{} },
 }
 
 // RegisterBackend adds a new DB Backend to sqlxm for Migrator to use to run
 // queries. A backend handles peculiarities in SQL dialects and can help
 // abstract alternate implementations.
+//
+// The backend is shared by every Migrator that uses it, so it is set up for the
+// DB of the last one. Use RegisterBackendFactory for a backend used by several
+// Migrators at once, or one that keeps the state of a run, like a lock.
 func RegisterBackend(key string, backend backends.Backend) error {
+	return RegisterBackendFactory(key, func() backends.Backend { return backend })
+}
+
+// RegisterBackendFactory registers a backend like RegisterBackend, but fn is
+// called to create a new instance of the backend for each Migrator that uses
+// it, like the built-in backends.
+//
+//    err := sqlxm.RegisterBackendFactory("cockroach_10", func() backends.Backend {
+//        return &backends.Cockroach{MaxRetries: 10}
+//    })
+func RegisterBackendFactory(key string, fn BackendFactory) error {
+	registeredBackendsMu.Lock()
+	defer registeredBackendsMu.Unlock()
 	_, exists := registeredBackends[key]
 	if exists {
 		return fmt.Errorf("backend with key '%s' already exists", key)
 	}
-	registeredBackends[key] = backend
+	registeredBackends[key] = fn
 	return nil
 }
 
+// OverrideBackend registers backend with the key like RegisterBackend, but
+// replaces the backend if the key already exists, for example to use a hardened
+// version of the built-in Postgres backend.
+//
+// Migrators that are already using the replaced backend keep using it until
+// UseBackend is called again. Like RegisterBackend, it is safe to call from
+// multiple goroutines, but overriding a backend while other goroutines create
+// Migrators makes it unpredictable which backend they get, so it should be done
+// during initialization.
+func OverrideBackend(key string, backend backends.Backend) {
+	OverrideBackendFactory(key, func() backends.Backend { return backend })
+}
+
+// OverrideBackendFactory is like OverrideBackend, but fn is called to create
+// a new instance of the backend for each Migrator, like
+// RegisterBackendFactory.
+func OverrideBackendFactory(key string, fn BackendFactory) {
+	registeredBackendsMu.Lock()
+	defer registeredBackendsMu.Unlock()
+	registeredBackends[key] = fn
+}
+
 // Migration is a single schema change to apply to the database.
 type Migration struct {
 	Name      string
 	Comment   string
 	hash      string
 	Statement string
-	args      []interface{}
-	migrated  bool
+	// RollbackStatement undoes the changes made by Statement.
+	RollbackStatement string
+	// Tags and Environment are metadata about the migration, for example from
+	// a YAML migration file. They do not change how the migration is run.
+	Tags        []string
+	Environment string
+	// NoTransaction runs the statement outside the migration transaction, for
+	// statements that cannot be run in one, e.g. CREATE INDEX CONCURRENTLY.
+	NoTransaction bool
+	args          []interface{}
+	migrated      bool
+	// condition is checked at run time, and the migration is skipped if it
+	// returns false.
+	condition func() bool
+}
+
+// Hash returns the checksum of the migration statement and args that is stored
+// in the migration table when the migration is run.
+func (m Migration) Hash() string {
+	return m.hash
+}
+
+// Args returns the args the migration statement is run with.
+func (m Migration) Args() []interface{} {
+	return m.args
 }
 
 // Execute the migration on the database
-func (m Migration) run(tx *sqlx.Tx) error {
-	_, err := tx.Exec(m.Statement, m.args...)
+func (m Migration) run(ctx context.Context, tx *sqlx.Tx, migrator *Migrator) error {
+	if r, ok := migrator.backend.(backends.StatementRunner); ok {
+		return r.RunStatement(ctx, tx, m.Statement, m.args...)
+	}
+	_, err := tx.ExecContext(ctx, m.Statement, m.args...)
 	return err
 }
 
 // Insert the migration record row into the migration table
-func (m Migration) insertRecord(tx *sqlx.Tx, migrator *Migrator) error {
-	return migrator.backend.InsertRecord(tx, m.Name, m.hash, m.Comment)
+func (m Migration) insertRecord(ctx context.Context, tx *sqlx.Tx, migrator *Migrator, executionMs int64) error {
+	return migrator.backend.InsertRecordWithApplier(ctx, tx, m.Name, m.hash, m.Comment, executionMs, migrator.appliedBy, migrator.recordFields...)
+}
+
+// Insert the migration record row in its own transaction, for a migration that
+// is not run in the migration transaction.
+func (m Migration) insertRecordNoTx(ctx context.Context, migrator *Migrator, executionMs int64) error {
+	tx, err := migrator.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction failed: %w", err)
+	}
+	err = m.insertRecord(ctx, tx, migrator, executionMs)
+	if err != nil {
+		migrator.rollbackTx(tx)
+		return err
+	}
+	return migrator.commitTx(tx)
 }
 
 // A MigrationLog represents the results from a single migration.
+//
+// MigrationLog is marshalled to JSON with the status as its name, e.g.
+// "success", the start and end times in RFC 3339 format, and the duration in
+// milliseconds.
 type MigrationLog struct {
-	Name    string
-	Hash    string
-	Status  int
-	Details string
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Status  LogStatus `json:"status"`
+	Details string    `json:"details"`
+	// When the migration started and ended, e.g. to find it in the slow query
+	// log, and how long it took.
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Duration  time.Duration `json:"duration_ms"`
 }
 
 // Migrator handles the process of migrating your database. Each instance of
@@ -108,30 +263,220 @@ type Migrator struct {
 	// safe mode stops migrations and returns an error if the hashes don't
 	// match for a migration.
 	safe bool
+	// Refuse to force run migrations.
+	safeMode bool
 	// The names of migrations that need the hash repaired.
 	repair map[string]string
 	// Set of added migrations
 	names map[string]struct{}
+	// Set of migrations to skip if they have not been run.
+	skip map[string]struct{}
 	// The query runner for the db.
 	backend backends.Backend
+	// The backend before the middleware is applied.
+	baseBackend backends.Backend
+	// Wraps the backend, the first is the outermost.
+	middleware []BackendMiddleware
+	// Prefix of the migration record names, if set.
+	namespace string
 	// The SQL 'table_schema' in Postgres this is typically 'public' in MySQL
 	// this is the name of the DB.
 	tableSchema string
+	// Custom function used to begin the migration transaction.
+	beginTx func(ctx context.Context) (*sqlx.Tx, error)
+	// Begins, commits and rolls back the migration transactions.
+	txManager TransactionManager
+	// Custom migration table column definitions keyed by column name.
+	columns map[string]string
+	// strictDuplicates makes AddMigrationIfNotExists return an error for every
+	// duplicate, even if the hashes match.
+	strictDuplicates bool
+	// Called with the migration transaction just before it is committed.
+	commitHook func(tx *sqlx.Tx, appliedNames []string) error
+	// Verify the migration table has not been changed outside of sqlxm.
+	tableChecksum bool
+	// Creates the checksum for each migration.
+	hashFunc HashFunc
+	// Normalize statements before they are hashed.
+	normalizeHash bool
+	// Rewrites each statement before it is run.
+	preprocess func(ctx context.Context, name string, statement string) (string, error)
+	// Require migration names to be sequentially numbered.
+	sequential bool
+	// How long to wait for the migration lock.
+	lockTimeout time.Duration
+	// How long each migration statement can run, or 0 for no limit.
+	queryTimeout time.Duration
+	// Run every migration outside of a transaction.
+	nonTransactional bool
+	// Roll back the migration transaction if a run panics.
+	rollbackOnPanic bool
+	// The most migrations a run can apply, or 0 for no limit.
+	maxPending int
+	// Check the pending migrations before each run.
+	lintRules []LintRule
+	// Issued by backends that are PragmaSetters when they are set up.
+	pragmas []string
+	// Receives the notices of backends that are NoticeCapturers, if set.
+	notices io.Writer
+	// Releases the connections of the transactions begun to capture notices.
+	noticeConns map[*sqlx.Tx]func()
+	// Receives metrics about each migration if set.
+	metrics MetricsCollector
+	// Traces each run and migration if set.
+	tracer Tracer
+	// Renders the migration progress if set.
+	progress *progressBar
+	// Use line based progress output instead of the progress bar.
+	progressFallback bool
+	// The data used to render migration statements as templates.
+	templateData interface{}
+	useTemplate  bool
+	// Receives log lines while migrations are run.
+	logger Logger
+	// Who applied the migrations, stored in each migration record.
+	appliedBy string
+	// How many times to try to connect to the DB, and how long to wait after
+	// the first failed attempt.
+	connectAttempts int
+	connectBackoff  time.Duration
+	// Called with the log entry of each migration as soon as it has run.
+	onMigration func(MigrationLog)
+	// Receives the events of each run if Events has been called.
+	events chan MigrationEvent
+	// Called with each migration error, and the run continues if it returns
+	// nil.
+	onMigrationError func(MigrationLog, error) error
+	// Called before and after each run.
+	beforeRun []func(ctx context.Context, pending int) error
+	afterRun  []func(ctx context.Context, log []MigrationLog, err error)
+	// Extra migration table columns, and the values stored in them.
+	recordColumns []backends.RecordColumn
+	recordFields  []backends.ExtraField
+	// Claim each migration record before the migration is run.
+	claims bool
+	// Where migrations are loaded from, and whether they are loaded again on
+	// every run.
+	sources  []MigrationSource
+	lazyLoad bool
+	// Run each migration in a savepoint, so a failed migration doesn't stop
+	// the run.
+	savepoints bool
 }
 
 // UseBackend changes the default backend to a custom or built-in backend. The
 // backend must be registered before it can be used. A backend can be registered
-// once and used on multiple migrator instances.
+// once and used on multiple migrator instances, and the Migrator gets a new
+// instance of it unless it was registered with RegisterBackend.
 func (m *Migrator) UseBackend(key string) error {
-	b, ok := registeredBackends[key]
+	registeredBackendsMu.RLock()
+	fn, ok := registeredBackends[key]
+	registeredBackendsMu.RUnlock()
 	if !ok {
-		return fmt.Errorf("backend '%s' is not a registered backend", key)
+		return fmt.Errorf("backend '%s' is not a registered backend: %w", key, ErrBackendNotFound)
 	}
-	m.backend = b
-	m.backend.Setup(m.db, m.TableName, m.tableSchema)
+	m.setBackend(fn())
 	return nil
 }
 
+// setBackend sets up b for the DB and migration table of the Migrator, and
+// makes it the backend.
+func (m *Migrator) setBackend(b backends.Backend) {
+	if p, ok := b.(backends.PragmaSetter); ok {
+		p.SetPragmas(m.pragmas)
+	}
+	if n, ok := b.(backends.NoticeCapturer); ok {
+		n.CaptureNotices(m.notices)
+	}
+	b.Setup(m.db, m.TableName, m.tableSchema)
+	b.OverrideColumns(m.columns)
+	b.ExtendColumns(m.recordColumns)
+	m.baseBackend = b
+	m.backend = m.wrapBackend(b)
+}
+
+// OnMigration sets fn to be called with the log entry of each migration as soon
+// as it has run, for example to stream progress to a client. Since all the
+// migrations are run in a single transaction, fn is called before any of them
+// are committed. Passing nil removes fn.
+func (m *Migrator) OnMigration(fn func(MigrationLog)) {
+	m.onMigration = fn
+}
+
+// OnMigrationError sets fn to be called with the log entry and error of each
+// migration that fails, for example to send an alert. If fn returns nil the
+// migration is logged with the ERROR status and the run continues with the next
+// migration, and the migrations that succeeded are committed. If fn returns an
+// error the run stops and the transaction is rolled back like it is without fn.
+// Passing nil removes fn.
+//
+// Postgres aborts the transaction when a statement fails, so the next
+// migrations fail too. Use SavepointPerMigration to roll back just the failed
+// migration instead.
+func (m *Migrator) OnMigrationError(fn func(MigrationLog, error) error) {
+	m.onMigrationError = fn
+}
+
+// OnBeforeRun adds fn to be called at the start of each run, before the DB is
+// used, for example to announce a deployment. pending is the number of
+// migrations in the run, including the ones that have already been applied,
+// since the DB has not been checked yet. If fn returns an error the run is
+// aborted and the error is returned.
+//
+// OnBeforeRun can be called more than once, and the functions are called in
+// the order they were added.
+func (m *Migrator) OnBeforeRun(fn func(ctx context.Context, pending int) error) {
+	m.beforeRun = append(m.beforeRun, fn)
+}
+
+// OnAfterRun adds fn to be called at the end of each run with the migration log
+// and the error the run returns, even if the run failed or was aborted by an
+// OnBeforeRun function.
+//
+// OnAfterRun can be called more than once, and the functions are called in the
+// order they were added.
+func (m *Migrator) OnAfterRun(fn func(ctx context.Context, log []MigrationLog, err error)) {
+	m.afterRun = append(m.afterRun, fn)
+}
+
+// WithCustomTransactionBegin replaces the default db.Beginx call used to start
+// the migration transaction with fn. This makes it possible to instrument the
+// transaction, use a specific connection, or provide a fake transaction in
+// tests. To also control how the transaction is committed and rolled back use
+// WithTransactionManager.
+func (m *Migrator) WithCustomTransactionBegin(fn func(ctx context.Context) (*sqlx.Tx, error)) {
+	m.beginTx = fn
+}
+
+// UseHashFunc changes the HashFunc used to create the checksum of each
+// migration. The default is SHA256Hash. The hashes of migrations that have
+// already been added are recalculated.
+//
+// Migration tables created by earlier versions of sqlxm store MD5 hashes. To
+// keep using them call UseHashFunc(MD5Hash), or see the README for how to
+// upgrade the table to SHA-256.
+func (m *Migrator) UseHashFunc(fn HashFunc) {
+	m.hashFunc = fn
+	for i, mig := range m.migrations {
+		m.migrations[i].hash = m.hashQuery(mig.Statement, mig.args)
+	}
+}
+
+// WithColumnOverride replaces the default definition of a migration table
+// column when the table is created. For example, to use a timezone aware date
+// column in Postgres.
+//
+//    m.WithColumnOverride("date", "TIMESTAMPTZ DEFAULT NOW() NOT NULL")
+//
+// The definition is everything after the column name. Overrides have no effect
+// if the migration table already exists.
+func (m *Migrator) WithColumnOverride(column, definition string) {
+	m.columns[column] = definition
+	if m.backend != nil {
+		m.backend.OverrideColumns(m.columns)
+	}
+}
+
 // The AddMigration method adds a new Migration to the list of migrations needed.
 //
 // It is important to note that the name argument must be unique, and it is used
@@ -146,8 +491,19 @@ func (m *Migrator) UseBackend(key string) error {
 // added.
 func (m *Migrator) AddMigration(name string, comment string, statement string, args ...interface{}) error {
 	if _, ok := m.names[name]; ok {
-		return fmt.Errorf("migration '%s' alraedy exists", name)
+		return fmt.Errorf("migration '%s' already exists: %w", name, ErrDuplicateMigration)
+	}
+	statement, err := m.renderStatement(name, statement)
+	if err != nil {
+		return err
 	}
+	m.addMigration(name, comment, statement, args)
+	return nil
+}
+
+// addMigration adds a Migration with a rendered statement, and a name that has
+// been checked.
+func (m *Migrator) addMigration(name string, comment string, statement string, args []interface{}) {
 	// Add name to set
 	m.names[name] = struct{}{}
 
@@ -155,18 +511,182 @@ func (m *Migrator) AddMigration(name string, comment string, statement string, a
 	mig := Migration{
 		Name:      name,
 		Comment:   comment,
-		hash:      hashQuery(statement, args),
+		hash:      m.hashQuery(statement, args),
 		Statement: statement,
 		args:      args,
 		migrated:  false,
 	}
 
 	m.migrations = append(m.migrations, mig)
+}
+
+// AddMigrationNoTx adds a new Migration like AddMigration that is run outside
+// the migration transaction, for statements that cannot be run in a
+// transaction, e.g. CREATE INDEX CONCURRENTLY in Postgres.
+//
+// The migration transaction is committed before the migration is run, and a new
+// one is started after it, so the migrations before it are kept even if it
+// fails. The migration record is inserted in a separate transaction after the
+// statement has run, and the record is not claimed by WithIdempotentRun.
+func (m *Migrator) AddMigrationNoTx(name string, comment string, statement string) error {
+	err := m.AddMigration(name, comment, statement)
+	if err != nil {
+		return err
+	}
+	m.migrations[len(m.migrations)-1].NoTransaction = true
+	return nil
+}
+
+// AddConditionalMigration adds a new Migration like AddMigration, but condition
+// is called when the migrations are run, and the migration is skipped if it
+// returns false. A skipped migration is logged with the SKIPPED status and no
+// record is inserted, so it is checked again on the next run. For example, to
+// only add test fixtures in development.
+//
+// If the migration has already been run its hash is still validated.
+func (m *Migrator) AddConditionalMigration(name string, comment string, statement string, condition func() bool) error {
+	err := m.AddMigration(name, comment, statement)
+	if err != nil {
+		return err
+	}
+	m.migrations[len(m.migrations)-1].condition = condition
+	return nil
+}
+
+// AddMigrationIfNotExists adds a new Migration like AddMigration, unless a
+// migration with the same name and hash has already been added. This makes it
+// safe to call from registration code that may run more than once.
+//
+// If a migration with the same name but a different hash exists an
+// ErrDuplicateMigration error is returned. When WithStrictDuplicateCheck is
+// enabled every duplicate returns ErrDuplicateMigration.
+func (m *Migrator) AddMigrationIfNotExists(name string, comment string, statement string, args ...interface{}) error {
+	i, exists := m.findMigration(name)
+	if !exists {
+		return m.AddMigration(name, comment, statement, args...)
+	}
+	statement, err := m.renderStatement(name, statement)
+	if err != nil {
+		return err
+	}
+	if m.strictDuplicates || m.migrations[i].hash != m.hashQuery(statement, args) {
+		return fmt.Errorf("migration '%s': %w", name, ErrDuplicateMigration)
+	}
+	return nil
+}
+
+// ReplaceMigration replaces the statement and comment of the added migration
+// with name, and updates its hash, e.g. to patch a generated migration or swap
+// a statement in a test. The args and the position of the migration are kept.
+//
+// If the migration has already been run, as far as the Migrator knows from its
+// last run, and the hash changes a warning is logged. Run will then log a hash
+// mismatch and RunStrict will return an error, unless the hash is repaired. An
+// error is returned if no migration with name has been added.
+func (m *Migrator) ReplaceMigration(name string, newStatement string, newComment string) error {
+	i, exists := m.findMigration(name)
+	if !exists {
+		return fmt.Errorf("replace migration '%s' failed: migration has not been added", name)
+	}
+	statement, err := m.renderStatement(name, newStatement)
+	if err != nil {
+		return err
+	}
+	mig := &m.migrations[i]
+	hash := m.hashQuery(statement, mig.args)
+	if m.knownApplied(name) && hash != mig.hash {
+		m.logger.Warn("replaced migration has already been run", "name", name, "hash", hash, "previous_hash", mig.hash)
+	}
+	mig.Statement = statement
+	mig.Comment = newComment
+	mig.hash = hash
 	return nil
 }
 
+// knownApplied returns true if the migration with name was applied before the
+// last run, or was applied by a run of the Migrator.
+func (m *Migrator) knownApplied(name string) bool {
+	if _, applied := m.previous[name]; applied {
+		return true
+	}
+	for _, l := range m.log {
+		if l.Name == name && l.Status == SUCCESS {
+			return true
+		}
+	}
+	return false
+}
+
+// WithStrictDuplicateCheck makes AddMigrationIfNotExists return
+// ErrDuplicateMigration for every migration that has already been added, even
+// when the hashes match.
+func (m *Migrator) WithStrictDuplicateCheck(strict bool) {
+	m.strictDuplicates = strict
+}
+
+// WithCommitHook registers fn to be called with the migration transaction just
+// before it is committed. The names of the migrations applied during the run are
+// passed to fn, and fn can run its own queries in the same transaction. For
+// example, to insert an audit record atomically with the migrations.
+//
+// If fn returns an error the transaction is rolled back and none of the
+// migrations are applied.
+func (m *Migrator) WithCommitHook(fn func(tx *sqlx.Tx, appliedNames []string) error) {
+	m.commitHook = fn
+}
+
+// WithMigrationTableChecksum enables a checksum of the migration table. At the
+// start of each run the checksum of all the migration records is compared with
+// the checksum stored after the last successful run in the "<TableName>_checksum"
+// table. If the records were inserted, edited, or deleted outside of sqlxm the
+// checksums will not match, and ErrMigrationTableTampered is returned.
+//
+// The checksum covers the whole table, so runs return ErrNamespaced if it is
+// enabled on a Migrator with a namespace.
+func (m *Migrator) WithMigrationTableChecksum(enabled bool) {
+	m.tableChecksum = enabled
+}
+
+// findMigration returns the index of the named migration in m.migrations.
+func (m *Migrator) findMigration(name string) (int, bool) {
+	for i, mig := range m.migrations {
+		if mig.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// GetMigration returns the added migration with name, and false if no migration
+// with the name has been added.
+func (m *Migrator) GetMigration(name string) (Migration, bool) {
+	i, ok := m.findMigration(name)
+	if !ok {
+		return Migration{}, false
+	}
+	return m.migrations[i], true
+}
+
+// AllMigrations returns a copy of the added migrations in the order they were
+// added, for example to inspect them before they are run.
+func (m *Migrator) AllMigrations() []Migration {
+	migrations := make([]Migration, len(m.migrations))
+	copy(migrations, m.migrations)
+	return migrations
+}
+
+// SkipMigrations excludes the named migrations from the following runs without
+// removing them. A skipped migration that has not been run is logged with the
+// SKIPPED status, and is not run or recorded. Migrations that have already been
+// run are not affected, and their hashes are still validated.
+func (m *Migrator) SkipMigrations(names ...string) {
+	for _, n := range names {
+		m.skip[n] = struct{}{}
+	}
+}
+
 // RepairHash finds an existing migration by name and updates the hash in the
-// DB. This is useful if you are using Run in safe mode, and there have been
+// DB. This is useful if you are using RunStrict, and there have been
 // non-substantive changes to the Migration.Statement such as formatting or
 // indenting changes.
 //
@@ -180,6 +700,17 @@ func (m *Migrator) RepairHash(names ...string) {
 	}
 }
 
+// InspectDB returns the names of all the tables in the database schema used by
+// the Migrator, including the migration table itself. This is useful to assert
+// the state of the database after migrations have been run.
+func (m *Migrator) InspectDB() ([]string, error) {
+	tables, err := m.backend.ListTables()
+	if err != nil {
+		return nil, fmt.Errorf("list tables failed: %w", err)
+	}
+	return tables, nil
+}
+
 // Run executes the new migrations against the DB.
 //
 // Run does a couple of things...
@@ -195,12 +726,40 @@ func (m *Migrator) RepairHash(names ...string) {
 // applied. This ensures that if something goes wrong there is not an unknown
 // state where some migrations are applied and some are not.
 //
-// It is important to note that Run validates the integrity of past
-// migrations. Once a migration has been run the hash is stored in the DB and
-// the hash is checked against the migration hash each time it is run. This
-// means that if changes are made to a migration statement after it has already
-// been run, the two hashes will not match. In this case Run will return a hash
-// mismatch error.
+// Run is in loose mode, it will not stop and return an error if an existing
+// record hash does not match the hash of the migration. The mismatch is logged
+// in the details of the migration log instead. "alter table" and "ALTER TABLE"
+// produce the same results, but have a different hash. To keep auto-formatters
+// and linter changes from breaking old migrations Run will ignore these and all
+// other changes to the statement and args.
+//
+// Use RunStrict to return an error when a migration has changed since it was
+// run.
+func (m *Migrator) Run() ([]MigrationLog, error) {
+	return m.RunContext(context.Background())
+}
+
+// RunContext is like Run but uses ctx. If ctx is cancelled while the
+// migrations are running the transaction is rolled back and the returned error
+// wraps ctx.Err(), so a cancellation can be told apart from a failed migration
+// with errors.Is.
+func (m *Migrator) RunContext(ctx context.Context) ([]MigrationLog, error) {
+	err := m.loadSources()
+	if err != nil {
+		return m.log, err
+	}
+	m.safe = false
+	err = m.run(ctx, m.migrations, 0)
+	return m.log, err
+}
+
+// RunStrict executes the new migrations against the DB like Run, but in strict
+// (safe) mode, which validates the integrity of past migrations. Once a
+// migration has been run the hash is stored in the DB and the hash is checked
+// against the migration hash each time it is run. This means that if changes
+// are made to a migration statement after it has already been run, the two
+// hashes will not match. In this case RunStrict will return a hash mismatch
+// error.
 //
 // The reason the hash is checked on each subsequent run is simple. Adding "NOT
 // NULL" to an already run "CREATE TABLE" migration will cause that brand-new
@@ -211,55 +770,227 @@ func (m *Migrator) RepairHash(names ...string) {
 //
 // If you have a style change like making all SQL keywords uppercase you can use
 // RepairHash to rehash the migration and update the Hash in the database.
-//
-// If you want to skip the hash validation you can use RunUnsafe instead.
-func (m *Migrator) Run() ([]MigrationLog, error) {
+func (m *Migrator) RunStrict() ([]MigrationLog, error) {
+	return m.RunStrictContext(context.Background())
+}
+
+// RunStrictContext is like RunStrict but uses ctx. Cancellation is handled the
+// same way as RunContext.
+func (m *Migrator) RunStrictContext(ctx context.Context) ([]MigrationLog, error) {
+	err := m.loadSources()
+	if err != nil {
+		return m.log, err
+	}
 	m.safe = true
-	err := m.run()
+	err = m.run(ctx, m.migrations, 0)
 	return m.log, err
 }
 
-// RunUnsafe executes the new migrations against the DB like Run, but in unsafe
-// mode. Unsafe mode will not stop and return an error if an existing record
-// hash does not match the hash of the migration.
+// RunUnsafe is the same as Run.
 //
-// The reason you may not want the hash checked on each subsequent run is simple.
-// "alter table" and "ALTER TABLE" produce the same results, but have a
-// different hash. To keep auto-formatters and linter changes from breaking old
-// migrations RunUnsafe will ignore these and all other changes to the statement
-// and args.
+// Deprecated: Use Run, which is in loose mode. Use RunStrict for safe mode.
 func (m *Migrator) RunUnsafe() ([]MigrationLog, error) {
-	m.safe = false
-	err := m.run()
-	return m.log, err
+	return m.Run()
+}
+
+// RunUnsafeContext is the same as RunContext.
+//
+// Deprecated: Use RunContext, which is in loose mode. Use RunStrictContext for
+// safe mode.
+func (m *Migrator) RunUnsafeContext(ctx context.Context) ([]MigrationLog, error) {
+	return m.RunContext(ctx)
 }
 
-// run all the Migrator.migrations.
-func (m *Migrator) run() error {
-	// Create the migration table if it does not exist
-	exists, err := m.backend.HasMigrationTable()
+// MigrateTo is like RunStrict, but stops after the migration with the given
+// name has been applied. The migrations after it are left pending, and a later
+// call to RunStrict applies them. This is useful to reproduce the schema as it was at a
+// specific migration.
+//
+// Unlike Run, MigrateTo is always in strict (safe) mode, so a hash mismatch of
+// a past migration stops it with ErrHashMismatch.
+//
+// An error is returned before the DB is touched if no migration with the name
+// has been added.
+func (m *Migrator) MigrateTo(name string) ([]MigrationLog, error) {
+	err := m.loadSources()
 	if err != nil {
-		return fmt.Errorf("the migration table check failed: %w", err)
+		return m.log, err
 	}
+	i, exists := m.findMigration(name)
 	if !exists {
-		err := m.createMigrationTable()
+		return m.log, fmt.Errorf("migrate to '%s' failed: migration has not been added", name)
+	}
+	m.safe = true
+	err = m.run(context.Background(), m.migrations[:i+1], 0)
+	return m.log, err
+}
+
+// MigrateBetween is like MigrateTo, but only runs the migrations added after
+// the from migration, up to and including the to migration, e.g. to run only
+// the migrations added in a feature branch. If from is empty the migrations
+// are run from the first one, and if to is empty they are run to the last one.
+// Like MigrateTo, it is always in strict (safe) mode.
+//
+// An error is returned if either migration has not been added, or if from was
+// added after to.
+func (m *Migrator) MigrateBetween(from string, to string) ([]MigrationLog, error) {
+	err := m.loadSources()
+	if err != nil {
+		return m.log, err
+	}
+	start, end := 0, len(m.migrations)
+	if from != "" {
+		i, exists := m.findMigration(from)
+		if !exists {
+			return m.log, fmt.Errorf("migrate from '%s' failed: migration has not been added", from)
+		}
+		start = i + 1
+	}
+	if to != "" {
+		i, exists := m.findMigration(to)
+		if !exists {
+			return m.log, fmt.Errorf("migrate to '%s' failed: migration has not been added", to)
+		}
+		end = i + 1
+	}
+	if start > end {
+		return m.log, fmt.Errorf("migrate between '%s' and '%s' failed: '%s' was added after '%s'", from, to, from, to)
+	}
+	m.safe = true
+	err = m.run(context.Background(), m.migrations[start:end], 0)
+	return m.log, err
+}
+
+// RunN is like RunStrict, but stops after n migrations have been applied.
+// Migrations that have already been run don't count towards n. If there are fewer than n
+// pending migrations they are all applied. This is useful to roll out a few
+// migrations at a time. Like MigrateTo, it is always in strict (safe) mode.
+//
+// An error is returned if n is less than 1.
+func (m *Migrator) RunN(n int) ([]MigrationLog, error) {
+	if n < 1 {
+		return m.log, fmt.Errorf("run count must be greater than 0, got %d", n)
+	}
+	err := m.loadSources()
+	if err != nil {
+		return m.log, err
+	}
+	m.safe = true
+	err = m.run(context.Background(), m.migrations, n)
+	return m.log, err
+}
+
+// run the migrations, which must be in the order of Migrator.migrations. If
+// limit is greater than 0 the run stops after limit migrations have been
+// applied. If ctx has been cancelled the error returned wraps ctx.Err().
+func (m *Migrator) run(ctx context.Context, migrations []Migration, limit int) (err error) {
+	defer func() {
+		for _, fn := range m.afterRun {
+			fn(ctx, m.log, err)
+		}
+		m.sendEvent(MigrationEvent{Type: RunComplete})
+	}()
+	for _, fn := range m.beforeRun {
+		err = fn(ctx, len(migrations))
+		if err != nil {
+			return fmt.Errorf("before run hook failed: %w", err)
+		}
+	}
+
+	if m.savepoints && m.safe {
+		return fmt.Errorf("savepoint per migration: %w", ErrSafeMode)
+	}
+	if m.sequential {
+		err = m.checkSequence()
 		if err != nil {
-			return fmt.Errorf("create '%s' table failed: %w", m.TableName, err)
+			return err
+		}
+	}
+
+	ctx, span := m.startSpan(ctx, "sqlxm.Run")
+	defer span.End()
+
+	err = m.connect(ctx)
+	if err != nil {
+		span.SetError(err)
+		m.logger.Error("migration run failed", "error", err)
+		return err
+	}
+
+	start := time.Now()
+	m.logger.Info("running migrations", "table", m.TableName, "migrations", len(migrations))
+	if m.nonTransactional {
+		m.logger.Warn("running migrations without a transaction, failed migrations are not rolled back")
+	}
+	err = m.runMigrations(ctx, migrations, limit)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("migration run cancelled: %s: %w", err, ctx.Err())
+	}
+	if err != nil {
+		span.SetError(err)
+		m.logger.Error("migration run failed", "error", err, "duration", time.Since(start))
+		return err
+	}
+	m.logger.Info("migration run complete", "duration", time.Since(start))
+	return nil
+}
+
+// runMigrations does the work for run.
+func (m *Migrator) runMigrations(ctx context.Context, migrations []Migration, limit int) (err error) {
+	// Make sure no one else is running migrations at the same time
+	err = m.backend.Lock(ctx, m.lockTimeout)
+	if err != nil {
+		return fmt.Errorf("acquire migration lock failed: %w", err)
+	}
+	defer m.backend.Unlock(context.Background())
+
+	err = m.ensureMigrationTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	if m.tableChecksum {
+		err = m.verifyTableChecksum()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(m.lintRules) > 0 {
+		prev, err := m.backend.QueryPreviousContext(ctx)
+		if err != nil {
+			return fmt.Errorf("get previous migrations failed: %w", err)
+		}
+		err = m.lint(migrations, prev)
+		if err != nil {
+			return err
 		}
 	}
 
 	// Create transaction for migrations
-	tx, err := m.db.Beginx()
+	tx, err := m.begin(ctx)
 	if err != nil {
 		return fmt.Errorf("begin transaction failed: %w", err)
 	}
 	commit := true
+	// The logs of the migrations run in the current transaction start here.
+	start := len(m.log)
 	defer func() {
+		if m.rollbackOnPanic {
+			if r := recover(); r != nil {
+				m.rollbackTx(tx)
+				panic(r)
+			}
+		}
 		if commit {
-			tx.Commit()
+			cerr := m.commitTx(tx)
+			if cerr != nil {
+				err = fmt.Errorf("commit transaction failed: %w", cerr)
+				failUncommitted(m.log[start:], cerr)
+			}
 			return
 		}
-		tx.Rollback()
+		m.rollbackTx(tx)
 	}()
 
 	err = m.repairHashes(tx)
@@ -269,34 +1000,145 @@ func (m *Migrator) run() error {
 	}
 
 	// Get previous migrations
-	prev, err := m.backend.QueryPrevious()
+	prev, err := m.backend.QueryPreviousContext(ctx)
 	if err != nil {
 		commit = false
 		return fmt.Errorf("get previous migrations failed: %w", err)
 	}
 	m.previous = prev
 
+	if m.maxPending > 0 {
+		pending := m.countPending(migrations, limit)
+		if pending > m.maxPending {
+			commit = false
+			return fmt.Errorf("%w: %d pending migrations, the limit is %d", ErrTooManyPending, pending, m.maxPending)
+		}
+	}
+
 	// Run each migration
-	for _, mig := range m.migrations {
-		err = m.executeMigration(tx, mig)
+	applied := make([]string, 0, len(migrations))
+	failed := make([]string, 0)
+	if m.progress != nil {
+		defer m.progress.done()
+	}
+	for i, mig := range migrations {
+		if m.progress != nil {
+			m.progress.update(i+1, len(migrations), mig.Name)
+		}
+		mig.NoTransaction = mig.NoTransaction || m.nonTransactional
+		if mig.NoTransaction {
+			// Pause the migration transaction while the migration is run
+			// outside of it.
+			err = m.commitTx(tx)
+			if err != nil {
+				commit = false
+				failUncommitted(m.log[start:], err)
+				return fmt.Errorf("commit transaction failed: %w", err)
+			}
+		}
+		if m.savepoints && !mig.NoTransaction {
+			var migFailed bool
+			migFailed, err = m.executeMigrationSavepoint(ctx, tx, mig, i)
+			if migFailed && err == nil {
+				failed = append(failed, mig.Name)
+				continue
+			}
+		} else {
+			err = m.executeMigration(ctx, tx, mig)
+			if err != nil && m.onMigrationError != nil {
+				err = m.onMigrationError(m.log[len(m.log)-1], err)
+			}
+		}
 		if err != nil {
 			commit = false
 			return fmt.Errorf("run error on '%s': %w", mig.Name, err)
 		}
+		if mig.NoTransaction {
+			next, err := m.begin(ctx)
+			if err != nil {
+				commit = false
+				return fmt.Errorf("begin transaction failed: %w", err)
+			}
+			tx = next
+			start = len(m.log)
+		}
+		if m.log[len(m.log)-1].Status == SUCCESS {
+			applied = append(applied, mig.Name)
+		}
+		if limit > 0 && len(applied) == limit {
+			break
+		}
+	}
+
+	if m.tableChecksum {
+		err = m.storeTableChecksum(tx)
+		if err != nil {
+			commit = false
+			return fmt.Errorf("store migration table checksum failed: %w", err)
+		}
+	}
+
+	if m.commitHook != nil {
+		err = m.commitHook(tx, applied)
+		if err != nil {
+			commit = false
+			return fmt.Errorf("commit hook failed: %w", err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrMigrationsFailed, strings.Join(failed, ", "))
 	}
 	return err
 }
 
+// countPending returns how many of migrations the run will apply, at most limit
+// if it is greater than 0. Migrations that have been run or are skipped with
+// SkipMigrations don't count.
+func (m *Migrator) countPending(migrations []Migration, limit int) int {
+	pending := 0
+	for _, mig := range migrations {
+		if _, exists := m.previous[mig.Name]; exists {
+			continue
+		}
+		if _, skip := m.skip[mig.Name]; skip {
+			continue
+		}
+		pending++
+	}
+	if limit > 0 && pending > limit {
+		return limit
+	}
+	return pending
+}
+
 // Executes a single migration
-func (m *Migrator) executeMigration(tx *sqlx.Tx, mig Migration) error {
+func (m *Migrator) executeMigration(ctx context.Context, tx *sqlx.Tx, mig Migration) (err error) {
+	ctx, span := m.startSpan(ctx, "sqlxm.Migration")
 	mLog := MigrationLog{
-		Name:    mig.Name,
-		Hash:    mig.hash,
-		Status:  SUCCESS,
-		Details: "ran migration successfully",
+		Name:      mig.Name,
+		Hash:      mig.hash,
+		Status:    SUCCESS,
+		Details:   "ran migration successfully",
+		StartTime: time.Now(),
 	}
 	defer func() {
+		mLog.EndTime = time.Now()
+		mLog.Duration = mLog.EndTime.Sub(mLog.StartTime)
 		m.log = append(m.log, mLog)
+		if m.onMigration != nil {
+			m.onMigration(mLog)
+		}
+		m.sendEvent(MigrationEvent{Type: MigrationComplete, Log: mLog})
+		if m.metrics != nil {
+			m.metrics.OnMigrationRun(mLog.Name, mLog.Status, mLog.Duration)
+		}
+		span.SetAttribute("migration.name", mLog.Name)
+		span.SetAttribute("migration.hash", mLog.Hash)
+		span.SetAttribute("migration.status", mLog.Status.String())
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
 	}()
 
 	_, exists := m.previous[mig.Name]
@@ -309,49 +1151,288 @@ func (m *Migrator) executeMigration(tx *sqlx.Tx, mig Migration) error {
 			mLog.Details = d
 			if m.safe {
 				mLog.Status = ERROR_HASH
-				return fmt.Errorf("%s %s", mig.Name, d)
+				m.logger.Error("migration hash mismatch", "name", mig.Name, "stored_hash", h, "hash", mig.hash)
+				return fmt.Errorf("%s %s: %w", mig.Name, d, ErrHashMismatch)
 			}
+			m.logger.Warn("migration hash mismatch", "name", mig.Name, "stored_hash", h, "hash", mig.hash)
 		}
+		m.logger.Debug("migration already run", "name", mig.Name)
+		return nil
+	}
+
+	if _, skip := m.skip[mig.Name]; skip {
+		mLog.Status = SKIPPED
+		mLog.Details = "migration skipped"
+		m.logger.Info("migration skipped", "name", mig.Name)
+		return nil
+	}
+	if mig.condition != nil && !mig.condition() {
+		mLog.Status = SKIPPED
+		mLog.Details = "migration condition not met"
+		m.logger.Info("migration skipped", "name", mig.Name, "reason", "condition not met")
 		return nil
 	}
 
-	err := mig.run(tx)
+	claimer, claims := m.claimer()
+	claims = claims && !mig.NoTransaction
+	if claims {
+		var claimed bool
+		claimed, err = claimer.ClaimRecord(ctx, tx, mig.Name, mig.hash, mig.Comment, m.appliedBy, m.recordFields...)
+		if err != nil {
+			mLog.Status = ERROR
+			mLog.Details = fmt.Sprintf("record claim failed: %s", err)
+			m.logger.Error("migration record claim failed", "name", mig.Name, "error", err)
+			return err
+		}
+		if !claimed {
+			mLog.Status = SKIPPED
+			mLog.Details = "migration claimed by another run"
+			m.logger.Info("migration skipped", "name", mig.Name, "reason", "claimed by another run")
+			return nil
+		}
+	}
+
+	if m.preprocess != nil {
+		mig.Statement, err = m.preprocess(ctx, mig.Name, mig.Statement)
+		if err != nil {
+			mLog.Status = ERROR
+			mLog.Details = fmt.Sprintf("preprocess failed: %s", err)
+			m.logger.Error("migration preprocess failed", "name", mig.Name, "error", err)
+			return err
+		}
+	}
+
+	m.logger.Debug("running migration", "name", mig.Name)
+	err = m.runStatement(ctx, tx, mig)
 	if err != nil {
 		mLog.Status = ERROR
 		mLog.Details = fmt.Sprintf("failed: %s", err)
+		if m.preprocess != nil {
+			mLog.Details = fmt.Sprintf("failed: %s: statement: %s", err, mig.Statement)
+		}
+		m.logger.Error("migration failed", "name", mig.Name, "error", err)
 		return err
 	}
 
 	// If the migration record insert fails something is wrong, and we should stop.
-	err = mig.insertRecord(tx, m)
+	executionMs := time.Since(mLog.StartTime).Milliseconds()
+	switch {
+	case mig.NoTransaction:
+		err = mig.insertRecordNoTx(ctx, m, executionMs)
+	case claims:
+		err = claimer.CompleteRecord(ctx, tx, mig.Name, executionMs)
+	default:
+		err = mig.insertRecord(ctx, tx, m, executionMs)
+	}
 	if err != nil {
 		mLog.Status = ERROR
 		mLog.Details = fmt.Sprintf("record insert failed: %s", err)
+		m.logger.Error("migration record insert failed", "name", mig.Name, "error", err)
+		return err
+	}
+	m.logger.Info("migration applied", "name", mig.Name, "duration", time.Since(mLog.StartTime))
+	return nil
+}
+
+// runStatement runs the statement of mig in tx, or on the DB if the migration
+// is not run in a transaction. When a query timeout is set the statement is
+// cancelled with the context after the timeout, and if the backend is a
+// backends.QueryTimeouter the database also stops it. An ErrQueryTimeout error
+// is returned when the timeout is hit.
+func (m *Migrator) runStatement(ctx context.Context, tx *sqlx.Tx, mig Migration) error {
+	if m.queryTimeout <= 0 {
+		return m.execStatement(ctx, tx, mig)
+	}
+	stmtCtx, stop := withStatementTimeout(ctx, m.queryTimeout)
+	defer stop()
+
+	t, timeouts := m.backend.(backends.QueryTimeouter)
+	if timeouts && !mig.NoTransaction {
+		statement, err := t.TimeoutStatement(stmtCtx, tx, mig.Statement, m.queryTimeout)
+		if err != nil {
+			return fmt.Errorf("set query timeout failed: %w", err)
+		}
+		mig.Statement = statement
+	}
+	err := m.execStatement(stmtCtx, tx, mig)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	if errors.Is(stmtCtx.Err(), context.DeadlineExceeded) || (timeouts && t.IsQueryTimeout(err)) {
+		return fmt.Errorf("%w: statement ran longer than %s: %s", ErrQueryTimeout, m.queryTimeout, err)
+	}
+	return err
+}
+
+// A statementContext is done when its parent is done or its timeout is hit.
+// Unlike a context from context.WithTimeout, stopping it doesn't make it done,
+// since some drivers, e.g. SQLite, interrupt the connection when the context
+// is done even if the statement has already finished, which can interrupt the
+// next statement instead.
+type statementContext struct {
+	context.Context
+	done chan struct{}
+	mu   sync.Mutex
+	err  error
+}
+
+// withStatementTimeout returns a statementContext that is done after timeout,
+// and a func to stop the timeout once the statement has finished.
+func withStatementTimeout(parent context.Context, timeout time.Duration) (context.Context, func()) {
+	c := &statementContext{Context: parent, done: make(chan struct{})}
+	stop := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		var err error
+		select {
+		case <-parent.Done():
+			err = parent.Err()
+		case <-timer.C:
+			err = context.DeadlineExceeded
+		case <-stop:
+			return
+		}
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+	}()
+	return c, func() { close(stop) }
+}
+
+// Done returns a channel that is closed when the statement should be stopped.
+func (c *statementContext) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns context.DeadlineExceeded if the timeout was hit, or the error of
+// the parent if it was done first.
+func (c *statementContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// execStatement runs the statement of mig in tx, or on the DB if the migration
+// is not run in a transaction.
+func (m *Migrator) execStatement(ctx context.Context, tx *sqlx.Tx, mig Migration) error {
+	if mig.NoTransaction {
+		_, err := m.db.ExecContext(ctx, mig.Statement, mig.args...)
 		return err
 	}
+	return m.retry(ctx, tx, func() error {
+		return mig.run(ctx, tx, m)
+	})
+}
+
+// retry calls fn with the backend's Retry if it is a backends.Retrier.
+func (m *Migrator) retry(ctx context.Context, tx *sqlx.Tx, fn func() error) error {
+	if r, ok := m.backend.(backends.Retrier); ok {
+		return r.Retry(ctx, tx, fn)
+	}
+	return fn()
+}
+
+// ensureMigrationTable creates the migration table if it does not exist.
+func (m *Migrator) ensureMigrationTable(ctx context.Context) error {
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil {
+		return errTableSetup("the migration table check failed", err)
+	}
+	if !exists {
+		err := m.createMigrationTable(ctx)
+		if err != nil {
+			return errTableSetup(fmt.Sprintf("create '%s' table failed", m.TableName), err)
+		}
+		return nil
+	}
+	// The table may have been created by an older version of sqlxm.
+	err = m.backend.MigrateMigrationTable()
+	if err != nil {
+		return errTableSetup(fmt.Sprintf("upgrade '%s' table failed", m.TableName), err)
+	}
 	return nil
 }
 
 // Creates the migrations table
-func (m *Migrator) createMigrationTable() error {
-	q, err := m.backend.CreateMigrationTable()
+func (m *Migrator) createMigrationTable(ctx context.Context) error {
+	start := time.Now()
+	q, err := m.backend.CreateMigrationTableContext(ctx)
+	end := time.Now()
 
 	l := MigrationLog{
-		Name:    fmt.Sprintf("create_%s_table", m.TableName),
-		Hash:    hashQuery(q),
-		Status:  SUCCESS,
-		Details: fmt.Sprintf("created '%s' table", m.TableName),
+		Name:      fmt.Sprintf("create_%s_table", m.TableName),
+		Hash:      m.hashQuery(q, nil),
+		Status:    SUCCESS,
+		Details:   fmt.Sprintf("created '%s' table", m.TableName),
+		StartTime: start,
+		EndTime:   end,
+		Duration:  end.Sub(start),
 	}
 
 	if err != nil {
 		l.Status = ERROR
 		l.Details = err.Error()
+		m.logger.Error("create migration table failed", "table", m.TableName, "error", err)
+	} else {
+		m.logger.Info("created migration table", "table", m.TableName)
 	}
 
 	m.log = append(m.log, l)
 	return err
 }
 
+// recordsChecksum returns the checksum of all the records in the migration table.
+func (m *Migrator) recordsChecksum(q sqlx.Queryer) (string, error) {
+	records, err := m.backend.QueryRecords(q)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, r := range records {
+		fmt.Fprintf(h, "%d:%s:%s:%s;", r.ID, r.Name, r.Hash, r.Comment)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// verifyTableChecksum compares the migration table checksum with the stored
+// checksum.
+func (m *Migrator) verifyTableChecksum() error {
+	if m.namespace != "" {
+		return fmt.Errorf("migration table checksum: %w", ErrNamespaced)
+	}
+	stored, err := m.backend.QueryChecksum()
+	if err != nil {
+		return fmt.Errorf("get migration table checksum failed: %w", err)
+	}
+	// Nothing to verify until the first checksum has been stored.
+	if stored == "" {
+		return nil
+	}
+	current, err := m.recordsChecksum(m.db)
+	if err != nil {
+		return fmt.Errorf("compute migration table checksum failed: %w", err)
+	}
+	if current != stored {
+		return fmt.Errorf("'%s' checksum '%s' does not match '%s': %w", m.TableName, current, stored, ErrMigrationTableTampered)
+	}
+	return nil
+}
+
+// storeTableChecksum stores the checksum of the migration table including the
+// records inserted by tx.
+func (m *Migrator) storeTableChecksum(tx *sqlx.Tx) error {
+	if m.namespace != "" {
+		return fmt.Errorf("migration table checksum: %w", ErrNamespaced)
+	}
+	checksum, err := m.recordsChecksum(tx)
+	if err != nil {
+		return err
+	}
+	return m.backend.StoreChecksum(tx, checksum)
+}
+
 // Gets the new hashes calls the backend RepairHashes method.
 func (m *Migrator) repairHashes(tx *sqlx.Tx) error {
 	if len(m.repair) == 0 {
@@ -369,9 +1450,8 @@ func (m *Migrator) repairHashes(tx *sqlx.Tx) error {
 
 // hashIsValid returns stored hash and true if the hash is valid.
 func (m Migrator) hashIsValid(mig Migration) (string, bool) {
-	repaired, exists := m.repair[mig.Name]
-	if exists && repaired == mig.hash {
-		return repaired, true
+	if m.hashRepaired(mig.Name) {
+		return mig.hash, true
 	}
 	previous, exists := m.previous[mig.Name]
 	if !exists {
@@ -380,17 +1460,76 @@ func (m Migrator) hashIsValid(mig Migration) (string, bool) {
 	return previous, previous == mig.hash
 }
 
-// New creates and returns a new Migrator instance. You typically should use one
+// hashRepaired returns true if the hash of the named migration is repaired with
+// RepairHash, so it is replaced with the current hash by the next run.
+func (m Migrator) hashRepaired(name string) bool {
+	_, ok := m.repair[name]
+	return ok
+}
+
+// ParseTableName splits a table name of the form schema.table into the schema
+// and the table, e.g. "migrations.schema_changes". The schema is empty if the
+// name is not qualified.
+func ParseTableName(qualified string) (schema string, table string) {
+	return backends.ParseTableName(qualified)
+}
+
+// New creates and returns a new Migrator instance like NewWithOptions, with
+// the table name and schema as arguments. You typically should use one
 // Migrator per database.
-func New(db *sqlx.DB, tableName string, tableSchema string) (Migrator, error) {
+//
+// If tableName is empty DefaultTableName is used, and if tableSchema is empty
+// the backend default is used. The tableName can be qualified with the schema,
+// e.g. "migrations.schema_changes", in which case tableSchema must be empty or
+// the same schema. Options are applied after the tableName and tableSchema
+// arguments, so WithTableName and WithTableSchema take precedence.
+//
+//    m, err := sqlxm.New(db, "", "", sqlxm.WithTableName("schema_changes"))
+func New(db *sqlx.DB, tableName string, tableSchema string, opts ...Option) (Migrator, error) {
+	schema, _ := ParseTableName(tableName)
+	if schema != "" && tableSchema != "" && tableSchema != schema {
+		return Migrator{}, fmt.Errorf("table name schema '%s' does not match table schema '%s'", schema, tableSchema)
+	}
+	args := make([]Option, 0, 2+len(opts))
+	if tableSchema != "" {
+		args = append(args, WithTableSchema(tableSchema))
+	}
+	if tableName != "" {
+		args = append(args, WithTableName(tableName))
+	}
+	return NewWithOptions(db, append(args, opts...)...)
+}
+
+// NewWithOptions creates and returns a new Migrator instance configured with
+// opts. The migration table is DefaultTableName in the backend default schema
+// unless WithTableName or WithTableSchema is used, the migrations are hashed
+// with SHA256Hash, and the lock timeout is DefaultLockTimeout.
+//
+//    m, err := sqlxm.NewWithOptions(db,
+//        sqlxm.WithTableName("public.schema_changes"),
+//        sqlxm.WithLockTimeout(10*time.Second),
+//    )
+func NewWithOptions(db *sqlx.DB, opts ...Option) (Migrator, error) {
 	m := Migrator{
 		db:          db,
-		TableName:   tableName,
-		tableSchema: tableSchema,
+		TableName:   DefaultTableName,
 		previous:    make(map[string]string),
 		migrations:  make([]Migration, 0, 1),
 		repair:      make(map[string]string),
 		names:       make(map[string]struct{}),
+		skip:        make(map[string]struct{}),
+		columns:     make(map[string]string),
+		hashFunc:    SHA256Hash,
+		lockTimeout: DefaultLockTimeout,
+		logger:      NoOpLogger(),
+		txManager:   DefaultTransactionManager{},
+	}
+	m.appliedBy, _ = os.Hostname()
+	for _, opt := range opts {
+		opt(&m)
+	}
+	if m.namespace != "" && !validNamespace(m.namespace) {
+		return Migrator{}, fmt.Errorf("namespace '%s': %w", m.namespace, ErrInvalidNamespace)
 	}
 	b := BackendType(db.DriverName())
 	err := m.UseBackend(b)
@@ -398,12 +1537,25 @@ func New(db *sqlx.DB, tableName string, tableSchema string) (Migrator, error) {
 	return m, err
 }
 
-// The hashQuery function is for creating a checksum for each Migration.
-func hashQuery(query string, args ...interface{}) string {
-	var b strings.Builder
-	b.WriteString(query)
-	for _, arg := range args {
-		b.WriteString(fmt.Sprintf("%v", arg))
+// NewFromSQL creates a Migrator like NewWithOptions for a database/sql DB, so
+// sqlx does not need to be imported to use sqlxm. The driverName must be given
+// since sql.DB doesn't expose it, and it is used to pick the backend. The table
+// name and schema can be set with the WithTableName and WithTableSchema
+// options.
+//
+// The DB is wrapped with sqlx.NewDb, which doesn't open a new connection or
+// copy the DB, so there is no cost to using NewFromSQL.
+//
+//    m, err := sqlxm.NewFromSQL(db, "postgres", sqlxm.WithTableSchema("public"))
+func NewFromSQL(db *sql.DB, driverName string, opts ...Option) (Migrator, error) {
+	return NewWithOptions(sqlx.NewDb(db, driverName), opts...)
+}
+
+// The hashQuery method is for creating a checksum for each Migration using the
+// Migrator HashFunc.
+func (m *Migrator) hashQuery(query string, args []interface{}) string {
+	if m.normalizeHash {
+		query = NormalizeStatement(query)
 	}
-	return fmt.Sprintf("%x", md5.Sum([]byte(b.String())))
+	return m.hashFunc(query, args)
 }
@@ -1,11 +1,23 @@
 package sqlxm
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/danielmorell/sqlxm/backends"
 	_ "github.com/go-sql-driver/mysql"
@@ -27,7 +39,15 @@ type back struct {
 func (b *back) Setup(db *sqlx.DB, table string, tableSchema string) {
 }
 
-func (b *back) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string) error {
+func (b *back) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return nil
+}
+
+func (b *back) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	return nil
+}
+
+func (b *back) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...backends.ExtraField) error {
 	return nil
 }
 
@@ -35,66 +55,3996 @@ func (b *back) HasMigrationTable() (bool, error) {
 	return false, nil
 }
 
-func (b *back) QueryPrevious() (map[string]string, error) {
-	return make(map[string]string), nil
-}
+func (b *back) HasMigrationTableContext(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+func (b *back) QueryPrevious() (map[string]string, error) {
+	return make(map[string]string), nil
+}
+
+func (b *back) QueryPreviousContext(ctx context.Context) (map[string]string, error) {
+	return make(map[string]string), nil
+}
+
+func (b *back) CreateMigrationTable() (string, error) {
+	return "", nil
+}
+
+func (b *back) CreateMigrationTableContext(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (b *back) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
+	return nil
+}
+
+func (b *back) OverrideColumns(columns map[string]string) {
+}
+
+func (b *back) ExtendColumns(columns []backends.RecordColumn) {
+}
+
+func (b *back) AddColumns(ctx context.Context, columns []backends.RecordColumn) (string, error) {
+	return "", nil
+}
+
+func (b *back) MigrateMigrationTable() error {
+	return nil
+}
+
+func (b *back) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (b *back) ListTables() ([]string, error) {
+	return []string{}, nil
+}
+
+func (b *back) QueryRecords(q sqlx.Queryer) ([]backends.MigrationRecord, error) {
+	return []backends.MigrationRecord{}, nil
+}
+
+func (b *back) CountRecords(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (b *back) QueryChecksum() (string, error) {
+	return "", nil
+}
+
+func (b *back) StoreChecksum(tx *sqlx.Tx, checksum string) error {
+	return nil
+}
+
+func (b *back) DeleteRecord(tx *sqlx.Tx, name string) error {
+	return nil
+}
+
+func (b *back) UpdateComment(tx *sqlx.Tx, name string, comment string) error {
+	return nil
+}
+
+func (b *back) CleanTable() error {
+	return nil
+}
+
+func (b *back) ArchiveRecords(tx *sqlx.Tx, before time.Time) error {
+	return nil
+}
+
+func (b *back) Lock(ctx context.Context, timeout time.Duration) error {
+	return nil
+}
+
+func (b *back) Unlock(ctx context.Context) error {
+	return nil
+}
+
+type testDBMS struct {
+	title       string
+	name        string
+	tableSchema string
+}
+
+// Unit Tests ------------------------------------------------------------------
+
+func TestBackendType(t *testing.T) {
+	// 0: backend, 1: driver
+	drivers := [][2]string{
+		{"postgres", "postgres"},
+		{"cockroach", "cockroach"},
+		{"cockroach", "cockroachdb"},
+		{"duckdb", "duckdb"},
+		{"mysql", "mysql"},
+		{"mariadb", "maria"},
+		{"sqlite", "sqlite3"},
+		{"oracle", "godror"},
+		{"sqlserver", "sqlserver"},
+		{"libsql", "turso"},
+		{"tidb", "tidb"},
+		{"planetscale", "mysql+planetscale"},
+		{"yugabyte", "yugabytedb"},
+	}
+
+	t.Run("KnownBackends", func(t *testing.T) {
+		for i, d := range drivers {
+			backend := BackendType(d[1])
+			if backend != d[0] {
+				t.Errorf("backend type #%d incorrect expected '%s', actuall '%s'", i, d[0], backend)
+			}
+		}
+	})
+	t.Run("UnknownBackend", func(t *testing.T) {
+		backend := BackendType("not-a-real-driver")
+		if backend != "unknown" {
+			t.Error("backend should return 'unknown'")
+		}
+	})
+}
+
+func TestRegisterBackend(t *testing.T) {
+	t.Run("ExistingBackend", func(t *testing.T) {
+		err := RegisterBackend("postgres", &back{})
+		if err == nil {
+			t.Error("backend exists: an error should be returned")
+		}
+	})
+	t.Run("NewBackend", func(t *testing.T) {
+		err := RegisterBackend("mydb", &back{})
+		if err != nil {
+			t.Error("backend does not exist: an error should not be returned")
+		}
+	})
+}
+
+func TestRegisterBackendConcurrently(t *testing.T) {
+	migrators := make([]*Migrator, 8)
+	for i := range migrators {
+		migrators[i], _ = newTestMigrator(t)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(migrators))
+	for i, m := range migrators {
+		wg.Add(1)
+		go func(i int, m *Migrator) {
+			defer wg.Done()
+			err := m.UseBackend("concurrent_db_missing")
+			if !errors.Is(err, ErrBackendNotFound) {
+				errs <- fmt.Errorf("expected ErrBackendNotFound, got: %v", err)
+			}
+			key := fmt.Sprintf("concurrent_db_%d", i)
+			err = RegisterBackend(key, &backends.SQLite{})
+			if err == nil {
+				err = m.UseBackend(key)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}(i, m)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestOverrideBackend(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := RegisterBackend("override_db", &backends.SQLite{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &backends.SQLite{}
+	OverrideBackend("override_db", b)
+	err = m.UseBackend("override_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.backend != b {
+		t.Error("the backend should be replaced")
+	}
+}
+
+func TestRegisterBackendFactory(t *testing.T) {
+	err := RegisterBackendFactory("factory_db", func() backends.Backend {
+		return &backends.SQLite{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterBackendFactory("factory_db", func() backends.Backend {
+		return &backends.SQLite{}
+	})
+	if err == nil {
+		t.Error("backend exists: an error should be returned")
+	}
+	m1, _ := newTestMigrator(t)
+	m2, _ := newTestMigrator(t)
+	for _, m := range []*Migrator{m1, m2} {
+		err = m.UseBackend("factory_db")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m1.baseBackend == m2.baseBackend {
+		t.Error("the factory should be called for each Migrator")
+	}
+}
+
+func TestNewBuiltInBackends(t *testing.T) {
+	sqlite, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+
+	// New only needs the driver name to pick the backend.
+	drivers := []string{"mysql", "mariadb", "postgres", "sqlite", "sqlserver", "godror"}
+	for _, d := range drivers {
+		_, err := New(sqlx.NewDb(sqlite.DB, d), "migrations", "")
+		if err != nil {
+			t.Errorf("driver '%s' should have a backend: %s", d, err)
+		}
+	}
+}
+
+func TestNewFromSQL(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m, err := NewFromSQL(db, "sqlite", WithTableName("schema_changes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	exists, err := m.backend.HasMigrationTable()
+	if err != nil || !exists {
+		t.Errorf("migration table 'schema_changes' should exist: %v", err)
+	}
+}
+
+func TestHashFunc(t *testing.T) {
+	t.Run("SHA256", func(t *testing.T) {
+		h := SHA256Hash("SELECT 1;", nil)
+		if h != "17db4fd369edb9244b9f91d9aeed145c3d04ad8ba6e95d06247f07a63527d11a" {
+			t.Errorf("SHA-256 hash incorrect: got '%s'", h)
+		}
+	})
+	t.Run("MD5Legacy", func(t *testing.T) {
+		// Earlier versions hashed the statement followed by the args slice.
+		h := MD5Hash("SELECT 1;", nil)
+		if h != "a93a5fc2a0893beaf6ad11c80f19f805" {
+			t.Errorf("MD5 hash incorrect: got '%s'", h)
+		}
+	})
+}
+
+func TestHashNormalization(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	WithHashNormalization()(m)
+	a := m.hashQuery("CREATE TABLE users (\n\tname TEXT DEFAULT 'Jane  Doe'\n);", nil)
+	b := m.hashQuery("  create table users ( name text default 'Jane  Doe' );", nil)
+	c := m.hashQuery("create table users ( name text default 'jane doe' );", nil)
+	if a != b {
+		t.Errorf("formatting should not change the hash: '%s' != '%s'", a, b)
+	}
+	if a == c {
+		t.Error("quoted strings should not be normalized")
+	}
+}
+
+func TestNormalizeStatement(t *testing.T) {
+	tests := map[string]string{
+		"  CREATE TABLE users (\n\tid INT\n);  ":                "create table users ( id int );",
+		"SELECT 1; -- the answer\nSELECT 2;":                    "select 1; select 2;",
+		"SELECT /* the\nanswer */ 1;":                           "select 1;",
+		"SELECT '-- not /* a */ comment', \"Name\" FROM users;": "select '-- not /* a */ comment', \"Name\" from users;",
+	}
+	for stmt, expected := range tests {
+		if got := NormalizeStatement(stmt); got != expected {
+			t.Errorf("normalized statement incorrect: expected '%s', got '%s'", expected, got)
+		}
+	}
+	stmts := NormalizeStatements([]string{"SELECT  1;", "SELECT\n2;"})
+	if !reflect.DeepEqual(stmts, []string{"select 1;", "select 2;"}) {
+		t.Errorf("normalized statements incorrect: %v", stmts)
+	}
+
+	// Normalizing by hand gives the same hash as WithHashNormalization.
+	m, _ := newTestMigrator(t)
+	stmt := "CREATE TABLE users (\n\tid INT -- primary key\n);"
+	manual := m.hashQuery(NormalizeStatement(stmt), nil)
+	WithHashNormalization()(m)
+	if m.hashQuery(stmt, nil) != manual {
+		t.Error("hash normalization should match NormalizeStatement")
+	}
+}
+
+func TestGlobalFingerprint(t *testing.T) {
+	a, _ := newTestMigrator(t)
+	b, _ := newTestMigrator(t)
+	for _, m := range []*Migrator{a, b} {
+		err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.GlobalFingerprint() != b.GlobalFingerprint() {
+		t.Error("the same migrations should have the same fingerprint")
+	}
+
+	err := b.AddMigration("create_tag_table", "", `CREATE TABLE tags (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.GlobalFingerprint() == b.GlobalFingerprint() {
+		t.Error("different migrations should have different fingerprints")
+	}
+	b.UseHashFunc(MD5Hash)
+	if len(b.GlobalFingerprint()) != 32 {
+		t.Errorf("fingerprint should use the hash func: '%s'", b.GlobalFingerprint())
+	}
+}
+
+func TestDetectHashCollision(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	_, err := m.DetectStoredHashCollision()
+	if !errors.Is(err, ErrMigrationTableNotFound) {
+		t.Errorf("expected ErrMigrationTableNotFound, got: %v", err)
+	}
+
+	for _, name := range []string{"create_users_table", "create_posts_table", "create_users_again"} {
+		table := "users"
+		if name == "create_posts_table" {
+			table = "posts"
+		}
+		err := m.AddMigration(name, "", `CREATE TABLE IF NOT EXISTS `+table+` (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []HashCollision{{
+		Hash:  m.migrations[0].hash,
+		Names: []string{"create_users_table", "create_users_again"},
+	}}
+	collisions := m.DetectHashCollision()
+	if !reflect.DeepEqual(collisions, want) {
+		t.Errorf("hash collisions incorrect: %+v", collisions)
+	}
+
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	collisions, err = m.DetectStoredHashCollision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(collisions, want) {
+		t.Errorf("stored hash collisions incorrect: %+v", collisions)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	t.Run("Defaults", func(t *testing.T) {
+		m, err := New(db, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.TableName != DefaultTableName {
+			t.Errorf("default table name incorrect: got '%s'", m.TableName)
+		}
+	})
+	t.Run("Options", func(t *testing.T) {
+		m, err := New(db, "migrations", "", WithTableName("schema_changes"), WithHashFunc(MD5Hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.TableName != "schema_changes" {
+			t.Errorf("option should override table name: got '%s'", m.TableName)
+		}
+		err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.migrations[0].hash) != 32 {
+			t.Errorf("hash func option not applied: got '%s'", m.migrations[0].hash)
+		}
+	})
+	t.Run("NewWithOptions", func(t *testing.T) {
+		m, err := NewWithOptions(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.TableName != DefaultTableName || m.tableSchema != "" {
+			t.Errorf("default table incorrect: got '%s.%s'", m.tableSchema, m.TableName)
+		}
+		m, err = NewWithOptions(db, WithTableName("main.schema_changes"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.TableName != "schema_changes" || m.tableSchema != "main" {
+			t.Errorf("table option not applied: got '%s.%s'", m.tableSchema, m.TableName)
+		}
+	})
+}
+
+func TestParseTableName(t *testing.T) {
+	for qualified, want := range map[string][2]string{
+		"schema_changes":            {"", "schema_changes"},
+		"migrations.schema_changes": {"migrations", "schema_changes"},
+	} {
+		schema, table := ParseTableName(qualified)
+		if schema != want[0] || table != want[1] {
+			t.Errorf("ParseTableName(%q) = %q, %q", qualified, schema, table)
+		}
+	}
+
+	// The main database of SQLite can be used as the schema.
+	_, db := newTestMigrator(t)
+	_, err := New(db, "main.schema_changes", "temp")
+	if err == nil {
+		t.Error("expected an error for a schema that doesn't match the table schema")
+	}
+	m, err := New(db, "main.schema_changes", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TableName != "schema_changes" || m.tableSchema != "main" {
+		t.Errorf("table name incorrect: '%s' '%s'", m.tableSchema, m.TableName)
+	}
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM main.schema_changes`)
+	if err != nil || count != 1 {
+		t.Errorf("migration table should be in the main database: %d %v", count, err)
+	}
+	exists, err := m.backend.HasMigrationTable()
+	if err != nil || !exists {
+		t.Errorf("migration table should exist: %v", err)
+	}
+}
+
+// newTestMigrator returns a Migrator backed by a temporary SQLite DB for unit
+// tests that don't need a real DBMS.
+func newTestMigrator(t *testing.T) (*Migrator, *sqlx.DB) {
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	m, err := New(db, "migrations", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &m, db
+}
+
+func TestAddMigrationFromGlob(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"002_add_username.sql": "ALTER TABLE users ADD username TEXT;",
+		"001_create_users.sql": "-- Add the user table\nCREATE TABLE users (id INT);",
+		"notes.txt":            "not a migration",
+	}
+	for name, body := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("MatchedFiles", func(t *testing.T) {
+		m, _ := newTestMigrator(t)
+		err := m.AddMigrationFromGlob(filepath.Join(dir, "*.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.migrations) != 2 {
+			t.Fatalf("migration count incorrect: expected '2', got '%d'", len(m.migrations))
+		}
+		if m.migrations[0].Name != "001_create_users" {
+			t.Errorf("migrations not sorted: got '%s' first", m.migrations[0].Name)
+		}
+		if m.migrations[0].Comment != "Add the user table" {
+			t.Errorf("comment not parsed: got '%s'", m.migrations[0].Comment)
+		}
+	})
+	t.Run("NoMatches", func(t *testing.T) {
+		m, _ := newTestMigrator(t)
+		err := m.AddMigrationFromGlob(filepath.Join(dir, "*.yaml"))
+		if _, ok := err.(ErrNoMigrationsFound); !ok {
+			t.Errorf("expected ErrNoMigrationsFound, got '%v'", err)
+		}
+	})
+	t.Run("Duplicate", func(t *testing.T) {
+		m, _ := newTestMigrator(t)
+		err := m.AddMigration("002_add_username", "", "ALTER TABLE users ADD username TEXT;")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.AddMigrationFromGlob(filepath.Join(dir, "*.sql"))
+		if !errors.Is(err, ErrDuplicateMigration) {
+			t.Fatalf("expected ErrDuplicateMigration, got '%v'", err)
+		}
+		if len(m.migrations) != 1 {
+			t.Errorf("no files should be added when one is a duplicate: %d migrations", len(m.migrations))
+		}
+	})
+}
+
+func TestAddMigrationsFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/002_create_posts.sql": {Data: []byte("CREATE TABLE posts (id INT);")},
+		"migrations/001_create_users.sql": {Data: []byte("-- Add users\nCREATE TABLE users (id INT);")},
+		"migrations/README.md":            {Data: []byte("# Migrations")},
+	}
+	m, _ := newTestMigrator(t)
+	err := m.AddMigrationsFromFS(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.migrations) != 2 || m.migrations[0].Name != "create_users" || m.migrations[1].Name != "create_posts" {
+		t.Errorf("migrations incorrect: %v", m.migrations)
+	}
+	if m.migrations[0].Comment != "Add users" {
+		t.Errorf("comment not parsed: got '%s'", m.migrations[0].Comment)
+	}
+
+	err = m.AddMigrationsFromFS(fstest.MapFS{
+		"more/003_create_users.sql": {Data: []byte("CREATE TABLE users (id BIGINT);")},
+	}, "more")
+	if !errors.Is(err, ErrDuplicateMigration) {
+		t.Errorf("expected ErrDuplicateMigration, got '%v'", err)
+	}
+
+	err = m.AddMigrationsFromFS(fsys, "empty")
+	if _, ok := err.(ErrNoMigrationsFound); !ok {
+		t.Errorf("expected ErrNoMigrationsFound, got '%v'", err)
+	}
+}
+
+func TestMigrateSections(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_users.sql": {Data: []byte(`-- Add the users
+-- table.
+-- +migrate Up
+CREATE TABLE users (id INT);
+
+-- +migrate Down
+DROP TABLE users;
+`)},
+		"migrations/002_create_posts.sql": {Data: []byte("CREATE TABLE posts (id INT);")},
+	}
+	m, _ := newTestMigrator(t)
+	err := m.AddMigrationsFromFS(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, posts := m.migrations[0], m.migrations[1]
+	if users.Comment != "Add the users table." {
+		t.Errorf("comment incorrect: got '%s'", users.Comment)
+	}
+	if users.Statement != "CREATE TABLE users (id INT);" || users.RollbackStatement != "DROP TABLE users;" {
+		t.Errorf("sections incorrect: up '%s' down '%s'", users.Statement, users.RollbackStatement)
+	}
+	if posts.Statement != "CREATE TABLE posts (id INT);" || posts.RollbackStatement != "" {
+		t.Errorf("file without delimiters should be up only: %+v", posts)
+	}
+}
+
+func TestAddMigrationsFromYAML(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigrationsFromYAML(strings.NewReader(`
+name: create_users_table
+statement: CREATE TABLE users (id INT);
+rollback: DROP TABLE users;
+tags: [users]
+environment: production
+---
+- name: create_posts_table
+  comment: Add the posts table.
+  statement: CREATE TABLE posts (id INT);
+- name: create_tags_table
+  statement: CREATE TABLE tags (id INT);
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.migrations) != 3 {
+		t.Fatalf("migration count incorrect: expected '3', got '%d'", len(m.migrations))
+	}
+	users, posts := m.migrations[0], m.migrations[1]
+	if users.RollbackStatement != "DROP TABLE users;" || users.Environment != "production" || len(users.Tags) != 1 {
+		t.Errorf("single migration incorrect: %+v", users)
+	}
+	if posts.Name != "create_posts_table" || posts.Comment != "Add the posts table." {
+		t.Errorf("migration list incorrect: %+v", posts)
+	}
+
+	err = m.AddMigrationsFromYAML(strings.NewReader("name: bad\nstatement: [unclosed\n"))
+	if err == nil || !strings.Contains(err.Error(), "line") {
+		t.Errorf("expected a line numbered error, got '%v'", err)
+	}
+	err = m.AddMigrationsFromYAML(strings.NewReader("- name: no_statement\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected a line numbered error, got '%v'", err)
+	}
+}
+
+// txManager records the calls to each TransactionManager method.
+type txManager struct {
+	DefaultTransactionManager
+	calls []string
+}
+
+func (tm *txManager) Begin(ctx context.Context, db *sqlx.DB) (*sqlx.Tx, error) {
+	tm.calls = append(tm.calls, "begin")
+	return tm.DefaultTransactionManager.Begin(ctx, db)
+}
+
+func (tm *txManager) Commit(tx *sqlx.Tx) error {
+	tm.calls = append(tm.calls, "commit")
+	return tm.DefaultTransactionManager.Commit(tx)
+}
+
+func (tm *txManager) Rollback(tx *sqlx.Tx) error {
+	tm.calls = append(tm.calls, "rollback")
+	return tm.DefaultTransactionManager.Rollback(tx)
+}
+
+func TestWithTransactionManager(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	tm := &txManager{}
+	WithTransactionManager(tm)(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	err = m.AddMigration("bad_migration", "", `CREATE TABLE;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err == nil {
+		t.Fatal("bad migration should fail")
+	}
+	if !reflect.DeepEqual(tm.calls, []string{"begin", "commit", "begin", "rollback"}) {
+		t.Errorf("transaction manager calls incorrect: %v", tm.calls)
+	}
+}
+
+func TestRunCommitError(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithTransactionManager(failCommit{})(m)
+	l, err := m.Run()
+	if err == nil || !strings.Contains(err.Error(), "commit failed") {
+		t.Errorf("the commit error should be returned, got: %v", err)
+	}
+	if l[len(l)-1].Status != ERROR {
+		t.Errorf("the uncommitted migration should be logged as an error: %v", l[len(l)-1])
+	}
+	count := -1
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations;`)
+	if err != nil || count != 0 {
+		t.Errorf("no records should be stored: %d %v", count, err)
+	}
+}
+
+func TestTableSetupError(t *testing.T) {
+	backendErr := errors.New("connection refused")
+	err := errTableSetup("the migration table check failed", backendErr)
+	if !errors.Is(err, ErrMigrationTableSetup) || !errors.Is(err, backendErr) {
+		t.Errorf("the error should match ErrMigrationTableSetup and the backend error: %v", err)
+	}
+	want := "migration table setup failed: the migration table check failed: connection refused"
+	if err.Error() != want {
+		t.Errorf("expected '%s', got '%s'", want, err)
+	}
+}
+
+func TestCaptureNotices(t *testing.T) {
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var notices bytes.Buffer
+	m, err := New(db, "migrations", "", CaptureNotices(&notices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE IF NOT EXISTS users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	// SQLite doesn't send notices, so the option is ignored.
+	if notices.Len() != 0 {
+		t.Errorf("no notices expected, got: %s", notices.String())
+	}
+	if len(m.noticeConns) != 0 {
+		t.Errorf("the transaction connections should be released, got %d", len(m.noticeConns))
+	}
+	if open := db.Stats().InUse; open != 0 {
+		t.Errorf("no connections should be in use, got %d", open)
+	}
+}
+
+func TestAddMigrationNoTx(t *testing.T) {
+	m, db := newTestMigrator(t)
+	tm := &txManager{}
+	WithTransactionManager(tm)(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigrationNoTx("create_user_index", "", `CREATE INDEX users_id ON users (id);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigrationNoTx("bad_migration", "", `CREATE TABLE;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.migrations[1].NoTransaction || m.migrations[0].NoTransaction {
+		t.Fatal("NoTransaction not set by AddMigrationNoTx")
+	}
+
+	_, err = m.RunStrict()
+	if err == nil {
+		t.Fatal("bad migration should fail")
+	}
+	// The run transaction is committed before each no-tx migration, and the
+	// record is inserted in its own transaction.
+	want := []string{"begin", "commit", "begin", "commit", "begin", "commit", "rollback"}
+	if !reflect.DeepEqual(tm.calls, want) {
+		t.Errorf("transaction manager calls incorrect: %v", tm.calls)
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("migrations before the failed no-tx migration should be kept, got %d records", count)
+	}
+}
+
+func TestWithNonTransactional(t *testing.T) {
+	m, db := newTestMigrator(t)
+	logger := &recordLogger{}
+	WithNonTransactional()(m)
+	WithLogger(logger)(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("bad_migration", "", `INSERT INTO users (id) VALUES (1); CREATE TABLE;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.RunStrict()
+	if err == nil {
+		t.Fatal("bad migration should fail")
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("migrations before the failed migration should be kept, got %d records", count)
+	}
+	err = db.Get(&count, `SELECT COUNT(*) FROM users`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("failed migration should not be rolled back, got %d users", count)
+	}
+	if !strings.Contains(strings.Join(logger.lines, "\n"), "without a transaction") {
+		t.Errorf("run should warn about non-transactional mode: %v", logger.lines)
+	}
+}
+
+func TestSavepointPerMigration(t *testing.T) {
+	m, db := newTestMigrator(t)
+	SavepointPerMigration()(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("bad_migration", "", `INSERT INTO users (id) VALUES (1); CREATE TABLE;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.RunStrict()
+	if !errors.Is(err, ErrSafeMode) {
+		t.Fatalf("expected ErrSafeMode, got '%v'", err)
+	}
+
+	log, err := m.Run()
+	if !errors.Is(err, ErrMigrationsFailed) || !strings.Contains(err.Error(), "bad_migration") {
+		t.Fatalf("expected ErrMigrationsFailed for bad_migration, got '%v'", err)
+	}
+	statuses := []LogStatus{SUCCESS, SUCCESS, ERROR, SUCCESS}
+	for i, status := range statuses {
+		if log[i].Status != status {
+			t.Errorf("log %d status is %s, expected %s", i, log[i].Status, status)
+		}
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 migration records, got %d", count)
+	}
+	err = db.Get(&count, `SELECT COUNT(*) FROM users`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("failed migration should be rolled back, got %d users", count)
+	}
+}
+
+func TestWithQueryTimeout(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	WithQueryTimeout(50 * time.Millisecond)(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("slow_migration", "", `CREATE TABLE numbers AS
+		WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n WHERE x < 1000000000)
+		SELECT x FROM n;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	log, err := m.RunStrict()
+	if !errors.Is(err, ErrQueryTimeout) {
+		t.Fatalf("expected ErrQueryTimeout, got: %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("slow migration should be interrupted, took %s", time.Since(start))
+	}
+	if last := log[len(log)-1]; last.Name != "slow_migration" || last.Status != ERROR {
+		t.Errorf("slow migration should fail: %v", last)
+	}
+
+	mysql := &backends.MySQL{}
+	q, err := mysql.TimeoutStatement(context.Background(), nil, "-- Count users\nSELECT COUNT(*) FROM users;", 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q != "-- Count users\nSELECT /*+ MAX_EXECUTION_TIME(2000) */ COUNT(*) FROM users;" {
+		t.Errorf("hint should follow the first keyword: %q", q)
+	}
+}
+
+func TestOnMigrationError(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("bad_migration", "", `CREATE TABLE;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := make([]string, 0)
+	m.OnMigrationError(func(l MigrationLog, err error) error {
+		failed = append(failed, l.Name)
+		return nil
+	})
+	log, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if !reflect.DeepEqual(failed, []string{"bad_migration"}) {
+		t.Errorf("OnMigrationError calls incorrect: %v", failed)
+	}
+	if log[2].Status != ERROR || log[3].Status != SUCCESS {
+		t.Errorf("migration log incorrect: %+v", log)
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 migration records, got %d", count)
+	}
+
+	// Returning an error stops the run and rolls it back.
+	alert := errors.New("alert sent")
+	err = m.AddMigration("create_tag_table", "", `CREATE TABLE tags (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.OnMigrationError(func(l MigrationLog, err error) error {
+		return alert
+	})
+	_, err = m.RunStrict()
+	if !errors.Is(err, alert) {
+		t.Fatalf("expected the OnMigrationError error, got '%v'", err)
+	}
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("run should be rolled back, got %d migration records", count)
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := make([]string, 0)
+	m.OnBeforeRun(func(ctx context.Context, pending int) error {
+		calls = append(calls, fmt.Sprintf("before 1: %d", pending))
+		return nil
+	})
+	m.OnBeforeRun(func(ctx context.Context, pending int) error {
+		calls = append(calls, "before 2")
+		return nil
+	})
+	m.OnAfterRun(func(ctx context.Context, log []MigrationLog, err error) {
+		calls = append(calls, fmt.Sprintf("after: %d %v", len(log), err))
+	})
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	want := []string{"before 1: 1", "before 2", "after: 2 <nil>"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hook calls incorrect: %v", calls)
+	}
+
+	// A failing before hook aborts the run before the DB is used.
+	m, _ = newTestMigrator(t)
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hookErr := errors.New("deploy frozen")
+	var afterErr error
+	m.OnBeforeRun(func(ctx context.Context, pending int) error {
+		return hookErr
+	})
+	m.OnAfterRun(func(ctx context.Context, log []MigrationLog, err error) {
+		afterErr = err
+	})
+	_, err = m.RunStrict()
+	if !errors.Is(err, hookErr) || !errors.Is(afterErr, hookErr) {
+		t.Errorf("expected the before hook error from Run and the after hook, got '%v' and '%v'", err, afterErr)
+	}
+	exists, err := m.backend.HasMigrationTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("aborted run should not create the migration table")
+	}
+}
+
+func TestWithCustomTransactionBegin(t *testing.T) {
+	m, db := newTestMigrator(t)
+	calls := 0
+	m.WithCustomTransactionBegin(func(ctx context.Context) (*sqlx.Tx, error) {
+		calls++
+		return db.BeginTxx(ctx, nil)
+	})
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if calls != 1 {
+		t.Errorf("custom begin should be called once, called '%d' times", calls)
+	}
+}
+
+func TestWithColumnOverride(t *testing.T) {
+	m, db := newTestMigrator(t)
+	m.WithColumnOverride("comment", "TEXT DEFAULT 'none' NOT NULL")
+
+	_, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	ddl := ""
+	err = db.Get(&ddl, `SELECT sql FROM sqlite_master WHERE name = 'migrations';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ddl, "comment TEXT DEFAULT 'none' NOT NULL") {
+		t.Errorf("column override not applied: %s", ddl)
+	}
+}
+
+func TestBatchAddMigration(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batches := map[string][]MigrationDef{
+		"Existing": {
+			{Name: "create_post_table", Statement: `CREATE TABLE posts (id INT);`},
+			{Name: "create_user_table", Statement: `CREATE TABLE users (id INT);`},
+		},
+		"Batch": {
+			{Name: "create_post_table", Statement: `CREATE TABLE posts (id INT);`},
+			{Name: "create_post_table", Statement: `CREATE TABLE posts (id INT);`},
+		},
+	}
+	for name, batch := range batches {
+		t.Run(name, func(t *testing.T) {
+			err := m.BatchAddMigration(batch)
+			if !errors.Is(err, ErrDuplicateMigration) {
+				t.Errorf("duplicate should return ErrDuplicateMigration: %v", err)
+			}
+			if len(m.migrations) != 1 {
+				t.Errorf("no migrations should be added from a failed batch: %d", len(m.migrations))
+			}
+		})
+	}
+
+	err = m.BatchAddMigration([]MigrationDef{
+		{Name: "create_post_table", Statement: `CREATE TABLE posts (id INT);`},
+		{Name: "add_admin", Statement: `INSERT INTO users (id) VALUES (?);`, Args: []interface{}{1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.migrations) != 3 || m.migrations[2].Name != "add_admin" || len(m.migrations[2].args) != 1 {
+		t.Errorf("batch not added in order: %+v", m.migrations)
+	}
+}
+
+// errReader is an io.Reader that always fails.
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestGetMigration(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "Add the user table", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("add_user", "", `INSERT INTO users (id) VALUES (?);`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mig, ok := m.GetMigration("add_user")
+	if !ok {
+		t.Fatal("migration should be found")
+	}
+	if mig.Name != "add_user" || mig.Hash() != m.hashQuery(mig.Statement, []interface{}{1}) || !reflect.DeepEqual(mig.Args(), []interface{}{1}) {
+		t.Errorf("migration incorrect: %+v", mig)
+	}
+	_, ok = m.GetMigration("not_added")
+	if ok {
+		t.Error("migration that has not been added should not be found")
+	}
+
+	all := m.AllMigrations()
+	if len(all) != 2 || all[0].Name != "create_user_table" || all[0].Comment != "Add the user table" {
+		t.Errorf("migrations incorrect: %+v", all)
+	}
+	all[0].Name = "changed"
+	if m.migrations[0].Name != "create_user_table" {
+		t.Error("AllMigrations should return a copy")
+	}
+}
+
+func TestAddFromReader(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddFromReader("create_user_table", "Add the user table", strings.NewReader(`CREATE TABLE users (id INT);`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mig := m.migrations[0]
+	if mig.Statement != `CREATE TABLE users (id INT);` || mig.Comment != "Add the user table" {
+		t.Errorf("migration incorrect: %+v", mig)
+	}
+	if mig.hash != m.hashQuery(mig.Statement, nil) {
+		t.Error("migration hash incorrect")
+	}
+
+	err = m.AddFromReader("create_post_table", "", errReader{})
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("expected the read error, got '%v'", err)
+	}
+	if len(m.migrations) != 1 {
+		t.Error("migration should not be added when the read fails")
+	}
+}
+
+func TestRecursiveLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/users/001_create_users.sql":          {Data: []byte(`CREATE TABLE users (id INT);`)},
+		"migrations/billing/001_create_invoices.sql":     {Data: []byte(`CREATE TABLE invoices (id INT);`)},
+		"migrations/billing/tax/001_create_tax_rate.sql": {Data: []byte(`CREATE TABLE tax_rates (id INT);`)},
+		"migrations/000_init.sql":                        {Data: []byte(`CREATE TABLE init (id INT);`)},
+		"migrations/README.md":                           {Data: []byte(`not a migration`)},
+	}
+
+	m, _ := newTestMigrator(t)
+	err := m.RecursiveLoad(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(m.migrations))
+	for i, mig := range m.migrations {
+		names[i] = mig.Name
+	}
+	want := []string{"000_init", "billing/001_create_invoices", "billing/tax/001_create_tax_rate", "users/001_create_users"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("migrations loaded incorrectly: %v", names)
+	}
+
+	m, _ = newTestMigrator(t)
+	err = m.RecursiveLoad(fsys, "migrations", MaxDepth(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.migrations) != 3 {
+		t.Errorf("MaxDepth(1) should skip nested directories, got %d migrations", len(m.migrations))
+	}
+
+	// Conflicts are collected and the other migrations are still added.
+	m, _ = newTestMigrator(t)
+	for _, name := range []string{"000_init", "users/001_create_users"} {
+		err = m.AddMigration(name, "", `SELECT 1;`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = m.RecursiveLoad(fsys, "migrations")
+	var errs MigrationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || !errors.Is(err, ErrDuplicateMigration) {
+		t.Fatalf("expected 2 duplicate migration errors, got '%v'", err)
+	}
+	if len(m.migrations) != 4 {
+		t.Errorf("expected the migrations without conflicts to be added, got %d", len(m.migrations))
+	}
+
+	err = m.RecursiveLoad(fsys, "migrations/users/none")
+	if err == nil {
+		t.Error("missing root should return an error")
+	}
+}
+
+func TestAddFromSource(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create_users.sql": {Data: []byte("-- Add users.\n-- +migrate Up\nCREATE TABLE users (id INT);\n-- +migrate Down\nDROP TABLE users;")},
+	}
+	sources := map[string]MigrationSource{
+		"FS":     FSSource(fsys, "migrations"),
+		"YAML":   YAMLSource(strings.NewReader("- name: create_users\n  statement: CREATE TABLE users (id INT);\n  rollback: DROP TABLE users;")),
+		"JSON":   JSONSource(strings.NewReader(`[{"name": "create_users", "statement": "CREATE TABLE users (id INT);", "rollback": "DROP TABLE users;"}]`)),
+		"Memory": MemorySource([]MigrationDef{{Name: "create_users", Statement: `CREATE TABLE users (id INT);`, Rollback: `DROP TABLE users;`}}),
+	}
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			m, _ := newTestMigrator(t)
+			err := m.AddFromSource(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.migrations) != 1 || m.migrations[0].Name != "create_users" || m.migrations[0].RollbackStatement != "DROP TABLE users;" {
+				t.Errorf("migrations not loaded from source: %+v", m.migrations)
+			}
+		})
+	}
+}
+
+func TestLazyLoad(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	LazyLoad()(m)
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "001_create_users.sql"), []byte(`CREATE TABLE users (id INT);`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddFromSource(DirSource(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.migrations) != 0 {
+		t.Errorf("lazy source should not be loaded until run: %+v", m.migrations)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	// A new file is picked up on the next run.
+	err = os.WriteFile(filepath.Join(dir, "002_create_posts.sql"), []byte(`CREATE TABLE posts (id INT);`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if len(m.migrations) != 2 || m.migrations[1].Name != "create_posts" {
+		t.Errorf("new migration not loaded: %+v", m.migrations)
+	}
+
+	// A changed file is an error.
+	err = os.WriteFile(filepath.Join(dir, "001_create_users.sql"), []byte(`CREATE TABLE users (id BIGINT);`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if !errors.Is(err, ErrDuplicateMigration) {
+		t.Errorf("changed migration should return ErrDuplicateMigration: %v", err)
+	}
+}
+
+func TestLazyLoadInspect(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	LazyLoad()(m)
+	err := m.AddFromSource(MemorySource([]MigrationDef{{Name: "create_users", Statement: `CREATE TABLE users (id INT);`}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := m.PendingCount()
+	if err != nil || n != 1 {
+		t.Errorf("pending count should load the source: %d %v", n, err)
+	}
+	status, err := m.Status()
+	if err != nil || len(status) != 1 {
+		t.Errorf("status should load the source: %v %v", status, err)
+	}
+	plan, err := m.Plan()
+	if err != nil || len(plan.Pending) != 1 {
+		t.Errorf("plan should load the source: %+v %v", plan, err)
+	}
+}
+
+func TestAddFromSourceNotLazy(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddFromSource(MemorySource([]MigrationDef{{Name: "create_users", Statement: `CREATE TABLE users (id INT);`}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.sources) != 0 {
+		t.Errorf("a source that is loaded when added should not be kept: %d", len(m.sources))
+	}
+	if len(m.migrations) != 1 {
+		t.Errorf("source not loaded: %+v", m.migrations)
+	}
+}
+
+func TestAddMigrationIfNotExists(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	stmt := `CREATE TABLE users (id INT);`
+	err := m.AddMigrationIfNotExists("create_user_table", "", stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("SameHash", func(t *testing.T) {
+		err := m.AddMigrationIfNotExists("create_user_table", "", stmt)
+		if err != nil {
+			t.Errorf("identical migration should not return an error: %s", err)
+		}
+	})
+	t.Run("DifferentHash", func(t *testing.T) {
+		err := m.AddMigrationIfNotExists("create_user_table", "", `CREATE TABLE users (id BIGINT);`)
+		if !errors.Is(err, ErrDuplicateMigration) {
+			t.Errorf("expected ErrDuplicateMigration, got '%v'", err)
+		}
+	})
+	t.Run("StrictSameHash", func(t *testing.T) {
+		m.WithStrictDuplicateCheck(true)
+		err := m.AddMigrationIfNotExists("create_user_table", "", stmt)
+		if !errors.Is(err, ErrDuplicateMigration) {
+			t.Errorf("expected ErrDuplicateMigration, got '%v'", err)
+		}
+	})
+	if len(m.migrations) != 1 {
+		t.Errorf("migration count incorrect: expected '1', got '%d'", len(m.migrations))
+	}
+}
+
+func TestWithCommitHook(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.WithCommitHook(func(tx *sqlx.Tx, appliedNames []string) error {
+		if len(appliedNames) != 1 || appliedNames[0] != "create_user_table" {
+			t.Errorf("applied names incorrect: got '%v'", appliedNames)
+		}
+		return errors.New("abort")
+	})
+
+	_, err = m.RunStrict()
+	if err == nil {
+		t.Fatal("commit hook error should be returned")
+	}
+
+	count := 0
+	err = db.Get(&count, `SELECT count(name) FROM sqlite_master WHERE name = 'users';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("migrations should be rolled back when the commit hook fails")
+	}
+}
+
+func TestWithMigrationTableChecksum(t *testing.T) {
+	m, db := newTestMigrator(t)
+	m.WithMigrationTableChecksum(true)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		_, err = m.RunStrict()
+		if err != nil {
+			t.Errorf("migrator run error: %s", err)
+		}
+	})
+	t.Run("Tampered", func(t *testing.T) {
+		_, err = db.Exec(`UPDATE migrations SET comment = 'edited';`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.RunStrict()
+		if !errors.Is(err, ErrMigrationTableTampered) {
+			t.Errorf("expected ErrMigrationTableTampered, got '%v'", err)
+		}
+	})
+}
+
+func TestProgressBar(t *testing.T) {
+	t.Run("Bar", func(t *testing.T) {
+		var b strings.Builder
+		progressBar{w: &b}.update(5, 10, "create_users")
+		if !strings.Contains(b.String(), "[=========>          ] 5/10 migrations create_users") {
+			t.Errorf("progress bar incorrect: %q", b.String())
+		}
+	})
+	t.Run("Fallback", func(t *testing.T) {
+		var b strings.Builder
+		progressBar{w: &b, fallback: true}.update(5, 10, "create_users")
+		if b.String() != "Running migration 5/10: create_users...\n" {
+			t.Errorf("progress fallback incorrect: %q", b.String())
+		}
+	})
+}
+
+func TestRunContext(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = m.RunStrictContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got '%v'", err)
+	}
+}
+
+func TestMultiplexedMigrator(t *testing.T) {
+	mm := NewMultiplexedMigrator(2)
+	dbs := make(map[string]*sqlx.DB)
+	for _, id := range []string{"shard_1", "shard_2", "shard_3"} {
+		db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), id+".sqlite"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		dbs[id] = db
+		m, err := New(db, "migrations", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := m.backend
+		err = mm.AddShard(id, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.backend != b {
+			t.Errorf("the backend of %s should not be changed", id)
+		}
+	}
+	err := mm.AddShard("shard_1", &Migrator{})
+	if err == nil {
+		t.Error("duplicate shard error expected")
+	}
+	// The migration fails on the second shard.
+	_, err = dbs["shard_2"].Exec(`CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := mm.RunAll(context.Background())
+	var shardErrs ShardErrors
+	if !errors.As(err, &shardErrs) || len(shardErrs) != 1 || shardErrs[0].Shard != "shard_2" {
+		t.Fatalf("expected the error of shard_2, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "shard 'shard_2'") {
+		t.Errorf("the shard should be in the error: %s", err)
+	}
+	for _, id := range []string{"shard_1", "shard_3"} {
+		var count int
+		err = dbs[id].Get(&count, `SELECT COUNT(*) FROM migrations`)
+		if err != nil {
+			t.Fatalf("%s should be migrated: %s", id, err)
+		}
+		if count != 1 {
+			t.Errorf("%s should have 1 record, got %d", id, count)
+		}
+		if last := logs[id][len(logs[id])-1]; last.Status != SUCCESS {
+			t.Errorf("%s should be migrated: %v", id, logs[id])
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	m, db := newTestMigrator(t)
+	migrations := [][3]string{
+		{"create_user_table", `CREATE TABLE users (id INT);`, `DROP TABLE users;`},
+		{"create_post_table", `CREATE TABLE posts (id INT);`, `DROP TABLE posts;`},
+		{"create_tag_table", `CREATE TABLE tags (id INT);`, `DROP TABLE tags;`},
+	}
+	for _, mig := range migrations {
+		err := m.AddMigrationWithRollback(mig[0], "", mig[1], mig[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	tableCount := func() int {
+		count := 0
+		err := db.Get(&count, `SELECT count(name) FROM sqlite_master WHERE name IN ('users', 'posts', 'tags');`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	t.Run("RollbackLast", func(t *testing.T) {
+		l, err := m.RollbackLast(1)
+		if err != nil {
+			t.Fatalf("rollback error: %s", err)
+		}
+		last := l[len(l)-1]
+		if last.Name != "create_tag_table" || last.Status != ROLLBACK {
+			t.Errorf("rollback log incorrect: %v", last)
+		}
+		if tableCount() != 2 {
+			t.Error("'tags' table should be dropped")
+		}
+	})
+	t.Run("RollbackTo", func(t *testing.T) {
+		_, err := m.RollbackTo("create_user_table")
+		if err != nil {
+			t.Fatalf("rollback error: %s", err)
+		}
+		if tableCount() != 0 {
+			t.Error("all tables should be dropped")
+		}
+		names, err := m.appliedNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 0 {
+			t.Errorf("records should be deleted: %v", names)
+		}
+	})
+	t.Run("NoRollbackStatement", func(t *testing.T) {
+		err := m.AddMigration("create_note_table", "", `CREATE TABLE notes (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.RunStrict()
+		if err != nil {
+			t.Fatalf("migrator run error: %s", err)
+		}
+		_, err = m.RollbackLast(1)
+		if err == nil {
+			t.Error("migration without a rollback statement should return an error")
+		}
+	})
+	t.Run("CanRollback", func(t *testing.T) {
+		ok, missing := m.CanRollback("create_tag_table", "create_note_table", "not_added")
+		if ok || !reflect.DeepEqual(missing, []string{"create_note_table", "not_added"}) {
+			t.Errorf("CanRollback incorrect: %v %v", ok, missing)
+		}
+		ok, missing = m.CanRollback("create_user_table", "create_tag_table")
+		if !ok || len(missing) != 0 {
+			t.Errorf("CanRollback incorrect: %v %v", ok, missing)
+		}
+	})
+	t.Run("Rollback", func(t *testing.T) {
+		_, err := m.Rollback("create_tag_table", "create_note_table")
+		if err == nil {
+			t.Error("migration without a rollback statement should return an error")
+		}
+		if tableCount() != 3 {
+			t.Error("nothing should be rolled back if a migration can't be")
+		}
+
+		l, err := m.Rollback("create_post_table", "create_user_table")
+		if err != nil {
+			t.Fatalf("rollback error: %s", err)
+		}
+		if l[len(l)-2].Name != "create_post_table" || l[len(l)-1].Name != "create_user_table" {
+			t.Errorf("migrations should be rolled back in the order given: %v", l[len(l)-2:])
+		}
+		names, err := m.appliedNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, []string{"create_tag_table", "create_note_table"}) {
+			t.Errorf("only the named migrations should be rolled back: %v", names)
+		}
+
+		_, err = m.Rollback("create_user_table")
+		if err == nil {
+			t.Error("migration that has not been applied should return an error")
+		}
+	})
+}
+
+// failCommit is a TransactionManager that rolls back instead of committing.
+type failCommit struct {
+	DefaultTransactionManager
+}
+
+func (failCommit) Commit(tx *sqlx.Tx) error {
+	tx.Rollback()
+	return errors.New("commit failed")
+}
+
+func TestRollbackCommitError(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigrationWithRollback("create_user_table", "", `CREATE TABLE users (id INT);`, `DROP TABLE users;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Run()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	WithTransactionManager(failCommit{})(m)
+	_, err = m.RollbackLast(1)
+	if err == nil || !strings.Contains(err.Error(), "commit failed") {
+		t.Errorf("the commit error should be returned, got: %v", err)
+	}
+	if m.safe {
+		t.Error("a rollback should not change the mode of the Migrator")
+	}
+}
+
+func TestRollbackHashMismatch(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigrationWithRollback("create_user_table", "", `CREATE TABLE users (id INT);`, `DROP TABLE users;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Run()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	_, err = db.Exec(`UPDATE migrations SET hash = 'changed' WHERE name = 'create_user_table';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rollbacks validate the hashes even after a loose run.
+	_, err = m.RollbackLast(1)
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("expected ErrHashMismatch, got: %v", err)
+	}
+}
+
+func TestWithRollbackOnPanic(t *testing.T) {
+	m, db := newTestMigrator(t)
+	WithRollbackOnPanic()(m)
+	WithStatementPreprocessor(func(ctx context.Context, name string, statement string) (string, error) {
+		if name == "create_posts_table" {
+			panic("preprocess failed")
+		}
+		return statement, nil
+	})(m)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_posts_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "preprocess failed" {
+				t.Errorf("the panic should be raised again, got: %v", r)
+			}
+		}()
+		m.RunStrict()
+	}()
+
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM sqlite_master WHERE name = 'users'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("the migrations before the panic should be rolled back")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := m.Plan()
+	if err != nil {
+		t.Fatalf("plan error: %s", err)
+	}
+	want := MigrationPlan{
+		Pending:        []PendingMigration{{Name: "create_post_table", Hash: m.migrations[1].hash, Index: 1}},
+		Skipped:        []SkippedMigration{},
+		HashMismatches: []HashMismatch{},
+		OrphanRecords:  []string{},
+		TotalApplied:   1,
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("plan incorrect: %+v", plan)
+	}
+
+	names, err := m.appliedNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Errorf("plan should not run migrations: %v", names)
+	}
+
+	t.Run("MismatchesAndOrphans", func(t *testing.T) {
+		m2, err := New(m.db, "migrations", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m2.AddMigration("create_user_table", "", `CREATE TABLE users (id BIGINT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.RunStrict()
+		if err != nil {
+			t.Fatalf("migrator run error: %s", err)
+		}
+
+		plan, err := m2.Plan()
+		if !errors.Is(err, ErrHashMismatch) {
+			t.Errorf("expected ErrHashMismatch, got '%v'", err)
+		}
+		if len(plan.HashMismatches) != 1 || plan.HashMismatches[0].StoredHash != m.migrations[0].hash || plan.HashMismatches[0].ComputedHash != m2.migrations[0].hash {
+			t.Errorf("plan hash mismatches incorrect: %+v", plan.HashMismatches)
+		}
+		if !reflect.DeepEqual(plan.OrphanRecords, []string{"create_post_table"}) || plan.TotalApplied != 2 || len(plan.Pending) != 0 {
+			t.Errorf("plan incorrect: %+v", plan)
+		}
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		m3, _ := newTestMigrator(t)
+		err := m3.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m3.AddConditionalMigration("create_post_table", "", `CREATE TABLE posts (id INT);`, func() bool { return false })
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m3.AddMigration("create_tag_table", "", `CREATE TABLE tags (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m3.SkipMigrations("create_tag_table")
+
+		plan, err := m3.Plan()
+		if err != nil {
+			t.Fatalf("plan error: %s", err)
+		}
+		if len(plan.Pending) != 1 || plan.Pending[0].Name != "create_user_table" {
+			t.Errorf("skipped migrations should not be pending: %+v", plan.Pending)
+		}
+		want := []SkippedMigration{
+			{Name: "create_post_table", Reason: "migration condition not met", Index: 1},
+			{Name: "create_tag_table", Reason: "migration skipped", Index: 2},
+		}
+		if !reflect.DeepEqual(plan.Skipped, want) {
+			t.Errorf("plan skipped incorrect: %+v", plan.Skipped)
+		}
+	})
+}
+
+func TestExplainMigration(t *testing.T) {
+	m, db := newTestMigrator(t)
+	_, err := db.Exec(`CREATE TABLE users (id INT, name TEXT); INSERT INTO users (id, name) VALUES (1, 'admin');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_posts_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("rename_user", "", `UPDATE users SET name = 'root' WHERE id = 1;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("rename_admin", "", `UPDATE userz SET name = 'root' WHERE id = 2;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := m.ExplainMigration(context.Background(), "rename_user")
+	if err != nil {
+		t.Fatalf("explain error: %s", err)
+	}
+	if !strings.Contains(plan, "users") {
+		t.Errorf("expected a plan that scans users, got: %s", plan)
+	}
+	_, err = m.ExplainMigration(context.Background(), "create_posts_table")
+	if !errors.Is(err, ErrNotApplicable) {
+		t.Errorf("expected ErrNotApplicable for DDL, got: %v", err)
+	}
+	_, err = m.ExplainMigration(context.Background(), "rename_admin")
+	if err == nil || !strings.Contains(err.Error(), "userz") {
+		t.Errorf("expected a missing table error, got: %v", err)
+	}
+
+	plans, err := m.ExplainAll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "rename_admin") {
+		t.Errorf("expected the error of rename_admin, got: %v", err)
+	}
+	if _, ok := plans["rename_user"]; !ok || len(plans) != 1 {
+		t.Errorf("expected the plan of rename_user, got: %v", plans)
+	}
+
+	// Nothing was run.
+	var name string
+	err = db.Get(&name, `SELECT name FROM users WHERE id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "admin" {
+		t.Errorf("the migrations should not be run, got the name %s", name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m, db := newTestMigrator(t)
+	_, err := m.Validate()
+	if !errors.Is(err, ErrMigrationTableNotFound) {
+		t.Errorf("expected ErrMigrationTableNotFound, got '%v'", err)
+	}
+	exists, err := m.backend.HasMigrationTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("validate should not create the migration table")
+	}
+
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	l, err := m.Validate()
+	if err != nil || len(l) != 0 {
+		t.Errorf("validate should pass: %v %v", l, err)
+	}
+
+	_, err = db.Exec(`UPDATE migrations SET hash = 'changed';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err = m.Validate()
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("expected ErrHashMismatch, got '%v'", err)
+	}
+	if len(l) != 1 || l[0].Status != ERROR_HASH {
+		t.Errorf("validate log incorrect: %v", l)
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	for _, name := range []string{"users", "posts", "tags"} {
+		err := m.AddMigration("create_"+name+"_table", "", `CREATE TABLE `+name+` (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := m.MigrateTo("create_comments_table")
+	if err == nil {
+		t.Error("expected an error for a missing migration")
+	}
+	exists, err := m.backend.HasMigrationTable()
+	if err != nil || exists {
+		t.Errorf("missing migration should not touch the DB: %v", err)
+	}
+
+	_, err = m.MigrateTo("create_posts_table")
+	if err != nil {
+		t.Fatalf("migrate to error: %s", err)
+	}
+	names, err := m.appliedNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[1] != "create_posts_table" {
+		t.Errorf("applied names incorrect: %v", names)
+	}
+
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	names, err = m.appliedNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 {
+		t.Errorf("run should apply the remaining migrations: %v", names)
+	}
+}
+
+func TestMigrateBetween(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	for _, name := range []string{"users", "posts", "tags", "likes"} {
+		err := m.AddMigration("create_"+name+"_table", "", `CREATE TABLE `+name+` (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range [][2]string{
+		{"create_comments_table", ""},
+		{"", "create_comments_table"},
+		{"create_tags_table", "create_posts_table"},
+	} {
+		_, err := m.MigrateBetween(c[0], c[1])
+		if err == nil {
+			t.Errorf("expected an error migrating between '%s' and '%s'", c[0], c[1])
+		}
+	}
+
+	_, err := m.MigrateBetween("", "create_users_table")
+	if err != nil {
+		t.Fatalf("migrate between error: %s", err)
+	}
+	_, err = m.MigrateBetween("create_posts_table", "create_tags_table")
+	if err != nil {
+		t.Fatalf("migrate between error: %s", err)
+	}
+	names, err := m.appliedNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "create_users_table" || names[1] != "create_tags_table" {
+		t.Errorf("applied names incorrect: %v", names)
+	}
+
+	_, err = m.MigrateBetween("create_tags_table", "")
+	if err != nil {
+		t.Fatalf("migrate between error: %s", err)
+	}
+	names, err = m.appliedNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 || names[2] != "create_likes_table" {
+		t.Errorf("applied names incorrect: %v", names)
+	}
+}
+
+func TestAddConditionalMigration(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	enabled := false
+	err := m.AddConditionalMigration("create_fixture_table", "", `CREATE TABLE fixtures (id INT);`, func() bool {
+		return enabled
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if l[len(l)-1].Status != SKIPPED {
+		t.Errorf("migration should be skipped: %v", l[len(l)-1])
+	}
+	names, err := m.appliedNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("skipped migration should not be recorded: %v", names)
+	}
+
+	enabled = true
+	l, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if l[len(l)-1].Status != SUCCESS {
+		t.Errorf("migration should run: %v", l[len(l)-1])
+	}
+}
+
+func TestSkipMigrations(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.MigrateTo("create_user_table")
+	if err != nil {
+		t.Fatalf("migrate to error: %s", err)
+	}
+	_, err = db.Exec(`UPDATE migrations SET hash = 'changed';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.SkipMigrations("create_user_table", "create_post_table")
+	_, err = m.RunStrict()
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("applied migrations should be validated, got '%v'", err)
+	}
+
+	m.RepairHash("create_user_table")
+	l, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if l[len(l)-1].Status != SKIPPED {
+		t.Errorf("migration should be skipped: %v", l[len(l)-1])
+	}
+	names, err := m.appliedNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Errorf("skipped migration should not be recorded: %v", names)
+	}
+}
+
+func TestRepairAllMismatches(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	oldHash := m.migrations[0].hash
+
+	m2, err := New(db, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m2.AddMigration("create_user_table", "", `CREATE TABLE users (id INTEGER);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m2.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := m2.RepairAllMismatches()
+	if err != nil {
+		t.Fatalf("repair all mismatches error: %s", err)
+	}
+	expected := []HashRepairResult{{Name: "create_user_table", OldHash: oldHash, NewHash: m2.migrations[0].hash}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("repair results incorrect: %+v", results)
+	}
+
+	_, err = m2.RunStrict()
+	if err != nil {
+		t.Fatalf("safe run after repair error: %s", err)
+	}
+}
+
+func TestExportPending(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every migration is pending before the migration table exists.
+	var b strings.Builder
+	err = m.ExportPending(&b)
+	if err != nil {
+		t.Fatalf("export pending error: %s", err)
+	}
+	if b.String() != "-- Migration: create_user_table\nCREATE TABLE users (id INT);\n\n" {
+		t.Errorf("export incorrect: %q", b.String())
+	}
+	exists, _ := m.backend.HasMigrationTable()
+	if exists {
+		t.Error("the migration table should not be created")
+	}
+
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	err = m.AddMigration("create_post_table", "Create the\npost table", "CREATE TABLE posts (id INT)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	err = m.ExportPending(&b)
+	if err != nil {
+		t.Fatalf("export pending error: %s", err)
+	}
+	expected := "-- Migration: create_post_table\n-- Create the\n-- post table\nCREATE TABLE posts (id INT);\n\n"
+	if b.String() != expected {
+		t.Errorf("export incorrect: %q", b.String())
+	}
+	_, err = db.Exec(b.String())
+	if err != nil {
+		t.Errorf("exported SQL should be valid: %s", err)
+	}
+}
+
+func TestExportToYAML(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigrationWithRollback("create_users_table", "Add the users table.", "CREATE TABLE users (\n\tid INT\n);", "DROP TABLE users;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_posts_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("YAML", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := m.ExportToYAML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "hash: "+m.migrations[0].hash) {
+			t.Errorf("export should have the computed hash:\n%s", buf)
+		}
+		imported, _ := newTestMigrator(t)
+		err = imported.AddMigrationsFromYAML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(imported.migrations, m.migrations) {
+			t.Errorf("imported migrations incorrect: %+v", imported.migrations)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := m.ExportToJSON(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, _ := newTestMigrator(t)
+		err = imported.AddFromSource(JSONSource(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(imported.migrations) != 2 || imported.migrations[1].hash != m.migrations[1].hash {
+			t.Errorf("imported migrations incorrect: %+v", imported.migrations)
+		}
+	})
+}
+
+func TestMarkApplied(t *testing.T) {
+	m, db := newTestMigrator(t)
+	_, err := db.Exec(`CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.MarkApplied("create_user_table", "create_post_table")
+	if err == nil {
+		t.Error("expected an error for a missing migration")
+	}
+
+	l, err := m.MarkApplied("create_user_table")
+	if err != nil {
+		t.Fatalf("mark applied error: %s", err)
+	}
+	if len(l) != 1 || l[0].Status != SUCCESS {
+		t.Errorf("mark applied log incorrect: %v", l)
+	}
+	l, err = m.MarkApplied("create_user_table")
+	if err != nil {
+		t.Fatalf("mark applied error: %s", err)
+	}
+	if len(l) != 1 || l[0].Status != PREVIOUS {
+		t.Errorf("mark applied log incorrect: %v", l)
+	}
+
+	// The table already exists, so this fails if the migration is run.
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Errorf("migrator run error: %s", err)
+	}
+}
+
+func TestMarkAppliedCommitError(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithTransactionManager(failCommit{})(m)
+	logs, err := m.MarkApplied("create_user_table")
+	if err == nil || !strings.Contains(err.Error(), "commit failed") {
+		t.Errorf("the commit error should be returned, got: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Status != ERROR {
+		t.Errorf("the uncommitted record should be logged as an error: %v", logs)
+	}
+	count := -1
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations;`)
+	if err != nil || count != 0 {
+		t.Errorf("no records should be stored: %d %v", count, err)
+	}
+}
+
+func TestForceRun(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("insert_user", "", `INSERT INTO users (id) VALUES (1);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.ForceRun("missing")
+	if err == nil {
+		t.Error("expected an error for a missing migration")
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	l, err := m.ForceRun("insert_user")
+	if err != nil {
+		t.Fatalf("force run error: %s", err)
+	}
+	if l[len(l)-1].Status != SUCCESS {
+		t.Errorf("force run log incorrect: %v", l[len(l)-1])
+	}
+	count := 0
+	err = db.Get(&count, `SELECT count(*) FROM users;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("migration should run again: expected '2' users, got '%d'", count)
+	}
+	names, err := m.appliedNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Errorf("record should be replaced: %v", names)
+	}
+}
+
+func TestForceRunSafeMode(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	WithSafeMode()(m)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Run()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	_, err = m.ForceRun("create_user_table")
+	if !errors.Is(err, ErrSafeMode) {
+		t.Errorf("expected ErrSafeMode, got '%v'", err)
+	}
+}
+
+func TestForceRunCommitError(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithTransactionManager(failCommit{})(m)
+	l, err := m.ForceRun("create_user_table")
+	if err == nil || !strings.Contains(err.Error(), "commit failed") {
+		t.Errorf("the commit error should be returned, got: %v", err)
+	}
+	if l[len(l)-1].Status != ERROR {
+		t.Errorf("the uncommitted migration should be logged as an error: %v", l[len(l)-1])
+	}
+	count := -1
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations;`)
+	if err != nil || count != 0 {
+		t.Errorf("no records should be stored: %d %v", count, err)
+	}
+}
+
+func TestDeleteRecord(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.DeleteRecord("create_user_table")
+	if !errors.Is(err, ErrMigrationNotFound) {
+		t.Errorf("expected ErrMigrationNotFound without a migration table, got: %v", err)
+	}
+
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE IF NOT EXISTS users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	err = m.DeleteRecord("create_posts_table")
+	if !errors.Is(err, ErrMigrationNotFound) {
+		t.Errorf("expected ErrMigrationNotFound, got: %v", err)
+	}
+	err = m.DeleteRecord("create_user_table")
+	if err != nil {
+		t.Fatalf("delete record error: %s", err)
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("record should be deleted, got %d records", count)
+	}
+
+	log, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if last := log[len(log)-1]; last.Name != "create_user_table" || last.Status != SUCCESS {
+		t.Errorf("migration should be run again: %v", last)
+	}
+}
+
+func TestUpdateMigrationComment(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "Add the usr table", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	err = m.UpdateMigrationComment("create_user_table", "Add the user table")
+	if err != nil {
+		t.Fatalf("update comment error: %s", err)
+	}
+	var comment string
+	err = db.Get(&comment, `SELECT comment FROM migrations WHERE name = 'create_user_table'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment != "Add the user table" {
+		t.Errorf("comment should be updated, got: %s", comment)
+	}
+
+	err = m.UpdateMigrationComment("create_posts_table", "Add the posts table")
+	if !errors.Is(err, ErrMigrationNotFound) {
+		t.Errorf("expected ErrMigrationNotFound, got: %v", err)
+	}
+}
+
+func TestRunN(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	for _, name := range []string{"users", "posts", "tags"} {
+		err := m.AddMigration("create_"+name+"_table", "", `CREATE TABLE `+name+` (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := m.RunN(0)
+	if err == nil {
+		t.Error("expected an error for n < 1")
+	}
+	for _, expected := range []int{1, 2, 3, 3} {
+		_, err = m.RunN(1)
+		if err != nil {
+			t.Fatalf("run n error: %s", err)
+		}
+		names, err := m.appliedNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != expected {
+			t.Errorf("applied count incorrect: expected '%d', got '%v'", expected, names)
+		}
+	}
+}
+
+func TestWithMaxPendingMigrations(t *testing.T) {
+	m, db := newTestMigrator(t)
+	WithMaxPendingMigrations(2)(m)
+	for _, name := range []string{"users", "posts", "tags"} {
+		err := m.AddMigration("create_"+name+"_table", "", `CREATE TABLE `+name+` (id INT);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := m.RunStrict()
+	if !errors.Is(err, ErrTooManyPending) {
+		t.Fatalf("expected ErrTooManyPending, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "3 pending migrations, the limit is 2") {
+		t.Errorf("error should have the count and limit: %s", err)
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("no migrations should be applied, got %d records", count)
+	}
+
+	_, err = m.RunN(2)
+	if err != nil {
+		t.Fatalf("run n within the limit error: %s", err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+}
+
+func TestSequentialNumbering(t *testing.T) {
+	tests := []struct {
+		names    []string
+		expected error
+	}{
+		{[]string{"0001_create_users", "0002_create_posts"}, nil},
+		{[]string{"0001_create_users", "0003_create_posts"}, ErrSequenceGap},
+		{[]string{"0001_create_users", "create_posts"}, ErrSequenceGap},
+		{[]string{"0001_create_users", "0001_create_posts"}, ErrSequenceDuplicate},
+	}
+	for _, test := range tests {
+		m, _ := newTestMigrator(t)
+		WithSequentialNumbering()(m)
+		for _, name := range test.names {
+			err := m.AddMigration(name, "", `SELECT 1;`)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := m.RunStrict()
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%v: expected '%v', got '%v'", test.names, test.expected, err)
+		}
+		if test.expected != nil {
+			exists, _ := m.backend.HasMigrationTable()
+			if exists {
+				t.Errorf("%v: the DB should not be touched", test.names)
+			}
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO migrations (name, hash, comment) VALUES ('removed', 'abc', '');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		t.Fatalf("status error: %s", err)
+	}
+	if len(status) != 3 {
+		t.Fatalf("status count incorrect: expected '3', got '%d'", len(status))
+	}
+	user, post, removed := status[0], status[1], status[2]
+	if !user.Applied || !user.HashMatch || user.AppliedAt == nil || user.AppliedAt.IsZero() {
+		t.Errorf("applied status incorrect: %+v", user)
+	}
+	if post.Applied || post.AppliedAt != nil || post.StoredHash != "" {
+		t.Errorf("pending status incorrect: %+v", post)
+	}
+	if removed.Name != "removed" || !removed.Orphan || !removed.Applied {
+		t.Errorf("orphan status incorrect: %+v", removed)
+	}
+}
+
+func TestStatusRepairHash(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	_, err = db.Exec(`UPDATE migrations SET hash = 'changed' WHERE name = 'create_user_table';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.RepairHash("create_user_table")
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status[0].HashMatch {
+		t.Errorf("a repaired hash should match: %+v", status[0])
+	}
+	_, err = m.Validate()
+	if err != nil {
+		t.Errorf("a repaired hash should be valid: %s", err)
+	}
+}
+
+func TestAppliedAndPendingCount(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := m.AppliedCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := m.PendingCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 0 || pending != 1 {
+		t.Errorf("expected 0 applied and 1 pending before the run, got %d and %d", applied, pending)
+	}
+
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	_, err = db.Exec(`INSERT INTO migrations (name, hash, comment) VALUES ('removed_migration', 'abc', '');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_posts_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err = m.AppliedCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err = m.PendingCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The orphan record is applied, but doesn't make create_posts_table applied.
+	if applied != 2 || pending != 1 {
+		t.Errorf("expected 2 applied and 1 pending, got %d and %d", applied, pending)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(h http.Handler) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	if rec := get(ReadyHandler(m)); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("pending migrations should not be ready: got '%d'", rec.Code)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if rec := get(ReadyHandler(m)); rec.Code != http.StatusOK {
+		t.Errorf("should be ready: got '%d' %s", rec.Code, rec.Body)
+	}
+
+	rec := get(StatusHandler(m))
+	var status []MigrationStatus
+	err = json.Unmarshal(rec.Body.Bytes(), &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(status) != 1 || !status[0].Applied {
+		t.Errorf("status response incorrect: '%d' %s", rec.Code, rec.Body)
+	}
+
+	_, err = db.Exec(`UPDATE migrations SET hash = 'changed';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(StatusHandler(m)); rec.Code != http.StatusInternalServerError {
+		t.Errorf("hash mismatch should fail: got '%d'", rec.Code)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_posts_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := m.Events()
+	done := make(chan []string)
+	go func() {
+		got := make([]string, 0)
+		for e := range events {
+			got = append(got, e.Type.String()+" "+e.Log.Name)
+		}
+		done <- got
+	}()
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	m.CloseEvents()
+
+	want := []string{
+		"migration_complete create_user_table",
+		"migration_complete create_posts_table",
+		"run_complete ",
+	}
+	if got := <-done; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+
+	// Without a consumer the events are discarded instead of blocking the run.
+	m.Events()
+	for i := 0; i < 5; i++ {
+		_, err = m.RunStrict()
+		if err != nil {
+			t.Fatalf("migrator run error: %s", err)
+		}
+	}
+	m.CloseEvents()
+}
+
+func TestUseHashFunc(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.migrations[0].hash) != 64 {
+		t.Errorf("default hash should be SHA-256: got '%s'", m.migrations[0].hash)
+	}
+
+	m.UseHashFunc(MD5Hash)
+	if len(m.migrations[0].hash) != 32 {
+		t.Errorf("existing migrations should be rehashed: got '%s'", m.migrations[0].hash)
+	}
+}
+
+func TestMigrationLogTiming(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	// The migration table log entry is followed by the migration.
+	for _, entry := range l {
+		if entry.StartTime.IsZero() || entry.Duration <= 0 {
+			t.Errorf("log entry timing not set: %+v", entry)
+		}
+		if entry.EndTime.Sub(entry.StartTime) != entry.Duration {
+			t.Errorf("log entry end time incorrect: %+v", entry)
+		}
+	}
+}
+
+func TestExecutionTime(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	ms := int64(-1)
+	err = db.Get(&ms, `SELECT execution_ms FROM migrations WHERE name = 'create_user_table';`)
+	if err != nil || ms < 0 {
+		t.Errorf("execution time not stored: %d %v", ms, err)
+	}
+}
+
+func TestWithApplier(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	host, _ := os.Hostname()
+	if m.appliedBy != host {
+		t.Errorf("applied by should default to the host name '%s', got '%s'", host, m.appliedBy)
+	}
+	WithApplier("billing-service")(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	records, err := m.backend.QueryRecords(m.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].AppliedBy != "billing-service" {
+		t.Errorf("applied by not stored: %+v", records)
+	}
+}
+
+func TestExtendMigrationRecord(t *testing.T) {
+	m, db := newTestMigrator(t)
+	ExtendMigrationRecord(RecordColumn{Name: "git_sha", Type: "TEXT", Default: "''"})(m)
+	WithRecordFields(ExtraField{Name: "git_sha", Value: "abc123"})(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	sha := ""
+	err = db.Get(&sha, `SELECT git_sha FROM migrations WHERE name = 'create_user_table';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha != "abc123" {
+		t.Errorf("extra field not stored: '%s'", sha)
+	}
+
+	// Add a column to the existing table.
+	ExtendMigrationRecord(RecordColumn{Name: "deployment_id", Type: "INTEGER", Nullable: true})(m)
+	for i := 0; i < 2; i++ {
+		err = m.AlterMigrationTable()
+		if err != nil {
+			t.Fatalf("alter migration table error: %s", err)
+		}
+	}
+	WithRecordFields(ExtraField{Name: "deployment_id", Value: 42})(m)
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	id := 0
+	err = db.Get(&id, `SELECT deployment_id FROM migrations WHERE name = 'create_post_table';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 42 {
+		t.Errorf("extra field not stored in added column: %d", id)
+	}
+}
+
+func TestWithIdempotentRun(t *testing.T) {
+	m, db := newTestMigrator(t)
+	WithIdempotentRun()(m)
+
+	// Another run claims create_user_table after the previous migrations have
+	// been queried.
+	err := m.AddConditionalMigration("create_user_table", "", `CREATE TABLE users (id INT);`, func() bool {
+		_, err := db.Exec(`INSERT INTO migrations (name, hash, comment, status) VALUES ('create_user_table', '', '', 'running');`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	// The first entry is the migration table.
+	if log[1].Status != SKIPPED || log[2].Status != SUCCESS {
+		t.Errorf("claimed migration should be skipped: %v", log)
+	}
+	status := ""
+	err = db.Get(&status, `SELECT status FROM migrations WHERE name = 'create_post_table';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "done" {
+		t.Errorf("claimed record status incorrect: '%s'", status)
+	}
+}
+
+// noClaimBackend hides the optional interfaces of a backend, like a backend
+// without claim support.
+type noClaimBackend struct {
+	backends.Backend
+}
+
+func TestWithIdempotentRunNoClaimer(t *testing.T) {
+	m, db := newTestMigrator(t)
+	m.setBackend(noClaimBackend{m.baseBackend})
+	WithIdempotentRun()(m)
+	m.UseBackendMiddleware(LoggingMiddleware(&recordLogger{}))
+
+	err := m.AddMigration("create_numbers_table", "", `CREATE TABLE numbers AS
+		WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n WHERE x < 200000)
+		SELECT x FROM n;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	ms := int64(0)
+	err = db.Get(&ms, `SELECT execution_ms FROM migrations WHERE name = 'create_numbers_table';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms <= 0 {
+		t.Errorf("execution time not recorded without claims: %d", ms)
+	}
+}
+
+func TestArchiveOldRecords(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	_, err = db.Exec(`UPDATE migrations SET date = '2000-01-01 00:00:00' WHERE name = 'create_user_table';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The history table is only created once.
+	before := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		err = m.ArchiveOldRecords(before)
+		if err != nil {
+			t.Fatalf("archive old records error: %s", err)
+		}
+	}
+	var names []string
+	err = db.Select(&names, `SELECT name FROM migrations;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"create_post_table"}) {
+		t.Errorf("old records not removed: %v", names)
+	}
+	names = nil
+	err = db.Select(&names, `SELECT name FROM migrations_history;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"create_user_table"}) {
+		t.Errorf("old records not archived: %v", names)
+	}
+}
+
+// historyBackend is a backends.HistoryTableCreator that counts the history
+// tables it creates.
+type historyBackend struct {
+	backends.Backend
+	db      *sqlx.DB
+	created int
+}
+
+func (b *historyBackend) CreateHistoryTable(ctx context.Context) error {
+	b.created++
+	_, err := b.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS migrations_history AS SELECT * FROM migrations WHERE 0;`)
+	return err
+}
+
+func TestArchiveOldRecordsHistoryTableCreator(t *testing.T) {
+	m, db := newTestMigrator(t)
+	b := &historyBackend{Backend: m.baseBackend, db: db}
+	m.setBackend(b)
+	m.UseBackendMiddleware(LoggingMiddleware(&recordLogger{}))
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Run()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	err = m.ArchiveOldRecords(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("archive old records error: %s", err)
+	}
+	if b.created != 1 {
+		t.Errorf("the history table should be created before the transaction, got %d calls", b.created)
+	}
+	count := 0
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations_history;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("old records not archived: %d", count)
+	}
+}
+
+func TestCleanMigrationTable(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.CleanMigrationTable()
+	if err != nil {
+		t.Fatalf("clean without a migration table error: %s", err)
+	}
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE IF NOT EXISTS users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	err = m.CleanMigrationTable()
+	if err != nil {
+		t.Fatalf("clean migration table error: %s", err)
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations`)
+	if err != nil {
+		t.Fatalf("the migration table should be kept: %s", err)
+	}
+	if count != 0 || len(m.previous) != 0 || len(m.log) != 0 {
+		t.Errorf("the records, previous migrations and log should be reset, got %d %v %v", count, m.previous, m.log)
+	}
+
+	log, err := m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if len(log) != 1 || log[0].Status != SUCCESS {
+		t.Errorf("the migration should be run again: %v", log)
+	}
+}
+
+func TestMigrationTableUpgrade(t *testing.T) {
+	m, db := newTestMigrator(t)
+	// A migration table created before the execution_ms and applied_by columns
+	// were added.
+	_, err := db.Exec(`CREATE TABLE migrations (
+		id      INTEGER                             PRIMARY KEY,
+		name    TEXT                                NOT NULL UNIQUE,
+		hash    TEXT                                NOT NULL,
+		date    TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		comment TEXT                                NOT NULL
+	);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO migrations (name, hash, comment) VALUES ('create_user_table', 'abc', '');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.AddMigration("create_posts_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Run()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	var version int
+	err = db.Get(&version, `SELECT MAX(schema_version) FROM migrations WHERE name = 'create_user_table'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("expected schema version 2, got %d", version)
+	}
+	var appliedBy string
+	err = db.Get(&appliedBy, `SELECT applied_by FROM migrations WHERE name = 'create_posts_table'`)
+	if err != nil {
+		t.Fatalf("the migration table should be upgraded: %s", err)
+	}
+
+	// A table that is up to date is not changed.
+	err = m.backend.MigrateMigrationTable()
+	if err != nil {
+		t.Errorf("migrate up to date table error: %s", err)
+	}
+}
+
+func TestMigrationLogJSON(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	err = m.RunAndWrite(context.Background(), &b)
+	if err != nil {
+		t.Fatalf("run and write error: %s", err)
+	}
+
+	var raw []map[string]interface{}
+	err = json.Unmarshal([]byte(b.String()), &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 2 || raw[1]["name"] != "create_user_table" || raw[1]["status"] != "success" {
+		t.Errorf("JSON log incorrect: %s", b.String())
+	}
+	for _, key := range []string{"hash", "details", "start_time", "end_time", "duration_ms"} {
+		if _, ok := raw[1][key]; !ok {
+			t.Errorf("JSON log missing '%s': %s", key, b.String())
+		}
+	}
+
+	var l []MigrationLog
+	err = json.Unmarshal([]byte(b.String()), &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := l[1].Duration - m.log[1].Duration
+	if l[1].Status != SUCCESS || diff > time.Microsecond || diff < -time.Microsecond {
+		t.Errorf("JSON log round trip incorrect: %+v", l[1])
+	}
+	if !l[1].EndTime.Equal(m.log[1].EndTime) {
+		t.Errorf("JSON log end time incorrect: %+v", l[1])
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	m, db := newTestMigrator(t)
+
+	err := m.UseBackend("missing")
+	if !errors.Is(err, ErrBackendNotFound) {
+		t.Errorf("expected ErrBackendNotFound, got '%v'", err)
+	}
+
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if !errors.Is(err, ErrDuplicateMigration) {
+		t.Errorf("expected ErrDuplicateMigration, got '%v'", err)
+	}
+
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	_, err = db.Exec(`UPDATE migrations SET hash = 'changed';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("expected ErrHashMismatch, got '%v'", err)
+	}
+}
+
+func TestLogStatusString(t *testing.T) {
+	statuses := map[LogStatus]string{
+		SUCCESS:       "success",
+		ERROR_HASH:    "error_hash",
+		PENDING:       "pending",
+		LogStatus(99): "LogStatus(99)",
+	}
+	for status, expected := range statuses {
+		if status.String() != expected {
+			t.Errorf("status string incorrect: expected '%s', got '%s'", expected, status)
+		}
+	}
+}
+
+type metrics struct {
+	runs  map[string]LogStatus
+	calls map[string]LogStatus
+}
+
+func (c *metrics) OnMigrationRun(name string, status LogStatus, duration time.Duration) {
+	c.runs[name] = status
+}
+
+func (c *metrics) OnBackendCall(method string, status LogStatus, duration time.Duration) {
+	c.calls[method] = status
+}
+
+func TestWithMetrics(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	c := &metrics{runs: make(map[string]LogStatus)}
+	WithMetrics(c)(m)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if len(c.runs) != 1 || c.runs["create_user_table"] != SUCCESS {
+		t.Errorf("metrics incorrect: %v", c.runs)
+	}
+}
+
+type span struct {
+	name  string
+	attrs map[string]string
+	err   error
+	ended bool
+}
+
+func (s *span) SetAttribute(key string, value string) { s.attrs[key] = value }
+func (s *span) SetError(err error)                    { s.err = err }
+func (s *span) End()                                  { s.ended = true }
+
+type tracer struct {
+	spans []*span
+}
+
+func (t *tracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &span{name: name, attrs: make(map[string]string)}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+func TestWithTracer(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	tr := &tracer{}
+	WithTracer(tr)(m)
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("bad_migration", "", `NOT SQL;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err == nil {
+		t.Fatal("expected a migration error")
+	}
+
+	if len(tr.spans) != 3 || tr.spans[0].name != "sqlxm.Run" || tr.spans[0].err == nil {
+		t.Fatalf("run span incorrect: %+v", tr.spans)
+	}
+	user, bad := tr.spans[1], tr.spans[2]
+	if user.attrs["migration.name"] != "create_user_table" || user.attrs["migration.status"] != "success" || user.err != nil {
+		t.Errorf("migration span incorrect: %+v", user)
+	}
+	if bad.attrs["migration.status"] != "error" || bad.err == nil || !bad.ended {
+		t.Errorf("failed migration span incorrect: %+v", bad)
+	}
+}
+
+// retryBackend is a SQLite backend that retries each migration statement once.
+type retryBackend struct {
+	backends.SQLite
+	calls int
+}
+
+func (b *retryBackend) Retry(ctx context.Context, tx *sqlx.Tx, fn func() error) error {
+	b.calls++
+	return fn()
+}
+
+func TestRetrier(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	b := &retryBackend{}
+	err := RegisterBackend("sqlite_retry", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.UseBackend("sqlite_retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if b.calls != 1 {
+		t.Errorf("migration should be run with Retry once, called '%d' times", b.calls)
+	}
+}
+
+func TestWithTemplateData(t *testing.T) {
+	m, db := newTestMigrator(t)
+	WithTemplateData(map[string]string{"Table": "users"})(m)
+
+	err := m.AddMigrationWithRollback("create_user_table", "", `CREATE TABLE {{.Table}} (id INT);`, `DROP TABLE {{.Table}};`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mig := m.migrations[0]
+	if mig.Statement != `CREATE TABLE users (id INT);` || mig.RollbackStatement != `DROP TABLE users;` {
+		t.Errorf("statement not rendered: '%s' '%s'", mig.Statement, mig.RollbackStatement)
+	}
+	if mig.hash != SHA256Hash(`CREATE TABLE users (id INT);`, nil) {
+		t.Errorf("hash should be created from the rendered statement")
+	}
+
+	t.Run("TemplateErrors", func(t *testing.T) {
+		err := m.AddMigration("bad_template", "", `CREATE TABLE {{.Table (id INT);`)
+		if err == nil {
+			t.Error("parse error expected")
+		}
+		err = m.AddMigration("missing_key", "", `CREATE TABLE {{.Missing}} (id INT);`)
+		if err == nil {
+			t.Error("missing key error expected")
+		}
+		if len(m.migrations) != 1 {
+			t.Errorf("migrations with template errors should not be added")
+		}
+	})
+
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	_, err = db.Exec(`SELECT id FROM users;`)
+	if err != nil {
+		t.Errorf("rendered migration not run: %s", err)
+	}
+}
+
+// recordLogger records the level and message of each log line.
+type recordLogger struct {
+	lines []string
+}
+
+func (l *recordLogger) Debug(msg string, fields ...interface{}) {
+	l.lines = append(l.lines, "debug: "+msg)
+}
+
+func (l *recordLogger) Info(msg string, fields ...interface{}) {
+	l.lines = append(l.lines, "info: "+msg)
+}
+
+func (l *recordLogger) Warn(msg string, fields ...interface{}) {
+	l.lines = append(l.lines, "warn: "+msg)
+}
+
+func (l *recordLogger) Error(msg string, fields ...interface{}) {
+	l.lines = append(l.lines, "error: "+msg)
+}
+
+func TestWithStatementPreprocessor(t *testing.T) {
+	m, db := newTestMigrator(t)
+	WithStatementPreprocessor(func(ctx context.Context, name string, statement string) (string, error) {
+		if name == "bad_migration" {
+			return "", errors.New("unknown macro")
+		}
+		return strings.Replace(statement, "{{tenant}}", "acme", -1), nil
+	})(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE {{tenant}}_users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM acme_users`)
+	if err != nil {
+		t.Errorf("preprocessed statement should create the acme_users table: %s", err)
+	}
+	if m.migrations[0].hash != m.hashQuery(`CREATE TABLE {{tenant}}_users (id INT);`, nil) {
+		t.Error("hash should be of the statement as it was added")
+	}
+
+	err = m.AddMigration("bad_migration", "", `SELECT 1;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := m.RunStrict()
+	if err == nil || log[len(log)-1].Status != ERROR || !strings.Contains(log[len(log)-1].Details, "unknown macro") {
+		t.Errorf("expected the preprocess error, got '%v' %+v", err, log[len(log)-1])
+	}
+
+	// A failed statement is logged as it was run.
+	m, _ = newTestMigrator(t)
+	WithStatementPreprocessor(func(ctx context.Context, name string, statement string) (string, error) {
+		return strings.Replace(statement, "{{tenant}}", "acme", -1), nil
+	})(m)
+	err = m.AddMigration("bad_migration", "", `CREATE TABLE {{tenant}}_users;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err = m.RunStrict()
+	if err == nil || !strings.Contains(log[len(log)-1].Details, "statement: CREATE TABLE acme_users;") {
+		t.Errorf("failed statement should be in the log details, got %+v", log[len(log)-1])
+	}
+}
+
+func TestWithLinter(t *testing.T) {
+	m, db := newTestMigrator(t)
+	WithLinter(PrimaryKeyRule(), DropCommentRule())(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT PRIMARY KEY);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT); CREATE TABLE tags (id INT PRIMARY KEY);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("drop_user_table", "", "-- Users moved to the accounts service.\nDROP TABLE users;\nDROP TABLE tags;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	var lintErrs LintErrors
+	if !errors.As(err, &lintErrs) {
+		t.Fatalf("expected LintErrors, got: %v", err)
+	}
+	want := LintErrors{
+		{Rule: "primary-key", Migration: "create_post_table", Message: "CREATE TABLE has no PRIMARY KEY"},
+		{Rule: "drop-comment", Migration: "drop_user_table", Message: "DROP TABLE has no comment"},
+	}
+	if !reflect.DeepEqual(lintErrs, want) {
+		t.Errorf("lint errors incorrect: %v", lintErrs)
+	}
+	var count int
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("nothing should be applied when lint fails, got %d records", count)
+	}
+}
+
+func TestReplaceMigration(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	l := &recordLogger{}
+	WithLogger(l)(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.ReplaceMigration("create_user_table", `CREATE TABLE users (id BIGINT);`, "Add the user table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mig := m.migrations[0]
+	if mig.Statement != `CREATE TABLE users (id BIGINT);` || mig.Comment != "Add the user table" || mig.hash != m.hashQuery(mig.Statement, nil) {
+		t.Errorf("migration not replaced: %+v", mig)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+
+	// Replacing a migration that has been run logs a warning.
+	l.lines = nil
+	err = m.ReplaceMigration("create_user_table", `CREATE TABLE users (id INT);`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(l.lines, []string{"warn: replaced migration has already been run"}) {
+		t.Errorf("expected a warning, got %v", l.lines)
+	}
+
+	err = m.ReplaceMigration("not_added", `SELECT 1;`, "")
+	if err == nil {
+		t.Error("replacing a migration that has not been added should return an error")
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	l := &recordLogger{}
+	WithLogger(l)(m)
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	expected := []string{
+		"info: running migrations",
+		"info: created migration table",
+		"debug: running migration",
+		"info: migration applied",
+		"info: migration run complete",
+	}
+	if !reflect.DeepEqual(l.lines, expected) {
+		t.Errorf("log lines incorrect: %v", l.lines)
+	}
+}
+
+func TestUseBackendMiddleware(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	var calls []string
+	order := func(name string) BackendMiddleware {
+		return func(next backends.Backend) backends.Backend {
+			return &hookBackend{next: next, after: func(method string, d time.Duration, err error) {
+				if method == "QueryPreviousContext" {
+					calls = append(calls, name)
+				}
+			}}
+		}
+	}
+	c := &metrics{runs: make(map[string]LogStatus), calls: make(map[string]LogStatus)}
+	l := &recordLogger{}
+	m.UseBackendMiddleware(order("outer"), order("inner"), LoggingMiddleware(l), TimingMiddleware(c))
 
-func (b *back) CreateMigrationTable() (string, error) {
-	return "", nil
-}
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	// The inner middleware returns first.
+	if !reflect.DeepEqual(calls, []string{"inner", "outer"}) {
+		t.Errorf("middleware order incorrect: %v", calls)
+	}
+	if c.calls["InsertRecordWithApplier"] != SUCCESS {
+		t.Errorf("backend calls not timed: %v", c.calls)
+	}
+	if len(c.runs) != 0 {
+		t.Errorf("backend calls should not be reported as migrations: %v", c.runs)
+	}
+	if len(l.lines) == 0 || l.lines[0] != "debug: backend call" {
+		t.Errorf("backend calls not logged: %v", l.lines)
+	}
 
-func (b *back) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
-	return nil
+	// The middleware is kept when the backend is changed.
+	err = m.UseBackend("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.backend.(*hookBackend); !ok {
+		t.Errorf("middleware removed by UseBackend: %T", m.backend)
+	}
 }
 
-type testDBMS struct {
-	title       string
-	name        string
-	tableSchema string
+func TestWithNamespacePrefix(t *testing.T) {
+	_, db := newTestMigrator(t)
+	users, err := New(db, "migrations", "", WithNamespacePrefix("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	billing, err := New(db, "migrations", "", WithNamespacePrefix("billing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = users.AddMigration("create_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = billing.AddMigration("create_table", "", `CREATE TABLE invoices (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []*Migrator{&users, &billing} {
+		_, err = m.RunStrict()
+		if err != nil {
+			t.Fatalf("migrator run error: %s", err)
+		}
+	}
+	var names []string
+	err = db.Select(&names, `SELECT name FROM migrations ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "users:create_table" || names[1] != "billing:create_table" {
+		t.Errorf("stored names should have the namespace prefix: %v", names)
+	}
+
+	applied, err := billing.appliedNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0] != "create_table" {
+		t.Errorf("namespace should only see its own records: %v", applied)
+	}
+	_, err = billing.Validate()
+	if err != nil {
+		t.Errorf("validate error: %s", err)
+	}
 }
 
-// Unit Tests ------------------------------------------------------------------
+func TestWithNamespacePrefixOverlapping(t *testing.T) {
+	_, db := newTestMigrator(t)
+	users, err := New(db, "migrations", "", WithNamespacePrefix("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admins, err := New(db, "migrations", "", WithNamespacePrefix("users_admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = users.AddMigration("create_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = admins.AddMigration("create_table", "", `CREATE TABLE admins (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*Migrator{&users, &admins} {
+		_, err = m.RunStrict()
+		if err != nil {
+			t.Fatalf("migrator run error: %s", err)
+		}
+	}
 
-func TestBackendType(t *testing.T) {
-	// 0: backend, 1: driver
-	drivers := [][2]string{
-		{"postgres", "postgres"},
-		{"postgres", "cockroach"},
-		{"mysql", "mysql"},
-		{"sqlite", "sqlite3"},
-		{"oracle", "godror"},
-		{"sqlserver", "sqlserver"},
+	for _, m := range []*Migrator{&users, &admins} {
+		applied, err := m.appliedNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != 1 || applied[0] != "create_table" {
+			t.Errorf("namespace '%s' should only see its own records: %v", m.namespace, applied)
+		}
+		n, err := m.AppliedCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("namespace '%s' should only count its own records: %d", m.namespace, n)
+		}
 	}
 
-	t.Run("KnownBackends", func(t *testing.T) {
-		for i, d := range drivers {
-			backend := BackendType(d[1])
-			if backend != d[0] {
-				t.Errorf("backend type #%d incorrect expected '%s', actuall '%s'", i, d[0], backend)
-			}
+	_, err = New(db, "migrations", "", WithNamespacePrefix("users:admin"))
+	if !errors.Is(err, ErrInvalidNamespace) {
+		t.Errorf("expected ErrInvalidNamespace, got %v", err)
+	}
+}
+
+func TestWithNamespacePrefixWholeTable(t *testing.T) {
+	_, db := newTestMigrator(t)
+	m, err := New(db, "migrations", "", WithNamespacePrefix("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.ArchiveOldRecords(time.Now())
+	if !errors.Is(err, ErrNamespaced) {
+		t.Errorf("archive should return ErrNamespaced, got %v", err)
+	}
+	m.WithMigrationTableChecksum(true)
+	_, err = m.Run()
+	if !errors.Is(err, ErrNamespaced) {
+		t.Errorf("a run with the table checksum should return ErrNamespaced, got %v", err)
+	}
+}
+
+func TestWithNamespacePrefixClean(t *testing.T) {
+	_, db := newTestMigrator(t)
+	users, err := New(db, "migrations", "", WithNamespacePrefix("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	billing, err := New(db, "migrations", "", WithNamespacePrefix("billing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*Migrator{&users, &billing} {
+		err = m.AddMigration("create_table", "", fmt.Sprintf(`CREATE TABLE %s (id INT);`, m.namespace))
+		if err != nil {
+			t.Fatal(err)
 		}
-	})
-	t.Run("UnknownBackend", func(t *testing.T) {
-		backend := BackendType("not-a-real-driver")
-		if backend != "unknown" {
-			t.Error("backend should return 'unknown'")
+		_, err = m.Run()
+		if err != nil {
+			t.Fatalf("migrator run error: %s", err)
 		}
-	})
+	}
+
+	err = users.CleanMigrationTable()
+	if err != nil {
+		t.Fatalf("clean migration table error: %s", err)
+	}
+	var names []string
+	err = db.Select(&names, `SELECT name FROM migrations;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"billing:create_table"}) {
+		t.Errorf("only the records of the namespace should be deleted: %v", names)
+	}
 }
 
-func TestRegisterBackend(t *testing.T) {
-	t.Run("ExistingBackend", func(t *testing.T) {
-		err := RegisterBackend("postgres", &back{})
-		if err == nil {
-			t.Error("backend exists: an error should be returned")
+// runnerBackend is a SQLite backend that records each statement it runs.
+type runnerBackend struct {
+	backends.SQLite
+	statements []string
+}
+
+func (b *runnerBackend) RunStatement(ctx context.Context, tx *sqlx.Tx, statement string, args ...interface{}) error {
+	b.statements = append(b.statements, statement)
+	_, err := tx.ExecContext(ctx, statement, args...)
+	return err
+}
+
+func TestStatementRunner(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	b := &runnerBackend{}
+	err := RegisterBackend("sqlite_runner", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.UseBackend("sqlite_runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if len(b.statements) != 1 || b.statements[0] != `CREATE TABLE users (id INT);` {
+		t.Errorf("migration should be run with RunStatement: %v", b.statements)
+	}
+}
+
+func TestWithConnectionRetry(t *testing.T) {
+	// Nothing listens on port 1, so every connection attempt fails.
+	db, err := sqlx.Open("postgres", "postgres://sqlxm@127.0.0.1:1/sqlxm?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	l := &recordLogger{}
+	m, err := New(db, "", "", WithConnectionRetry(3, time.Millisecond), WithLogger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") || errors.Unwrap(err) == nil {
+		t.Errorf("connection error expected, got: %v", err)
+	}
+	warnings := 0
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, "warn: ") {
+			warnings++
 		}
-	})
-	t.Run("NewBackend", func(t *testing.T) {
-		err := RegisterBackend("mydb", &back{})
+	}
+	if warnings != 2 {
+		t.Errorf("each retry should be logged, got: %v", l.lines)
+	}
+}
+
+func TestPing(t *testing.T) {
+	m, db := newTestMigrator(t)
+	err := m.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("ping error: %s", err)
+	}
+
+	err = m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	err = m.Ping(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "ping database failed") {
+		t.Errorf("ping error expected, got: %v", err)
+	}
+	_, err = m.RunStrict()
+	if err == nil || !strings.Contains(err.Error(), "ping database failed") {
+		t.Errorf("run should fail on the ping, got: %v", err)
+	}
+	if len(m.log) != 0 {
+		t.Errorf("nothing should be run, got: %v", m.log)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	m, db := newTestMigrator(t)
+	m.lockTimeout = 200 * time.Millisecond
+
+	// Hold the lock like another Migrator would.
+	err := m.backend.Lock(context.Background(), m.lockTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("expected ErrLockTimeout, got '%v'", err)
+	}
+
+	_, err = db.Exec(`DELETE FROM migrations_lock;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Errorf("migrator run error: %s", err)
+	}
+}
+
+func TestSQLiteLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	backend := func() *backends.SQLite {
+		db, err := sqlx.Open("sqlite", path)
 		if err != nil {
-			t.Error("backend does not exist: an error should not be returned")
+			t.Fatal(err)
 		}
-	})
+		t.Cleanup(func() {
+			db.Close()
+		})
+		b := &backends.SQLite{}
+		b.Setup(db, "migrations", "")
+		return b
+	}
+	// Two processes using the same database file.
+	b1, b2 := backend(), backend()
+	ctx := context.Background()
+
+	err := b1.Lock(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b2.Lock(ctx, 200*time.Millisecond)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got '%v'", err)
+	}
+	// Only the holder can release the lock.
+	err = b2.Unlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b2.Lock(ctx, 200*time.Millisecond)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got '%v'", err)
+	}
+	err = b1.Unlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b2.Lock(ctx, time.Second)
+	if err != nil {
+		t.Errorf("lock should be acquired after it is released: %s", err)
+	}
+}
+
+func TestSQLiteLockStale(t *testing.T) {
+	m, db := newTestMigrator(t)
+	ctx := context.Background()
+	err := m.backend.Lock(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The process exited without unlocking.
+	stale := time.Now().UTC().Add(-2 * backends.SQLiteLockTTL).Format("2006-01-02 15:04:05")
+	_, err = db.Exec(`UPDATE migrations_lock SET migrator_id = 'exited', acquired_at = ?;`, stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.backend.Lock(ctx, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("a stale lock should be taken over: %s", err)
+	}
+	err = m.backend.Unlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := -1
+	err = db.Get(&count, `SELECT COUNT(*) FROM migrations_lock;`)
+	if err != nil || count != 0 {
+		t.Errorf("the lock should be released: %d %v", count, err)
+	}
+}
+
+func TestLockPerMigrator(t *testing.T) {
+	m1, db := newTestMigrator(t)
+	m2, err := New(db, "migrations", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m1.baseBackend == m2.baseBackend {
+		t.Fatal("each Migrator should have its own backend instance")
+	}
+	ctx := context.Background()
+
+	err = m1.backend.Lock(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unlocking a Migrator that doesn't hold the lock doesn't release it.
+	err = m2.backend.Unlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m2.backend.Lock(ctx, 200*time.Millisecond)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got '%v'", err)
+	}
+	err = m1.backend.Unlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteLockTableUpgrade(t *testing.T) {
+	m, db := newTestMigrator(t)
+	_, err := db.Exec(`CREATE TABLE migrations_lock (
+		id          INTEGER   PRIMARY KEY CHECK (id = 1),
+		acquired_at TIMESTAMP NOT NULL
+	);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+}
+
+func TestWithSQLitePragmas(t *testing.T) {
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = New(db, "migrations", "", WithSQLiteWALMode(), WithSQLiteForeignKeys(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pragmas were issued on the only connection in the pool.
+	var mode string
+	var foreignKeys int
+	err = db.Get(&mode, `PRAGMA journal_mode;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Get(&foreignKeys, `PRAGMA foreign_keys;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != "wal" || foreignKeys != 1 {
+		t.Errorf("expected the wal journal mode and foreign keys, got: %s %d", mode, foreignKeys)
+	}
+
+	m, err := New(db, "migrations", "", WithSQLiteForeignKeys(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Get(&foreignKeys, `PRAGMA foreign_keys;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foreignKeys != 0 {
+		t.Errorf("foreign keys should be turned off")
+	}
+	err = m.Ping(context.Background())
+	if err != nil {
+		t.Errorf("ping error: %s", err)
+	}
 }
 
 // End-to-End Tests ------------------------------------------------------------
@@ -125,34 +4075,41 @@ func postgresDSN(env map[string]string) string {
 	)
 }
 
-func mysqlDSN(env map[string]string) string {
+func mysqlDSN(env map[string]string, prefix string) string {
 	// username:password@protocol(address)/dbname?param=value
 	return fmt.Sprintf(
 		"%s:%s@(%s:%s)/%s",
-		env["MYSQL_USER"],
-		env["MYSQL_PASSWORD"],
-		env["MYSQL_HOST"],
-		env["MYSQL_PORT"],
-		env["MYSQL_DB"],
+		env[prefix+"_USER"],
+		env[prefix+"_PASSWORD"],
+		env[prefix+"_HOST"],
+		env[prefix+"_PORT"],
+		env[prefix+"_DB"],
 	)
 }
 
 func connectToDB(dbms string) (*sqlx.DB, func(drop ...string)) {
 	env := getEnv()
 	var sourceData string
+	driver := dbms
 	switch dbms {
 	case "mysql":
-		sourceData = mysqlDSN(env)
+		sourceData = mysqlDSN(env, "MYSQL")
+	case "mariadb":
+		// MariaDB uses the MySQL driver.
+		driver = "mysql"
+		sourceData = mysqlDSN(env, "MARIADB")
 	case "postgres":
 		sourceData = postgresDSN(env)
 	case "sqlite":
 		sourceData = env["SQLITE_PATH"]
 	}
 
-	db, err := sqlx.Open(dbms, sourceData)
+	db, err := sqlx.Open(driver, sourceData)
 	if err != nil {
 		log.Fatalf("DB connection %s: %s\n", dbms, err)
 	}
+	// The driver name is used to pick the backend.
+	db = sqlx.NewDb(db.DB, dbms)
 
 	return db, func(drop ...string) {
 		dropTables(db, drop)
@@ -172,6 +4129,11 @@ func TestMainE2E(t *testing.T) {
 			name:        "mysql",
 			tableSchema: env["MYSQL_DB"],
 		},
+		{
+			title:       "MariaDB",
+			name:        "mariadb",
+			tableSchema: env["MARIADB_DB"],
+		},
 		{
 			title:       "Postgres",
 			name:        "postgres",
@@ -197,6 +4159,9 @@ func TestMainE2E(t *testing.T) {
 		t.Run(fmt.Sprintf("%stestUseBackend", d.title), func(t *testing.T) {
 			testUseBackend(t, d)
 		})
+		t.Run(fmt.Sprintf("%stestInspectDB", d.title), func(t *testing.T) {
+			testInspectDB(t, d)
+		})
 	}
 }
 
@@ -243,7 +4208,7 @@ func testE2E(t *testing.T, dbms testDBMS) {
 		t.Error(err)
 	}
 
-	l, err := migrator.Run()
+	l, err := migrator.RunStrict()
 	if err != nil {
 		t.Errorf("migrator run error: %s", err)
 	}
@@ -337,7 +4302,7 @@ func testSafe(t *testing.T, dbms testDBMS) {
 	if err != nil {
 		t.Error(err)
 	}
-	_, err = migrator1.Run()
+	_, err = migrator1.RunStrict()
 	if err != nil {
 		t.Errorf("migrator run error: %s", err)
 	}
@@ -362,7 +4327,7 @@ func testSafe(t *testing.T, dbms testDBMS) {
 			t.Error(err)
 		}
 
-		l, err := migrator2.Run()
+		l, err := migrator2.RunStrict()
 		if err == nil || ERROR_HASH != l[len(l)-1].Status {
 			t.Error("migrator run safe error: hash mismatch check failed")
 		}
@@ -387,7 +4352,7 @@ func testSafe(t *testing.T, dbms testDBMS) {
 			t.Error(err)
 		}
 
-		l, err := migrator3.RunUnsafe()
+		l, err := migrator3.Run()
 		lastLog := l[len(l)-1]
 		if err != nil {
 			t.Error("migrator run loose error: run failed")
@@ -421,7 +4386,7 @@ func testSafe(t *testing.T, dbms testDBMS) {
 
 		migrator4.RepairHash("create_user_table")
 
-		l, err := migrator4.Run()
+		l, err := migrator4.RunStrict()
 
 		var lastLog = MigrationLog{}
 		if len(l) > 0 {
@@ -503,10 +4468,47 @@ func testUseBackend(t *testing.T, dbms testDBMS) {
 		if err != nil {
 			t.Error(err)
 		}
-		_, err = migrator.Run()
+		_, err = migrator.RunStrict()
 		if err != nil {
 			t.Errorf("migrator run error: %s", err)
 		}
 
 	})
 }
+
+func testInspectDB(t *testing.T, dbms testDBMS) {
+	db, done := connectToDB(dbms.name)
+	defer done("migrations", "users")
+
+	migrator, err := New(db, "migrations", dbms.tableSchema)
+	if err != nil {
+		t.Error(err)
+	}
+	err = migrator.AddMigration(
+		"create_user_table",
+		"Add the initial user table",
+		`CREATE TABLE users (id INT);`,
+	)
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = migrator.RunStrict()
+	if err != nil {
+		t.Errorf("migrator run error: %s", err)
+	}
+
+	tables, err := migrator.InspectDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Backends may create other tables, like a lock table.
+	found := 0
+	for _, table := range tables {
+		if table == "migrations" || table == "users" {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("tables incorrect: expected 'migrations' and 'users', got '%v'", tables)
+	}
+}
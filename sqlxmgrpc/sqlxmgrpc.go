@@ -0,0 +1,146 @@
+// Package sqlxmgrpc serves a sqlxm Migrator over gRPC, so migrations can be run
+// and monitored from a control plane. The service is defined in
+// migrations/proto/sqlxm.proto.
+//
+//    s := grpc.NewServer()
+//    sqlxmpb.RegisterMigrationServiceServer(s, sqlxmgrpc.NewServer(&m))
+//    err := s.Serve(lis)
+package sqlxmgrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/danielmorell/sqlxm"
+	sqlxmpb "github.com/danielmorell/sqlxm/migrations/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server is a sqlxmpb.MigrationServiceServer for a single Migrator. A Migrator
+// is not safe for concurrent use, so the calls are run one at a time.
+type Server struct {
+	sqlxmpb.UnimplementedMigrationServiceServer
+	m  *sqlxm.Migrator
+	mu sync.Mutex
+}
+
+// NewServer creates a Server for m. The migrations must be added to m before
+// the server is started.
+func NewServer(m *sqlxm.Migrator) *Server {
+	return &Server{m: m}
+}
+
+// GetStatus returns the status of every added migration and orphan record.
+func (s *Server) GetStatus(ctx context.Context, _ *emptypb.Empty) (*sqlxmpb.MigrationStatusResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses, err := s.m.Status()
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	resp := &sqlxmpb.MigrationStatusResponse{
+		Migrations: make([]*sqlxmpb.MigrationStatus, len(statuses)),
+	}
+	for i, st := range statuses {
+		ms := &sqlxmpb.MigrationStatus{
+			Name:       st.Name,
+			Hash:       st.Hash,
+			StoredHash: st.StoredHash,
+			Applied:    st.Applied,
+			HashMatch:  st.HashMatch,
+			Orphan:     st.Orphan,
+		}
+		if st.AppliedAt != nil {
+			ms.AppliedAt = timestamppb.New(*st.AppliedAt)
+		}
+		resp.Migrations[i] = ms
+	}
+	return resp, nil
+}
+
+// RunMigrations runs the pending migrations, and sends an event as each one
+// completes. If the client cancels the call the run is rolled back.
+func (s *Server) RunMigrations(req *sqlxmpb.RunRequest, stream sqlxmpb.MigrationService_RunMigrationsServer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sendErr error
+	s.m.OnMigration(func(l sqlxm.MigrationLog) {
+		if sendErr == nil {
+			sendErr = stream.Send(toEvent(l))
+		}
+	})
+	defer s.m.OnMigration(nil)
+
+	var err error
+	if req.GetUnsafe() {
+		_, err = s.m.RunContext(stream.Context())
+	} else {
+		_, err = s.m.RunStrictContext(stream.Context())
+	}
+	if err != nil {
+		return toStatusError(err)
+	}
+	return sendErr
+}
+
+// ValidateMigrations compares the added migrations with the migration table.
+// Hash mismatches are returned in the response, and other errors as a gRPC
+// error.
+func (s *Server) ValidateMigrations(ctx context.Context, _ *emptypb.Empty) (*sqlxmpb.ValidationResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logs, err := s.m.Validate()
+	if err != nil && !errors.Is(err, sqlxm.ErrHashMismatch) {
+		return nil, toStatusError(err)
+	}
+	resp := &sqlxmpb.ValidationResponse{
+		Valid:   err == nil,
+		Results: make([]*sqlxmpb.MigrationEvent, len(logs)),
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	for i, l := range logs {
+		resp.Results[i] = toEvent(l)
+	}
+	return resp, nil
+}
+
+// toEvent converts a MigrationLog to a MigrationEvent.
+func toEvent(l sqlxm.MigrationLog) *sqlxmpb.MigrationEvent {
+	e := &sqlxmpb.MigrationEvent{
+		Name:     l.Name,
+		Hash:     l.Hash,
+		Status:   l.Status.String(),
+		Details:  l.Details,
+		Duration: durationpb.New(l.Duration),
+	}
+	if !l.StartTime.IsZero() {
+		e.StartTime = timestamppb.New(l.StartTime)
+	}
+	return e
+}
+
+// toStatusError converts a sqlxm error to a gRPC status error.
+func toStatusError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, sqlxm.ErrQueryTimeout):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, sqlxm.ErrHashMismatch), errors.Is(err, sqlxm.ErrMigrationTableNotFound):
+		code = codes.FailedPrecondition
+	case errors.Is(err, sqlxm.ErrLockTimeout):
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,86 @@
+package sqlxmgrpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	sqlxmpb "github.com/danielmorell/sqlxm/migrations/proto"
+	"github.com/danielmorell/sqlxm/sqlxmtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestServer(t *testing.T) {
+	m, done := sqlxmtest.NewInMemory()
+	defer done()
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	sqlxmpb.RegisterMigrationServiceServer(s, NewServer(m))
+	go s.Serve(lis)
+	defer s.Stop()
+
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := sqlxmpb.NewMigrationServiceClient(conn)
+
+	stream, err := client.RunMigrations(ctx, &sqlxmpb.RunRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("run migrations error: %s", err)
+		}
+		if e.Status != "success" {
+			t.Errorf("migration '%s' status incorrect: %s", e.Name, e.Status)
+		}
+		names = append(names, e.Name)
+	}
+	if len(names) != 2 || names[0] != "create_user_table" || names[1] != "create_post_table" {
+		t.Errorf("an event should be sent for each migration: %v", names)
+	}
+
+	status, err := client.GetStatus(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("get status error: %s", err)
+	}
+	for _, s := range status.Migrations {
+		if !s.Applied || s.AppliedAt == nil {
+			t.Errorf("migration '%s' should be applied", s.Name)
+		}
+	}
+
+	v, err := client.ValidateMigrations(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("validate migrations error: %s", err)
+	}
+	if !v.Valid {
+		t.Errorf("migrations should be valid: %s", v.Error)
+	}
+}
@@ -0,0 +1,51 @@
+// Package sqlxmotel traces sqlxm migration runs with OpenTelemetry.
+//
+//    m, err := sqlxm.New(db, "migrations", "public",
+//        sqlxmotel.WithTracer(otel.Tracer("sqlxm")))
+package sqlxmotel
+
+import (
+	"context"
+
+	"github.com/danielmorell/sqlxm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracer is the sqlxm.WithTracer option for an OpenTelemetry tracer.
+func WithTracer(tracer trace.Tracer) sqlxm.Option {
+	return sqlxm.WithTracer(Tracer{tracer})
+}
+
+// Tracer adapts a trace.Tracer to the sqlxm.Tracer interface.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// Start implements sqlxm.Tracer.
+func (t Tracer) Start(ctx context.Context, name string) (context.Context, sqlxm.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, Span{span}
+}
+
+// Span adapts a trace.Span to the sqlxm.Span interface.
+type Span struct {
+	span trace.Span
+}
+
+// SetAttribute implements sqlxm.Span.
+func (s Span) SetAttribute(key string, value string) {
+	s.span.SetAttributes(attribute.String(key, value))
+}
+
+// SetError implements sqlxm.Span.
+func (s Span) SetError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End implements sqlxm.Span.
+func (s Span) End() {
+	s.span.End()
+}
@@ -0,0 +1,75 @@
+// Package sqlxmprom reports sqlxm migration metrics to Prometheus.
+//
+//    c, err := sqlxmprom.PrometheusCollector(prometheus.DefaultRegisterer)
+//    if err != nil {
+//        return err
+//    }
+//    m, err := sqlxm.New(db, "migrations", "public", sqlxm.WithMetrics(c))
+package sqlxmprom
+
+import (
+	"time"
+
+	"github.com/danielmorell/sqlxm"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a sqlxm.MetricsCollector that records Prometheus metrics. It is
+// also a sqlxm.BackendMetricsCollector, so it can be used with
+// sqlxm.TimingMiddleware.
+type Collector struct {
+	total    *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	lastRun  prometheus.Gauge
+	backend  *prometheus.HistogramVec
+}
+
+// PrometheusCollector creates a Collector and registers the following metrics
+// with registerer.
+//
+//    sqlxm_migrations_total{status}                      counter
+//    sqlxm_migration_duration_seconds{name}              histogram
+//    sqlxm_last_run_timestamp                            gauge
+//    sqlxm_backend_call_duration_seconds{method,status}  histogram
+//
+// The backend call histogram is only recorded when the Collector is used with
+// sqlxm.TimingMiddleware.
+func PrometheusCollector(registerer prometheus.Registerer) (*Collector, error) {
+	c := &Collector{
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sqlxm_migrations_total",
+			Help: "The number of migrations run by status.",
+		}, []string{"status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "sqlxm_migration_duration_seconds",
+			Help: "How long each migration took to run.",
+		}, []string{"name"}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sqlxm_last_run_timestamp",
+			Help: "The unix time the last migration was run.",
+		}),
+		backend: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "sqlxm_backend_call_duration_seconds",
+			Help: "How long each backend call took.",
+		}, []string{"method", "status"}),
+	}
+	for _, collector := range []prometheus.Collector{c.total, c.duration, c.lastRun, c.backend} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// OnMigrationRun implements sqlxm.MetricsCollector.
+func (c *Collector) OnMigrationRun(name string, status sqlxm.LogStatus, duration time.Duration) {
+	c.total.WithLabelValues(status.String()).Inc()
+	c.duration.WithLabelValues(name).Observe(duration.Seconds())
+	c.lastRun.SetToCurrentTime()
+}
+
+// OnBackendCall implements sqlxm.BackendMetricsCollector.
+func (c *Collector) OnBackendCall(method string, status sqlxm.LogStatus, duration time.Duration) {
+	c.backend.WithLabelValues(method, status.String()).Observe(duration.Seconds())
+}
@@ -0,0 +1,233 @@
+package sqlxmtest
+
+import (
+	"context"
+	"time"
+
+	"github.com/danielmorell/sqlxm/backends"
+	"github.com/jmoiron/sqlx"
+)
+
+// MockBackend is a backends.Backend for tests. Each method calls the matching
+// hook if it is set, otherwise it does nothing and returns zero values. Register
+// it with sqlxm.RegisterBackend and select it with Migrator.UseBackend.
+//
+//	b := &sqlxmtest.MockBackend{
+//	    HasMigrationTableFunc: func() (bool, error) { return true, nil },
+//	}
+//	err := sqlxm.RegisterBackend("mock", b)
+type MockBackend struct {
+	SetupFunc                       func(db *sqlx.DB, table string, tableSchema string)
+	InsertRecordFunc                func(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error
+	InsertRecordContextFunc         func(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error
+	InsertRecordWithApplierFunc     func(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...backends.ExtraField) error
+	HasMigrationTableFunc           func() (bool, error)
+	HasMigrationTableContextFunc    func(ctx context.Context) (bool, error)
+	QueryPreviousFunc               func() (map[string]string, error)
+	QueryPreviousContextFunc        func(ctx context.Context) (map[string]string, error)
+	CreateMigrationTableFunc        func() (string, error)
+	CreateMigrationTableContextFunc func(ctx context.Context) (string, error)
+	RepairHashesFunc                func(tx *sqlx.Tx, hashes map[string]string) error
+	OverrideColumnsFunc             func(columns map[string]string)
+	ExtendColumnsFunc               func(columns []backends.RecordColumn)
+	AddColumnsFunc                  func(ctx context.Context, columns []backends.RecordColumn) (string, error)
+	MigrateMigrationTableFunc       func() error
+	PingFunc                        func(ctx context.Context) error
+	ListTablesFunc                  func() ([]string, error)
+	QueryRecordsFunc                func(q sqlx.Queryer) ([]backends.MigrationRecord, error)
+	CountRecordsFunc                func(ctx context.Context) (int, error)
+	QueryChecksumFunc               func() (string, error)
+	StoreChecksumFunc               func(tx *sqlx.Tx, checksum string) error
+	DeleteRecordFunc                func(tx *sqlx.Tx, name string) error
+	UpdateCommentFunc               func(tx *sqlx.Tx, name string, comment string) error
+	CleanTableFunc                  func() error
+	ArchiveRecordsFunc              func(tx *sqlx.Tx, before time.Time) error
+	LockFunc                        func(ctx context.Context, timeout time.Duration) error
+	UnlockFunc                      func(ctx context.Context) error
+}
+
+func (b *MockBackend) Setup(db *sqlx.DB, table string, tableSchema string) {
+	if b.SetupFunc != nil {
+		b.SetupFunc(db, table, tableSchema)
+	}
+}
+
+func (b *MockBackend) InsertRecord(tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	if b.InsertRecordFunc != nil {
+		return b.InsertRecordFunc(tx, name, hash, comment, executionMs)
+	}
+	return nil
+}
+
+func (b *MockBackend) InsertRecordContext(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64) error {
+	if b.InsertRecordContextFunc != nil {
+		return b.InsertRecordContextFunc(ctx, tx, name, hash, comment, executionMs)
+	}
+	return nil
+}
+
+func (b *MockBackend) InsertRecordWithApplier(ctx context.Context, tx *sqlx.Tx, name string, hash string, comment string, executionMs int64, appliedBy string, extra ...backends.ExtraField) error {
+	if b.InsertRecordWithApplierFunc != nil {
+		return b.InsertRecordWithApplierFunc(ctx, tx, name, hash, comment, executionMs, appliedBy, extra...)
+	}
+	return nil
+}
+
+func (b *MockBackend) HasMigrationTable() (bool, error) {
+	if b.HasMigrationTableFunc != nil {
+		return b.HasMigrationTableFunc()
+	}
+	return false, nil
+}
+
+func (b *MockBackend) HasMigrationTableContext(ctx context.Context) (bool, error) {
+	if b.HasMigrationTableContextFunc != nil {
+		return b.HasMigrationTableContextFunc(ctx)
+	}
+	return false, nil
+}
+
+func (b *MockBackend) QueryPrevious() (map[string]string, error) {
+	if b.QueryPreviousFunc != nil {
+		return b.QueryPreviousFunc()
+	}
+	return make(map[string]string), nil
+}
+
+func (b *MockBackend) QueryPreviousContext(ctx context.Context) (map[string]string, error) {
+	if b.QueryPreviousContextFunc != nil {
+		return b.QueryPreviousContextFunc(ctx)
+	}
+	return make(map[string]string), nil
+}
+
+func (b *MockBackend) CreateMigrationTable() (string, error) {
+	if b.CreateMigrationTableFunc != nil {
+		return b.CreateMigrationTableFunc()
+	}
+	return "", nil
+}
+
+func (b *MockBackend) CreateMigrationTableContext(ctx context.Context) (string, error) {
+	if b.CreateMigrationTableContextFunc != nil {
+		return b.CreateMigrationTableContextFunc(ctx)
+	}
+	return "", nil
+}
+
+func (b *MockBackend) RepairHashes(tx *sqlx.Tx, hashes map[string]string) error {
+	if b.RepairHashesFunc != nil {
+		return b.RepairHashesFunc(tx, hashes)
+	}
+	return nil
+}
+
+func (b *MockBackend) OverrideColumns(columns map[string]string) {
+	if b.OverrideColumnsFunc != nil {
+		b.OverrideColumnsFunc(columns)
+	}
+}
+
+func (b *MockBackend) ExtendColumns(columns []backends.RecordColumn) {
+	if b.ExtendColumnsFunc != nil {
+		b.ExtendColumnsFunc(columns)
+	}
+}
+
+func (b *MockBackend) AddColumns(ctx context.Context, columns []backends.RecordColumn) (string, error) {
+	if b.AddColumnsFunc != nil {
+		return b.AddColumnsFunc(ctx, columns)
+	}
+	return "", nil
+}
+
+func (b *MockBackend) MigrateMigrationTable() error {
+	if b.MigrateMigrationTableFunc != nil {
+		return b.MigrateMigrationTableFunc()
+	}
+	return nil
+}
+
+func (b *MockBackend) Ping(ctx context.Context) error {
+	if b.PingFunc != nil {
+		return b.PingFunc(ctx)
+	}
+	return nil
+}
+
+func (b *MockBackend) ListTables() ([]string, error) {
+	if b.ListTablesFunc != nil {
+		return b.ListTablesFunc()
+	}
+	return nil, nil
+}
+
+func (b *MockBackend) QueryRecords(q sqlx.Queryer) ([]backends.MigrationRecord, error) {
+	if b.QueryRecordsFunc != nil {
+		return b.QueryRecordsFunc(q)
+	}
+	return nil, nil
+}
+
+func (b *MockBackend) CountRecords(ctx context.Context) (int, error) {
+	if b.CountRecordsFunc != nil {
+		return b.CountRecordsFunc(ctx)
+	}
+	return 0, nil
+}
+
+func (b *MockBackend) QueryChecksum() (string, error) {
+	if b.QueryChecksumFunc != nil {
+		return b.QueryChecksumFunc()
+	}
+	return "", nil
+}
+
+func (b *MockBackend) StoreChecksum(tx *sqlx.Tx, checksum string) error {
+	if b.StoreChecksumFunc != nil {
+		return b.StoreChecksumFunc(tx, checksum)
+	}
+	return nil
+}
+
+func (b *MockBackend) DeleteRecord(tx *sqlx.Tx, name string) error {
+	if b.DeleteRecordFunc != nil {
+		return b.DeleteRecordFunc(tx, name)
+	}
+	return nil
+}
+
+func (b *MockBackend) UpdateComment(tx *sqlx.Tx, name string, comment string) error {
+	if b.UpdateCommentFunc != nil {
+		return b.UpdateCommentFunc(tx, name, comment)
+	}
+	return nil
+}
+
+func (b *MockBackend) CleanTable() error {
+	if b.CleanTableFunc != nil {
+		return b.CleanTableFunc()
+	}
+	return nil
+}
+
+func (b *MockBackend) ArchiveRecords(tx *sqlx.Tx, before time.Time) error {
+	if b.ArchiveRecordsFunc != nil {
+		return b.ArchiveRecordsFunc(tx, before)
+	}
+	return nil
+}
+
+func (b *MockBackend) Lock(ctx context.Context, timeout time.Duration) error {
+	if b.LockFunc != nil {
+		return b.LockFunc(ctx, timeout)
+	}
+	return nil
+}
+
+func (b *MockBackend) Unlock(ctx context.Context) error {
+	if b.UnlockFunc != nil {
+		return b.UnlockFunc(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Package sqlxmtest has helpers for testing code that uses a sqlxm.Migrator
+// without a real database server.
+package sqlxmtest
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/danielmorell/sqlxm"
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+)
+
+// The number of in-memory databases created, used to give each a unique name.
+var memoryDBs int64
+
+// NewInMemory returns a Migrator using a new in-memory SQLite database, and a
+// function to close the database when the test is done.
+//
+//	m, done := sqlxmtest.NewInMemory()
+//	defer done()
+func NewInMemory() (*sqlxm.Migrator, func()) {
+	// A shared cache lets every connection in the pool use the same database.
+	dsn := fmt.Sprintf("file:sqlxmtest-%d?mode=memory&cache=shared", atomic.AddInt64(&memoryDBs, 1))
+	db, err := sqlx.Open("sqlite", dsn)
+	if err != nil {
+		panic(fmt.Sprintf("sqlxmtest: open in-memory DB failed: %s", err))
+	}
+	// The database is deleted when the last connection is closed, so keep one
+	// open until done is called.
+	db.SetMaxIdleConns(4)
+	db.SetConnMaxLifetime(0)
+
+	m, err := sqlxm.New(db, sqlxm.DefaultTableName, "")
+	if err != nil {
+		panic(fmt.Sprintf("sqlxmtest: create migrator failed: %s", err))
+	}
+	return &m, func() {
+		db.Close()
+	}
+}
+
+// AssertMigrated fails the test if the named migration has not been applied.
+func AssertMigrated(t testing.TB, m *sqlxm.Migrator, name string) {
+	t.Helper()
+	s, ok := migrationStatus(t, m, name)
+	if ok && !s.Applied {
+		t.Errorf("migration '%s' should be applied", name)
+	}
+}
+
+// AssertNotMigrated fails the test if the named migration has been applied.
+func AssertNotMigrated(t testing.TB, m *sqlxm.Migrator, name string) {
+	t.Helper()
+	s, ok := migrationStatus(t, m, name)
+	if ok && s.Applied {
+		t.Errorf("migration '%s' should not be applied", name)
+	}
+}
+
+// AssertAllApplied stops the test if any added migration has not been applied,
+// or if its stored hash doesn't match. Orphan records of migrations that are no
+// longer added are ignored.
+func AssertAllApplied(t testing.TB, m *sqlxm.Migrator) {
+	t.Helper()
+	status, err := m.Status()
+	if err != nil {
+		t.Fatalf("get migration status failed: %s", err)
+	}
+	failed := make([]string, 0)
+	for _, s := range status {
+		if s.Orphan {
+			continue
+		}
+		if !s.Applied || !s.HashMatch {
+			failed = append(failed, fmt.Sprintf("%s (%s)", s.Name, s))
+		}
+	}
+	if len(failed) > 0 {
+		t.Fatalf("all migrations should be applied: %s", strings.Join(failed, ", "))
+	}
+}
+
+// AssertNoPendingMigrations stops the test if any added migration has not been
+// applied.
+func AssertNoPendingMigrations(t testing.TB, m *sqlxm.Migrator) {
+	t.Helper()
+	pending, err := m.PendingCount()
+	if err != nil {
+		t.Fatalf("count pending migrations failed: %s", err)
+	}
+	if pending > 0 {
+		t.Fatalf("there should be no pending migrations, got %d", pending)
+	}
+}
+
+// migrationStatus returns the status of the named migration. The test fails if
+// the status can't be found.
+func migrationStatus(t testing.TB, m *sqlxm.Migrator, name string) (sqlxm.MigrationStatus, bool) {
+	t.Helper()
+	status, err := m.Status()
+	if err != nil {
+		t.Errorf("get migration status failed: %s", err)
+		return sqlxm.MigrationStatus{}, false
+	}
+	for _, s := range status {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	t.Errorf("migration '%s' has not been added", name)
+	return sqlxm.MigrationStatus{}, false
+}
@@ -0,0 +1,98 @@
+package sqlxmtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/danielmorell/sqlxm"
+	"github.com/danielmorell/sqlxm/backends"
+	"github.com/jmoiron/sqlx"
+)
+
+// Make sure MockBackend stays in sync with the Backend interface.
+var _ backends.Backend = &MockBackend{}
+
+func TestNewInMemory(t *testing.T) {
+	m, done := NewInMemory()
+	defer done()
+
+	err := m.AddMigrationWithRollback("create_user_table", "", `CREATE TABLE users (id INT);`, `DROP TABLE users;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.MigrateTo("create_user_table")
+	if err != nil {
+		t.Fatalf("migrate to error: %s", err)
+	}
+	AssertMigrated(t, m, "create_user_table")
+	AssertNotMigrated(t, m, "create_post_table")
+
+	_, err = m.RollbackLast(1)
+	if err != nil {
+		t.Fatalf("rollback error: %s", err)
+	}
+	AssertNotMigrated(t, m, "create_user_table")
+
+	// Each in-memory DB is separate.
+	other, otherDone := NewInMemory()
+	defer otherDone()
+	status, err := other.Status()
+	if err != nil || len(status) != 0 {
+		t.Errorf("in-memory DBs should be separate: %v %v", status, err)
+	}
+}
+
+func TestAssertAllApplied(t *testing.T) {
+	m, done := NewInMemory()
+	defer done()
+
+	err := m.AddMigration("create_user_table", "", `CREATE TABLE users (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("create_post_table", "", `CREATE TABLE posts (id INT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	AssertAllApplied(t, m)
+	AssertNoPendingMigrations(t, m)
+}
+
+func TestMockBackend(t *testing.T) {
+	m, done := NewInMemory()
+	defer done()
+
+	inserted := ""
+	err := sqlxm.RegisterBackend("sqlxmtest_mock", &MockBackend{
+		InsertRecordWithApplierFunc: func(_ context.Context, _ *sqlx.Tx, name string, _ string, _ string, _ int64, _ string, _ ...backends.ExtraField) error {
+			inserted = name
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.UseBackend("sqlxmtest_mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.AddMigration("select_one", "", `SELECT 1;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.RunStrict()
+	if err != nil {
+		t.Fatalf("migrator run error: %s", err)
+	}
+	if inserted != "select_one" {
+		t.Errorf("hook not called: got '%s'", inserted)
+	}
+}
@@ -0,0 +1,151 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MigrationStatus is the state of a single migration in the database.
+type MigrationStatus struct {
+	Name string `json:"name"`
+	// Hash is the hash of the registered migration. It is empty for orphans.
+	Hash string `json:"hash"`
+	// StoredHash is the hash stored in the migration table. It is empty if the
+	// migration has not been applied.
+	StoredHash string `json:"stored_hash"`
+	// Applied is true if the migration has a record in the migration table.
+	Applied bool `json:"applied"`
+	// AppliedAt is when the migration record was inserted. It is nil if the
+	// migration has not been applied.
+	AppliedAt *time.Time `json:"applied_at"`
+	// HashMatch is true if the migration has been applied, and the stored hash
+	// matches the migration hash or is repaired with RepairHash.
+	HashMatch bool `json:"hash_match"`
+	// Orphan is true if the migration has been applied but is no longer
+	// registered with the Migrator.
+	Orphan bool `json:"orphan"`
+}
+
+// String returns the state of the migration: "pending", "applied",
+// "hash mismatch" or "orphan".
+func (s MigrationStatus) String() string {
+	switch {
+	case s.Orphan:
+		return "orphan"
+	case !s.Applied:
+		return "pending"
+	case !s.HashMatch:
+		return "hash mismatch"
+	}
+	return "applied"
+}
+
+// Status returns the state of every registered migration in the order they
+// were added, followed by any orphan records in the migration table that don't
+// match a registered migration.
+//
+// Status only reads from the database and does not create the migration table.
+// If the table does not exist every migration is reported as not applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	err := m.loadSources()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(m.migrations))
+
+	exists, err := m.backend.HasMigrationTableContext(context.Background())
+	if err != nil {
+		return status, errTableSetup("the migration table check failed", err)
+	}
+	if !exists {
+		for _, mig := range m.migrations {
+			status = append(status, MigrationStatus{Name: mig.Name, Hash: mig.hash})
+		}
+		return status, nil
+	}
+
+	records, err := m.backend.QueryRecords(m.db)
+	if err != nil {
+		return status, fmt.Errorf("get migration records failed: %w", err)
+	}
+	applied := make(map[string]int, len(records))
+	for i, r := range records {
+		applied[r.Name] = i
+	}
+
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Name: mig.Name, Hash: mig.hash}
+		if i, ok := applied[mig.Name]; ok {
+			date := records[i].Date
+			s.StoredHash = records[i].Hash
+			s.Applied = true
+			s.AppliedAt = &date
+			s.HashMatch = s.StoredHash == s.Hash || m.hashRepaired(mig.Name)
+		}
+		status = append(status, s)
+	}
+
+	for _, r := range records {
+		if _, ok := m.names[r.Name]; ok {
+			continue
+		}
+		date := r.Date
+		status = append(status, MigrationStatus{
+			Name:       r.Name,
+			StoredHash: r.Hash,
+			Applied:    true,
+			AppliedAt:  &date,
+			Orphan:     true,
+		})
+	}
+	return status, nil
+}
+
+// AppliedCount returns the number of records in the migration table, including
+// orphan records of migrations that are no longer added. It is 0 if the table
+// does not exist. Like Status, the migrations don't need to have been run.
+func (m *Migrator) AppliedCount() (int, error) {
+	ctx := context.Background()
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil {
+		return 0, errTableSetup("the migration table check failed", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+	count, err := m.backend.CountRecords(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("count migration records failed: %w", err)
+	}
+	return count, nil
+}
+
+// PendingCount returns the number of added migrations that have no record in
+// the migration table. Orphan records don't reduce the count, so it is not
+// always the number of added migrations minus AppliedCount.
+func (m *Migrator) PendingCount() (int, error) {
+	ctx := context.Background()
+	err := m.loadSources()
+	if err != nil {
+		return 0, err
+	}
+	exists, err := m.backend.HasMigrationTableContext(ctx)
+	if err != nil {
+		return 0, errTableSetup("the migration table check failed", err)
+	}
+	if !exists {
+		return len(m.migrations), nil
+	}
+	prev, err := m.backend.QueryPreviousContext(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("get previous migrations failed: %w", err)
+	}
+	pending := 0
+	for _, mig := range m.migrations {
+		if _, ok := prev[mig.Name]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,43 @@
+package sqlxm
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// WithTemplateData renders each migration statement as a text/template with
+// data when it is added, for example to use environment specific values in a
+// seed migration.
+//
+//    m, err := sqlxm.New(db, "migrations", "public", sqlxm.WithTemplateData(map[string]string{
+//        "Bucket": os.Getenv("S3_BUCKET"),
+//    }))
+//    err = m.AddMigration("add_bucket", "", `INSERT INTO buckets (name) VALUES ('{{.Bucket}}');`)
+//
+// Template errors are returned when the migration is added. The hash is created
+// from the rendered statement, so different values create different hashes.
+func WithTemplateData(data interface{}) Option {
+	return func(m *Migrator) {
+		m.templateData = data
+		m.useTemplate = true
+	}
+}
+
+// renderStatement executes the statement as a template with the template data.
+// The statement is returned as it is if WithTemplateData is not used.
+func (m *Migrator) renderStatement(name string, statement string) (string, error) {
+	if !m.useTemplate {
+		return statement, nil
+	}
+	t, err := template.New(name).Option("missingkey=error").Parse(statement)
+	if err != nil {
+		return "", fmt.Errorf("migration '%s' template parse failed: %w", name, err)
+	}
+	var b strings.Builder
+	err = t.Execute(&b, m.templateData)
+	if err != nil {
+		return "", fmt.Errorf("migration '%s' template execute failed: %w", name, err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,44 @@
+package sqlxm
+
+import "context"
+
+// A Tracer starts the spans used to trace migration runs. See the sqlxmotel
+// package for an OpenTelemetry implementation.
+type Tracer interface {
+	// Start creates a span and a context containing it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is a single traced operation started by a Tracer.
+type Span interface {
+	// SetAttribute sets an attribute like "migration.name" on the span.
+	SetAttribute(key string, value string)
+	// SetError marks the span as failed with err.
+	SetError(err error)
+	// End completes the span.
+	End()
+}
+
+// WithTracer traces each run with a "sqlxm.Run" span, and each migration with
+// a "sqlxm.Migration" child span with the migration.name, migration.hash and
+// migration.status attributes.
+func WithTracer(t Tracer) Option {
+	return func(m *Migrator) {
+		m.tracer = t
+	}
+}
+
+// noopSpan is used when no Tracer has been set.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value string) {}
+func (noopSpan) SetError(err error)                    {}
+func (noopSpan) End()                                  {}
+
+// startSpan starts a span with the Tracer if one has been set.
+func (m *Migrator) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if m.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return m.tracer.Start(ctx, name)
+}
@@ -0,0 +1,143 @@
+package sqlxm
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/danielmorell/sqlxm/backends"
+	"github.com/jmoiron/sqlx"
+)
+
+// A TransactionManager begins, commits and rolls back the transactions
+// migrations are run in. A custom TransactionManager can wrap them with tracing
+// spans, write audit records, or hand them to a saga framework.
+//
+// Embed DefaultTransactionManager to only change some of the methods.
+type TransactionManager interface {
+	Begin(ctx context.Context, db *sqlx.DB) (*sqlx.Tx, error)
+	Commit(tx *sqlx.Tx) error
+	Rollback(tx *sqlx.Tx) error
+}
+
+// DefaultTransactionManager is the TransactionManager used when none is set.
+// It calls db.BeginTxx, tx.Commit and tx.Rollback.
+type DefaultTransactionManager struct{}
+
+// Begin starts a transaction with the default options.
+func (DefaultTransactionManager) Begin(ctx context.Context, db *sqlx.DB) (*sqlx.Tx, error) {
+	return db.BeginTxx(ctx, nil)
+}
+
+// Commit commits tx.
+func (DefaultTransactionManager) Commit(tx *sqlx.Tx) error {
+	return tx.Commit()
+}
+
+// Rollback rolls back tx.
+func (DefaultTransactionManager) Rollback(tx *sqlx.Tx) error {
+	return tx.Rollback()
+}
+
+// WithTransactionManager sets the TransactionManager used for the migration
+// transactions. A function set with WithCustomTransactionBegin is still used to
+// begin them.
+func WithTransactionManager(tm TransactionManager) Option {
+	return func(m *Migrator) {
+		if tm == nil {
+			tm = DefaultTransactionManager{}
+		}
+		m.txManager = tm
+	}
+}
+
+// CaptureNotices writes the notices the database sends while the migration
+// transactions are run to w, e.g. the Postgres NOTICE messages of RAISE NOTICE
+// or CREATE TABLE IF NOT EXISTS. Each notice is written as a
+// "SEVERITY: message" line.
+//
+// Notices are only captured by the Postgres backend with the lib/pq driver, and
+// the option is ignored by the other backends. They are not captured when a
+// custom TransactionManager or begin function is used, or for migrations that
+// are run outside of the transaction.
+func CaptureNotices(w io.Writer) Option {
+	return func(m *Migrator) {
+		m.notices = w
+	}
+}
+
+// begin starts the migration transaction using the custom begin function if
+// one has been set, or the TransactionManager.
+func (m *Migrator) begin(ctx context.Context) (*sqlx.Tx, error) {
+	if m.beginTx != nil {
+		return m.beginTx(ctx)
+	}
+	if _, ok := m.txManager.(DefaultTransactionManager); ok && m.notices != nil {
+		return m.beginWatched(ctx)
+	}
+	return m.txManager.Begin(ctx, m.db)
+}
+
+// beginWatched starts the migration transaction on a connection the backend
+// captures the notices of. The connection is released when the transaction is
+// committed or rolled back.
+func (m *Migrator) beginWatched(ctx context.Context) (*sqlx.Tx, error) {
+	n, ok := m.backend.(backends.NoticeCapturer)
+	if !ok {
+		return m.txManager.Begin(ctx, m.db)
+	}
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stop, err := n.WatchNotices(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	if m.noticeConns == nil {
+		m.noticeConns = make(map[*sqlx.Tx]func())
+	}
+	m.noticeConns[tx] = func() {
+		stop()
+		conn.Close()
+	}
+	return tx, nil
+}
+
+// releaseConn releases the connection tx was begun on by beginWatched.
+func (m *Migrator) releaseConn(tx *sqlx.Tx) {
+	if release, ok := m.noticeConns[tx]; ok {
+		delete(m.noticeConns, tx)
+		release()
+	}
+}
+
+// commitTx commits tx with the TransactionManager.
+func (m *Migrator) commitTx(tx *sqlx.Tx) error {
+	defer m.releaseConn(tx)
+	return m.txManager.Commit(tx)
+}
+
+// rollbackTx rolls back tx with the TransactionManager.
+func (m *Migrator) rollbackTx(tx *sqlx.Tx) error {
+	defer m.releaseConn(tx)
+	return m.txManager.Rollback(tx)
+}
+
+// failUncommitted sets the status of the SUCCESS entries of logs to ERROR when
+// the transaction they were run in fails to commit, since nothing was applied.
+func failUncommitted(logs []MigrationLog, err error) {
+	for i := range logs {
+		if logs[i].Status == SUCCESS {
+			logs[i].Status = ERROR
+			logs[i].Details = fmt.Sprintf("commit failed: %s", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package sqlxm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlMigration is a migration definition in a YAML file.
+type yamlMigration struct {
+	Name        string   `yaml:"name"`
+	Comment     string   `yaml:"comment"`
+	Statement   string   `yaml:"statement"`
+	Rollback    string   `yaml:"rollback"`
+	Tags        []string `yaml:"tags"`
+	Environment string   `yaml:"environment"`
+}
+
+// AddMigrationsFromYAML adds the migrations defined in YAML read from r. Each
+// document can be a single migration or a list of migrations.
+//
+//    - name: create_users_table
+//      comment: Add the users table.
+//      statement: CREATE TABLE users (id INT);
+//      rollback: DROP TABLE users;
+//      tags: [users]
+//      environment: production
+//
+// The name and statement fields are required. Invalid YAML and missing fields
+// return an error with the line number of the problem.
+func (m *Migrator) AddMigrationsFromYAML(r io.Reader) error {
+	return decodeYAMLMigrations(r, func(def MigrationDef, line int) error {
+		err := m.BatchAddMigration([]MigrationDef{def})
+		if err != nil {
+			return fmt.Errorf("migration YAML line %d: %w", line, err)
+		}
+		return nil
+	})
+}
+
+// decodeYAMLMigrations calls fn with each migration defined in YAML read from r,
+// and the line it is defined on.
+func decodeYAMLMigrations(r io.Reader, fn func(def MigrationDef, line int) error) error {
+	dec := yaml.NewDecoder(r)
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("parse migration YAML failed: %w", err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+
+		items := []*yaml.Node{doc.Content[0]}
+		if doc.Content[0].Kind == yaml.SequenceNode {
+			items = doc.Content[0].Content
+		}
+		for _, item := range items {
+			def, err := yamlMigrationDef(item)
+			if err != nil {
+				return err
+			}
+			err = fn(def, item.Line)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// yamlMigrationDef returns the migration defined by a YAML mapping node.
+func yamlMigrationDef(node *yaml.Node) (MigrationDef, error) {
+	var y yamlMigration
+	err := node.Decode(&y)
+	if err != nil {
+		return MigrationDef{}, fmt.Errorf("parse migration YAML failed: %w", err)
+	}
+	if y.Name == "" || y.Statement == "" {
+		return MigrationDef{}, fmt.Errorf("migration YAML line %d: name and statement are required", node.Line)
+	}
+	return MigrationDef{
+		Name:        y.Name,
+		Comment:     y.Comment,
+		Statement:   y.Statement,
+		Rollback:    y.Rollback,
+		Tags:        y.Tags,
+		Environment: y.Environment,
+	}, nil
+}